@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"compress/flate"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -23,16 +25,20 @@ var (
 
 // Message types
 const (
-	Publish   uint8 = iota // stream updated message, view update types below
-	Subscribe              // subscribe on a topic or many topics
-	Request                // request for some data
-	Response               // response on request
-	Ping                   // query weather other side is there
-	Pong                   // replay to ping
-	Alive                  // signal that server side is still alive
-	Current                // request for current state of a stream
-	Event                  // event stream, no cache
-	Meta                   // set session metadata
+	Publish         uint8 = iota // stream updated message, view update types below
+	Subscribe                    // subscribe on a topic or many topics
+	Request                      // request for some data
+	Response                     // response on request
+	Ping                         // query weather other side is there
+	Pong                         // replay to ping
+	Alive                        // signal that server side is still alive
+	Current                      // request for current state of a stream
+	Event                        // event stream, no cache
+	Meta                         // set session metadata
+	SubscribeAdd                 // add topics to the existing subscriptions
+	SubscribeRemove              // remove topics from the subscriptions
+	Direct                       // message for the sessions selected by Meta, between backend services
+	Hello                        // capability handshake, body is Capabilities
 )
 
 // Topic update types
@@ -123,12 +129,19 @@ type Error struct {
 	Code    int    `json:"c,omitempty"`
 }
 
+// Error implements error interface.
+func (e *Error) Error() string {
+	return e.Message
+}
+
 // Parse decodes Msg received from client.
+// Both json and msgpack encoded messages are recognized.
 func Parse(buf []byte) *Msg {
 	return parse(buf, jsonSerializer)
 }
 
 // ParseFromBackend parses the message received from another backend service.
+// Both json and msgpack encoded messages are recognized.
 func ParseFromBackend(buf []byte) *Msg {
 	return parse(buf, backendSerializer)
 }
@@ -137,6 +150,9 @@ func parse(buf []byte, serializer jsoniter.API) *Msg {
 	if buf == nil {
 		return nil
 	}
+	if isMsgpackMap(buf) {
+		return parseMsgpack(buf, serializer == backendSerializer)
+	}
 	parts := bytes.SplitN(buf, separator, 2)
 
 	m := &Msg{}
@@ -189,28 +205,29 @@ func Undeflate(data []byte) []byte {
 
 // Marshal the message that will be sent to the client.
 func (m *Msg) Marshal() []byte {
-	buf, _ := m.marshal(CompressionNone, CompatibilityVersionDefault, jsonSerializer)
+	buf, _ := m.marshal(CompressionNone, CompatibilityVersionDefault, CodecJSON, false)
 	return buf
 }
 
 // MarshalForBackend is used when marshaling Msg for backend to backend communication.
 func (m *Msg) MarshalForBackend() []byte {
-	buf, _ := m.marshal(CompressionNone, CompatibilityVersionDefault, backendSerializer)
+	buf, _ := m.marshal(CompressionNone, CompatibilityVersionDefault, CodecJSON, true)
 	return buf
 }
 
 // MarshalDeflate packs and compress message that will be sent to the client.
 func (m *Msg) MarshalDeflate() ([]byte, bool) {
-	return m.marshal(CompressionDeflate, CompatibilityVersionDefault, jsonSerializer)
+	return m.marshal(CompressionDeflate, CompatibilityVersionDefault, CodecJSON, false)
 }
 
 // marshal encodes message into []byte
-func (m *Msg) marshal(supportedCompression, version uint8, serializer jsoniter.API) ([]byte, bool) {
+func (m *Msg) marshal(supportedCompression, version, codec uint8, backend bool) ([]byte, bool) {
 	if version == CompatibilityVersion1 {
 		if m.UpdateType == BurstStart || m.UpdateType == BurstEnd {
 			// unsuported mesage types in this version
 			return nil, false
 		}
+		codec = CodecJSON // v1 clients understand only json
 	}
 	m.Lock()
 	defer m.Unlock()
@@ -220,12 +237,12 @@ func (m *Msg) marshal(supportedCompression, version uint8, serializer jsoniter.A
 		compression = CompressionNone
 	}
 	// check if we already have payload
-	key := payloadKey(compression, version)
+	key := payloadKey(compression, version, codec, backend)
 	if payload, ok := m.payloads[key]; ok {
 		return payload, compression != CompressionNone
 	}
 
-	payload := m.payload(version, serializer)
+	payload := m.payload(version, codec, backend)
 	// decide wather we need compression
 	if len(payload) < compressionLenLimit {
 		m.noCompression = true
@@ -244,11 +261,18 @@ func (m *Msg) marshal(supportedCompression, version uint8, serializer jsoniter.A
 	return payload, compression != CompressionNone
 }
 
-func (m *Msg) payload(version uint8, serializer jsoniter.API) []byte {
+func (m *Msg) payload(version, codec uint8, backend bool) []byte {
+	if codec == CodecMsgpack {
+		return m.msgpackPayload(backend)
+	}
 	var header []byte
 	if version == CompatibilityVersion1 {
 		header = m.marshalV1header()
 	} else {
+		serializer := jsonSerializer
+		if backend {
+			serializer = backendSerializer
+		}
 		header, _ = serializer.Marshal(m)
 	}
 	buf := bytes.NewBuffer(header)
@@ -263,8 +287,12 @@ func (m *Msg) payload(version uint8, serializer jsoniter.API) []byte {
 	return buf.Bytes()
 }
 
-func payloadKey(compression, version uint8) uint8 {
-	return version*4 + compression
+func payloadKey(compression, version, codec uint8, backend bool) uint8 {
+	key := codec*16 + version*4 + compression
+	if backend {
+		key += 8
+	}
+	return key
 }
 
 func deflate(src []byte) []byte {
@@ -294,6 +322,16 @@ func (m *Msg) Body() []byte {
 	return m.body
 }
 
+// RawBody returns message body, also for messages created with the body
+// object (NewPublish, Response...) which is marshaled on each call.
+func (m *Msg) RawBody() []byte {
+	if m.body != nil || m.src == nil {
+		return m.body
+	}
+	body, _ := m.src.MarshalJSON()
+	return body
+}
+
 // Response creates response message from original request
 func (m *Msg) Response(o interface{}) *Msg {
 	return &Msg{
@@ -336,14 +374,20 @@ func (m *Msg) ResponseTransportError(err error) *Msg {
 	}
 }
 
+// ResponseError creates response message with application error.
+// If err is (or wraps) *Error it is sent as is, keeping source and code.
 func (m *Msg) ResponseError(err error) *Msg {
+	var ae *Error
+	if !errors.As(err, &ae) {
+		ae = &Error{
+			Source:  ApplicationError,
+			Message: err.Error(),
+		}
+	}
 	return &Msg{
 		Type:          Response,
 		CorrelationID: m.CorrelationID,
-		Error: &Error{
-			Source:  ApplicationError,
-			Message: err.Error(),
-		},
+		Error:         ae,
 	}
 }
 
@@ -360,6 +404,39 @@ func (m *Msg) Request() *Msg {
 	}
 }
 
+// DeadlineHeader is BackendHeaders key with request deadline
+// in unix milliseconds, set by the requester.
+const DeadlineHeader = "deadline"
+
+// SetDeadline sets request deadline in BackendHeaders.
+// Headers map is copied, it may be shared with the original message.
+func (m *Msg) SetDeadline(deadline time.Time) {
+	h := make(map[string]string, len(m.BackendHeaders)+1)
+	for k, v := range m.BackendHeaders {
+		h[k] = v
+	}
+	h[DeadlineHeader] = strconv.FormatInt(deadline.UnixNano()/int64(time.Millisecond), 10)
+	m.BackendHeaders = h
+}
+
+// Deadline returns request deadline from BackendHeaders.
+func (m *Msg) Deadline() (time.Time, bool) {
+	ms, err := strconv.ParseInt(m.BackendHeaders[DeadlineHeader], 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), true
+}
+
+// NewRequest creates request message for the uri with body o
+func NewRequest(uri string, o interface{}) *Msg {
+	return &Msg{
+		Type: Request,
+		URI:  uri,
+		src:  toBodyMarshaler(o),
+	}
+}
+
 // Pong creates Pong for corresponding Ping
 func (m *Msg) Pong() *Msg {
 	return &Msg{
@@ -416,6 +493,30 @@ func NewPublish(topic, path string, ts int64, updateType uint8, o interface{}) *
 	}
 }
 
+// NewDirect creates message for all sessions with BackendHeaders key set to value.
+// Sessions get it as publish message with Event update type.
+func NewDirect(key, value, uri string, o interface{}) *Msg {
+	return &Msg{
+		Type:       Direct,
+		URI:        uri,
+		UpdateType: Event,
+		Meta:       map[string]string{key: value},
+		src:        toBodyMarshaler(o),
+	}
+}
+
+// Directed creates publish message sent to the sessions selected by Direct message.
+func (m *Msg) Directed() *Msg {
+	return &Msg{
+		Type:       Publish,
+		URI:        m.URI,
+		UpdateType: m.UpdateType,
+		Ts:         m.Ts,
+		body:       m.body,
+		src:        m.src,
+	}
+}
+
 func toBodyMarshaler(o interface{}) BodyMarshaler {
 	if t, ok := o.(BodyMarshaler); ok {
 		return t
@@ -481,6 +582,23 @@ func (m *Msg) AsReplay() *Msg {
 	}
 }
 
+// WithoutCacheDepth returns copy of the message without cache depth,
+// for clients which don't support it.
+func (m *Msg) WithoutCacheDepth() *Msg {
+	if m.CacheDepth == 0 {
+		return m
+	}
+	return &Msg{
+		Type:       m.Type,
+		URI:        m.URI,
+		UpdateType: m.UpdateType,
+		Replay:     m.Replay,
+		Ts:         m.Ts,
+		body:       m.body,
+		src:        m.src,
+	}
+}
+
 func (m *Msg) MetaResponse(newMeta map[string]string) *Msg {
 	return &Msg{
 		Type:          Meta,
@@ -2,6 +2,7 @@ package amp
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -121,6 +122,18 @@ func TestParseV1Subscribe(t *testing.T) {
 	assert.Equal(t, m.Subscriptions["sportsbook/d_174626231"], int64(10))
 }
 
+func TestParseV1SubscribeAddRemove(t *testing.T) {
+	m := ParseV1([]byte(`{"t":10,"u":[{"s":"m","n":5}]}`))
+	assert.NotNil(t, m)
+	assert.Equal(t, SubscribeAdd, m.Type)
+	assert.Equal(t, map[string]int64{"sportsbook/m": 5}, m.Subscriptions)
+
+	m = ParseV1([]byte(`{"t":11,"u":[{"s":"m"}]}`))
+	assert.NotNil(t, m)
+	assert.Equal(t, SubscribeRemove, m.Type)
+	assert.Equal(t, map[string]int64{"sportsbook/m": 0}, m.Subscriptions)
+}
+
 func TestParseV1Ping(t *testing.T) {
 	buf := `{"t":4}`
 	m := ParseV1([]byte(buf))
@@ -334,3 +347,28 @@ func TestMsg_MarshalForBackend(t *testing.T) {
 		})
 	}
 }
+
+func TestDeadline(t *testing.T) {
+	m := &Msg{BackendHeaders: map[string]string{"a": "b"}}
+	_, ok := m.Deadline()
+	assert.False(t, ok)
+
+	h := m.BackendHeaders
+	deadline := time.Now().Add(time.Second).Truncate(time.Millisecond)
+	m.SetDeadline(deadline)
+	assert.Len(t, h, 1) // original headers are not changed
+	p := ParseFromBackend(m.MarshalForBackend())
+	d, ok := p.Deadline()
+	assert.True(t, ok)
+	assert.True(t, deadline.Equal(d))
+	assert.Equal(t, "b", p.BackendHeaders["a"])
+}
+
+func TestRawBody(t *testing.T) {
+	m := NewPublish("a", "", 1, Full, map[string]int{"x": 1})
+	assert.Nil(t, m.Body())
+	assert.Equal(t, `{"x":1}`, string(m.RawBody()))
+	p := ParseFromBackend(m.MarshalForBackend())
+	assert.Equal(t, `{"x":1}`, string(p.RawBody()))
+	assert.Nil(t, (&Msg{}).RawBody())
+}
@@ -0,0 +1,107 @@
+package amptest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/amp/session"
+	"github.com/minus5/svckit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func echo(m *amp.Msg) (*amp.Msg, error) {
+	if m.Path() == "fail" {
+		return nil, errors.New("failed")
+	}
+	var o interface{}
+	if err := m.Unmarshal(&o); err != nil {
+		return nil, err
+	}
+	return m.Response(o), nil
+}
+
+func TestServer(t *testing.T) {
+	log.Discard()
+	srv := NewServer(t, echo)
+	defer srv.Close()
+
+	srv.Publish(
+		amp.NewPublish("a", "", 1, amp.Full, map[string]int{"x": 1}),
+		amp.NewPublish("a", "", 2, amp.Diff, map[string]int{"x": 2}),
+	)
+	c := srv.Client(nil)
+	c.Subscribe(map[string]int64{"a": 0})
+	c.Expect(
+		amp.NewPublish("a", "", 1, amp.Full, map[string]int{"x": 1}),
+		amp.NewPublish("a", "", 2, amp.Diff, map[string]int{"x": 2}),
+	)
+
+	srv.Publish(amp.NewPublish("a", "", 3, amp.Diff, map[string]int{"x": 3}))
+	c.Expect(amp.NewPublish("a", "", 3, amp.Diff, map[string]int{"x": 3}))
+
+	id := c.Request("echo.req/x", map[string]int{"y": 1})
+	c.Expect((&amp.Msg{CorrelationID: id}).Response(map[string]int{"y": 1}))
+
+	id = c.Request("echo.req/fail", nil)
+	c.Expect((&amp.Msg{CorrelationID: id}).ResponseError(errors.New("failed")))
+
+	c.Meta(map[string]string{"user": "1"})
+	c.Expect(&amp.Msg{Type: amp.Meta, Meta: map[string]string{"user": "1"}})
+	id = c.Request("echo.req/x", 1)
+	c.Expect((&amp.Msg{CorrelationID: id}).Response(1))
+	reqs := srv.Requester.Requests()
+	assert.Len(t, reqs, 3)
+	assert.Equal(t, map[string]string{"user": "1"}, reqs[2].Meta)
+
+	c.ExpectNone(10 * time.Millisecond)
+}
+
+func TestServerAuthorizer(t *testing.T) {
+	log.Discard()
+	srv := NewServer(t, nil, Authorizer(session.TopicWhitelist([]string{"math.req"})))
+	defer srv.Close()
+
+	c := srv.Client(nil)
+	id := c.Request("chat.req/x", nil)
+	m := c.Next()
+	assert.Equal(t, id, m.CorrelationID)
+	assert.NotNil(t, m.Error)
+
+	id = c.Request("math.req/x", nil)
+	c.Expect((&amp.Msg{CorrelationID: id}).ResponseError(ErrNoHandler))
+	assert.Len(t, srv.Requester.Requests(), 1)
+}
+
+func TestServerRegistry(t *testing.T) {
+	log.Discard()
+	reg := session.NewRegistry("user")
+	srv := NewServer(t, nil, Registry(reg))
+	defer srv.Close()
+
+	c1 := srv.ClientWithBackendHeaders(nil, map[string]string{"user": "1"})
+	c2 := srv.ClientWithBackendHeaders(nil, map[string]string{"user": "2"})
+	c3 := srv.Client(map[string]string{"user": "1"}) // client set meta is not indexed
+	c3.Meta(map[string]string{"user": "2"})
+	c3.Expect(&amp.Msg{Type: amp.Meta, Meta: map[string]string{"user": "2"}})
+	c1.Meta(map[string]string{"lang": "hr"}) // wait for the session start
+	c1.Expect(&amp.Msg{Type: amp.Meta, Meta: map[string]string{"lang": "hr"}})
+	c2.Meta(map[string]string{"lang": "en"})
+	c2.Expect(&amp.Msg{Type: amp.Meta, Meta: map[string]string{"lang": "en"}})
+	assert.Equal(t, 2, reg.Len())
+
+	assert.Equal(t, 1, reg.Send(amp.NewDirect("user", "2", "notify", map[string]int{"x": 1})))
+	c2.Expect(amp.NewPublish("notify", "", 0, amp.Event, map[string]int{"x": 1}))
+	assert.Equal(t, 1, reg.Send(amp.NewDirect("user", "1", "notify", map[string]int{"x": 2})))
+	c1.Expect(amp.NewPublish("notify", "", 0, amp.Event, map[string]int{"x": 2}))
+	assert.Equal(t, 0, reg.Send(amp.NewDirect("user", "3", "notify", nil)))
+	c1.ExpectNone(10 * time.Millisecond)
+	c2.ExpectNone(10 * time.Millisecond)
+	c3.ExpectNone(10 * time.Millisecond)
+
+	// closed session is removed from the registry
+	c2.Close()
+	assert.Equal(t, 1, reg.Len())
+	assert.Equal(t, 0, reg.Send(amp.NewDirect("user", "2", "notify", nil)))
+}
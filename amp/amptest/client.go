@@ -0,0 +1,140 @@
+package amptest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/stretchr/testify/require"
+)
+
+// DefaultTimeout is max time Client waits for the next message.
+var DefaultTimeout = time.Second
+
+// Client is scriptable amp client connected to the session through Conn.
+// It sends client messages and asserts messages it receives from the session.
+type Client struct {
+	Conn    *Conn
+	Timeout time.Duration
+
+	t             testing.TB
+	correlationID uint64
+	done          chan struct{}
+}
+
+// NewClient starts serve (usually session.Sessions Serve) for the new
+// connection with session meta.
+func NewClient(t testing.TB, serve func(*Conn), meta map[string]string) *Client {
+	c := &Client{
+		Conn:    NewConn(meta),
+		Timeout: DefaultTimeout,
+		t:       t,
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(c.done)
+		serve(c.Conn)
+	}()
+	return c
+}
+
+// Send sends message to the session.
+func (c *Client) Send(m *amp.Msg) {
+	c.Conn.Push(m)
+}
+
+// Subscribe sends subscribe message.
+func (c *Client) Subscribe(topics map[string]int64) {
+	c.Send(&amp.Msg{Type: amp.Subscribe, Subscriptions: topics})
+}
+
+// Meta sends meta message.
+func (c *Client) Meta(meta map[string]string) {
+	c.Send(&amp.Msg{Type: amp.Meta, Meta: meta})
+}
+
+// Request sends request with body o to the uri,
+// returns correlation id of the request.
+func (c *Client) Request(uri string, o interface{}) uint64 {
+	c.correlationID++
+	m := amp.NewRequest(uri, o)
+	m.CorrelationID = c.correlationID
+	c.Send(m)
+	return m.CorrelationID
+}
+
+// Next returns next message from the session, alive messages are skipped.
+// Fails test if there is no message in Timeout.
+func (c *Client) Next() *amp.Msg {
+	c.t.Helper()
+	timeout := time.After(c.Timeout)
+	for {
+		select {
+		case buf := <-c.Conn.Out():
+			m := amp.Parse(buf)
+			require.NotNil(c.t, m, "can't parse message %s", buf)
+			if m.IsAlive() {
+				continue
+			}
+			return m
+		case <-timeout:
+			require.FailNow(c.t, "timeout waiting for message")
+			return nil
+		}
+	}
+}
+
+// Expect asserts that the next messages match want messages, in order.
+// Header fields are compared, and body if want message has one.
+func (c *Client) Expect(want ...*amp.Msg) []*amp.Msg {
+	c.t.Helper()
+	got := make([]*amp.Msg, 0, len(want))
+	for i, w := range want {
+		m := c.Next()
+		Match(c.t, w, m, "message %d", i)
+		got = append(got, m)
+	}
+	return got
+}
+
+// ExpectNone asserts that there is no message for the duration d.
+func (c *Client) ExpectNone(d time.Duration) {
+	c.t.Helper()
+	timeout := time.After(d)
+	for {
+		select {
+		case buf := <-c.Conn.Out():
+			m := amp.Parse(buf)
+			if m != nil && m.IsAlive() {
+				continue
+			}
+			require.FailNow(c.t, "unexpected message", "%s", buf)
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// Close closes connection and waits for the session to finish.
+func (c *Client) Close() {
+	c.Conn.Close()
+	<-c.done
+}
+
+// Match asserts that got message has the same header fields as want,
+// and the same json body if want has body.
+func Match(t testing.TB, want, got *amp.Msg, msgAndArgs ...interface{}) {
+	t.Helper()
+	require.NotNil(t, got, msgAndArgs...)
+	require.Equal(t, want.Type, got.Type, msgAndArgs...)
+	require.Equal(t, want.URI, got.URI, msgAndArgs...)
+	require.Equal(t, want.Ts, got.Ts, msgAndArgs...)
+	require.Equal(t, want.UpdateType, got.UpdateType, msgAndArgs...)
+	require.Equal(t, want.Replay, got.Replay, msgAndArgs...)
+	require.Equal(t, want.CorrelationID, got.CorrelationID, msgAndArgs...)
+	require.Equal(t, want.Error, got.Error, msgAndArgs...)
+	require.Equal(t, want.Meta, got.Meta, msgAndArgs...)
+	if wb := amp.Parse(want.Marshal()).Body(); len(wb) > 0 {
+		require.JSONEq(t, string(wb), string(got.Body()), msgAndArgs...)
+	}
+}
@@ -0,0 +1,139 @@
+package amptest
+
+import (
+	"io"
+	"sync"
+
+	"github.com/minus5/svckit/amp"
+)
+
+// Conn is in memory client connection.
+// Session side uses it as websocket connection (session.Sessions Serve),
+// test side pushes client messages with Push and reads session output with Out.
+type Conn struct {
+	in             chan []byte
+	out            chan []byte
+	closed         chan struct{}
+	closeOnce      sync.Once
+	meta           map[string]string
+	headers        map[string]string
+	backendHeaders map[string]string
+	binary         bool
+	sync.Mutex
+}
+
+// NewConn creates connection with session meta (query string of the websocket url).
+func NewConn(meta map[string]string) *Conn {
+	if meta == nil {
+		meta = make(map[string]string)
+	}
+	return &Conn{
+		in:      make(chan []byte, 1024),
+		out:     make(chan []byte, 1024),
+		closed:  make(chan struct{}),
+		meta:    meta,
+		headers: make(map[string]string),
+	}
+}
+
+// Push sends client message to the session.
+func (c *Conn) Push(m *amp.Msg) {
+	select {
+	case c.in <- m.Marshal():
+	case <-c.closed:
+	}
+}
+
+// Out returns messages written by the session.
+func (c *Conn) Out() <-chan []byte {
+	return c.out
+}
+
+// Closed is closed when connection is closed.
+func (c *Conn) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// Read implements session connection interface.
+func (c *Conn) Read() ([]byte, error) {
+	select {
+	case buf := <-c.in:
+		return buf, nil
+	case <-c.closed:
+		return nil, io.EOF
+	}
+}
+
+// Write implements session connection interface.
+func (c *Conn) Write(payload []byte, deflated bool) error {
+	if deflated {
+		payload = amp.Undeflate(payload)
+	}
+	select {
+	case c.out <- payload:
+		return nil
+	case <-c.closed:
+		return io.ErrClosedPipe
+	}
+}
+
+// SetBinary implements session connection interface.
+func (c *Conn) SetBinary(b bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.binary = b
+}
+
+// BinarySupported implements session connection interface.
+func (c *Conn) BinarySupported() bool { return true }
+
+// DeflateSupported implements session connection interface.
+func (c *Conn) DeflateSupported() bool { return false }
+
+// Headers implements session connection interface.
+func (c *Conn) Headers() map[string]string { return c.headers }
+
+// SetBackendHeaders implements session connection interface.
+func (c *Conn) SetBackendHeaders(h map[string]string) {
+	c.Lock()
+	defer c.Unlock()
+	c.backendHeaders = h
+}
+
+// GetBackendHeaders implements session connection interface.
+func (c *Conn) GetBackendHeaders() map[string]string {
+	c.Lock()
+	defer c.Unlock()
+	return c.backendHeaders
+}
+
+// No implements session connection interface.
+func (c *Conn) No() uint64 { return 0 }
+
+// Close closes connection, session reading from it is finished.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+// Meta implements session connection interface.
+func (c *Conn) Meta() map[string]string {
+	c.Lock()
+	defer c.Unlock()
+	return c.meta
+}
+
+// SetMeta implements session connection interface.
+func (c *Conn) SetMeta(meta map[string]string) {
+	c.Lock()
+	defer c.Unlock()
+	for k, v := range meta {
+		c.meta[k] = v
+	}
+}
+
+// GetRemoteIp implements session connection interface.
+func (c *Conn) GetRemoteIp() string { return "127.0.0.1" }
+
+// GetCookie implements session connection interface.
+func (c *Conn) GetCookie() string { return "" }
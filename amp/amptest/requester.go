@@ -0,0 +1,65 @@
+package amptest
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/minus5/svckit/amp"
+)
+
+// ErrNoHandler is response error of the requester without handler.
+var ErrNoHandler = errors.New("no handler")
+
+// Requester is in memory pair of amp/nsq Requester and Responder.
+// Requests are handled synchronously by the handler (same signature as
+// amp/nsq Responder handler, router.Router Serve can be used),
+// messages are encoded as between backend services.
+type Requester struct {
+	handler  func(m *amp.Msg) (*amp.Msg, error)
+	requests []*amp.Msg
+	sync.Mutex
+}
+
+// NewRequester creates requester which calls handler for each request.
+func NewRequester(handler func(m *amp.Msg) (*amp.Msg, error)) *Requester {
+	return &Requester{handler: handler}
+}
+
+// Send implements session requester interface.
+func (r *Requester) Send(s amp.Subscriber, m *amp.Msg) {
+	req := amp.ParseFromBackend(m.Request().MarshalForBackend())
+	r.Lock()
+	r.requests = append(r.requests, req)
+	r.Unlock()
+
+	var rm *amp.Msg
+	var err error
+	if r.handler == nil {
+		err = ErrNoHandler
+	} else {
+		rm, err = r.handler(req)
+	}
+	if err != nil {
+		rm = req.ResponseError(err)
+	}
+	if rm == nil {
+		return
+	}
+	rsp := amp.ParseFromBackend(rm.MarshalForBackend())
+	rsp.CorrelationID = m.CorrelationID
+	s.Send(rsp)
+}
+
+// Requests returns all requests received so far, as seen by the handler.
+func (r *Requester) Requests() []*amp.Msg {
+	r.Lock()
+	defer r.Unlock()
+	return append([]*amp.Msg(nil), r.requests...)
+}
+
+// Unsubscribe implements session requester interface.
+// Requests are handled synchronously so there is nothing to cancel.
+func (r *Requester) Unsubscribe(amp.Subscriber) {}
+
+// Wait implements session requester interface.
+func (r *Requester) Wait() {}
@@ -0,0 +1,126 @@
+// Package amptest provides in memory harness for testing amp services.
+//
+// Server connects real broker and sessions with in memory requester,
+// Client is scripted client connected to the session through in memory
+// Conn. Typical test:
+//
+//	srv := amptest.NewServer(t, rtr.Serve)
+//	defer srv.Close()
+//	srv.Publish(amp.NewPublish("a", "", 1, amp.Full, state))
+//	c := srv.Client(nil)
+//	c.Subscribe(map[string]int64{"a": 0})
+//	c.Expect(amp.NewPublish("a", "", 1, amp.Full, state))
+package amptest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/amp/broker"
+	"github.com/minus5/svckit/amp/session"
+)
+
+// Server is real broker and sessions factory with in memory requester.
+type Server struct {
+	Broker    *broker.Broker
+	Requester *Requester
+	Sessions  *session.Sessions
+
+	t          testing.TB
+	authorizer session.Authorizer
+	overflow   *session.OverflowPolicy
+	registry   *session.Registry
+	cancel     func()
+	in         chan *amp.Msg // closing it closes broker
+	clients    []*Client
+}
+
+// Authorizer sets sessions authorizer, default allows all messages.
+func Authorizer(a session.Authorizer) func(*Server) {
+	return func(s *Server) {
+		s.authorizer = a
+	}
+}
+
+// Overflow sets sessions slow consumer strategies.
+func Overflow(p session.OverflowPolicy) func(*Server) {
+	return func(s *Server) {
+		s.overflow = &p
+	}
+}
+
+// Registry sets sessions registry for directed messages,
+// use Registry Send to deliver Direct message.
+func Registry(r *session.Registry) func(*Server) {
+	return func(s *Server) {
+		s.registry = r
+	}
+}
+
+// NewServer creates server, requests are handled by handler.
+func NewServer(t testing.TB, handler func(m *amp.Msg) (*amp.Msg, error), opts ...func(*Server)) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		Broker:     broker.New(nil, nil),
+		Requester:  NewRequester(handler),
+		t:          t,
+		authorizer: session.AllowAll,
+		cancel:     cancel,
+		in:         make(chan *amp.Msg),
+	}
+	s.Broker.Consume(s.in)
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.Sessions = session.Factory(ctx, s.Broker, s.Requester, s.authorizer)
+	if s.overflow != nil {
+		s.Sessions.Overflow(*s.overflow)
+	}
+	if s.registry != nil {
+		s.Sessions.Registry(s.registry)
+	}
+	return s
+}
+
+// Publish publishes messages into broker and waits until they are processed,
+// so all subscribed clients get them.
+func (s *Server) Publish(msgs ...*amp.Msg) {
+	Publish(s.Broker, msgs...)
+}
+
+// Client connects new client with session meta.
+func (s *Server) Client(meta map[string]string) *Client {
+	c := NewClient(s.t, func(c *Conn) { s.Sessions.Serve(c) }, meta)
+	s.clients = append(s.clients, c)
+	return c
+}
+
+// ClientWithBackendHeaders connects new client with session meta and
+// server set backend headers (as set by the server after authentication).
+func (s *Server) ClientWithBackendHeaders(meta, headers map[string]string) *Client {
+	c := NewClient(s.t, func(c *Conn) {
+		c.SetBackendHeaders(headers)
+		s.Sessions.Serve(c)
+	}, meta)
+	s.clients = append(s.clients, c)
+	return c
+}
+
+// Close closes all clients, sessions and broker.
+func (s *Server) Close() {
+	for _, c := range s.clients {
+		c.Close()
+	}
+	close(s.in)
+	s.cancel()
+	s.Sessions.Wait()
+}
+
+// Publish publishes messages into broker and waits until they are processed.
+func Publish(b *broker.Broker, msgs ...*amp.Msg) {
+	for _, m := range msgs {
+		b.Publish(m)
+	}
+	b.Flush()
+}
@@ -9,7 +9,7 @@ type appendCache struct {
 
 func newAppendCache() *appendCache {
 	return &appendCache{
-		depth: 64,
+		depth: defaultCacheDepth,
 	}
 }
 
@@ -5,6 +5,7 @@
 package broker
 
 import (
+	"math"
 	"time"
 
 	"github.com/minus5/svckit/amp"
@@ -18,8 +19,14 @@ type Broker struct {
 	closed         chan struct{}
 	spreaders      map[string]*spreader
 	consumerNames  map[amp.Sender]map[string]int64
+	patterns       map[amp.Sender]map[string]int64
+	explicit       map[amp.Sender]map[string]struct{} // topics subscribed by name, for consumers with patterns
 	current        func(string)
 	expireDuration *time.Duration
+	policies       TopicPolicies
+	snapshotFile   string
+	snapshotTicker *time.Ticker
+	snapshotBusy   int32 // async snapshot save in progress
 }
 
 // Consume consumes all msgs from in channel.
@@ -37,16 +44,24 @@ func (s *Broker) Wait() {
 	<-s.closed
 }
 
-// New creates new scatter
-func New(current func(string), expireDuration *time.Duration) *Broker {
+// New creates new scatter.
+// Policies define sharding, cache and expiry per topic,
+// DefaultTopicPolicies are used if none is passed.
+func New(current func(string), expireDuration *time.Duration, policies ...TopicPolicy) *Broker {
+	if len(policies) == 0 {
+		policies = DefaultTopicPolicies
+	}
 	s := &Broker{
 		messages:       make(chan *amp.Msg, 1024),
 		loopWork:       make(chan func()),
 		closed:         make(chan struct{}),
 		spreaders:      make(map[string]*spreader),
 		consumerNames:  make(map[amp.Sender]map[string]int64),
+		patterns:       make(map[amp.Sender]map[string]int64),
+		explicit:       make(map[amp.Sender]map[string]struct{}),
 		current:        current,
 		expireDuration: expireDuration,
+		policies:       policies,
 	}
 	go s.loop()
 	return s
@@ -61,21 +76,26 @@ func copyMap(o map[string]int64) map[string]int64 {
 }
 
 // Replay collects all current messages.
+// Only spreaders are found in the broker loop, messages are collected
+// from the topic loops outside of it.
 func (s *Broker) Replay(name string) []*amp.Msg {
 	log.Debug("replay start")
-	var msgs []*amp.Msg
+	var sprs []*spreader
 	s.inLoopWait(func() {
 		if name == "" || name == "*" {
 			for _, spr := range s.spreaders {
-				msgs = append(msgs, spr.replay()...)
+				sprs = append(sprs, spr)
 			}
 			return
 		}
-		spr, ok := s.spreaders[name]
-		if ok {
-			msgs = append(msgs, spr.replay()...)
+		if spr, ok := s.spreaders[name]; ok {
+			sprs = append(sprs, spr)
 		}
 	})
+	var msgs []*amp.Msg
+	for _, spr := range sprs {
+		msgs = append(msgs, spr.replay()...)
+	}
 	log.I("msgs", len(msgs)).Debug("replay end")
 	return msgs
 }
@@ -85,11 +105,19 @@ func (s *Broker) Created(c amp.Sender) {}
 
 // Subscribe consumer to topics defined c.Topics()
 // amp.Sender should call this on each change ih his Topics list.
-func (s *Broker) Subscribe(c amp.Sender, newNames map[string]int64) {
-	metric.Time("broker.subscribe.len", len(newNames))
+//
+// Name ending with '*' is prefix pattern. Consumer is subscribed to all
+// existing topics with that prefix, and to each new one when it is created.
+// Existing topics are subscribed with the pattern ts (unless the topic
+// is also in the names with its own ts), new topics from the start.
+// Removing pattern unsubscribes all topics matched only by that pattern.
+// Session default authorizer rejects client patterns (session.TopicWhitelist).
+func (s *Broker) Subscribe(c amp.Sender, names map[string]int64) {
+	metric.Time("broker.subscribe.len", len(names))
 	s.inLoop(func() {
 		oldNames, ok := s.consumerNames[c]
-		s.consumerNames[c] = copyMap(newNames)
+		newNames := s.expandPatterns(c, names)
+		s.consumerNames[c] = newNames
 
 		if !ok {
 			for name, ts := range newNames {
@@ -127,18 +155,81 @@ func (s *Broker) Subscribe(c amp.Sender, newNames map[string]int64) {
 	})
 }
 
+// expandPatterns splits names to patterns and topic names,
+// returns topic names with all existing topics matching patterns added.
+func (s *Broker) expandPatterns(c amp.Sender, names map[string]int64) map[string]int64 {
+	topics := make(map[string]int64)
+	patterns := make(map[string]int64)
+	for name, ts := range names {
+		if amp.IsPattern(name) {
+			patterns[name] = ts
+			continue
+		}
+		topics[name] = ts
+	}
+	if len(patterns) == 0 {
+		delete(s.patterns, c)
+		delete(s.explicit, c)
+		return topics
+	}
+	s.patterns[c] = patterns
+	explicit := make(map[string]struct{})
+	for name := range topics {
+		explicit[name] = struct{}{}
+	}
+	s.explicit[c] = explicit
+	oldNames := s.consumerNames[c]
+	for name := range s.spreaders {
+		if _, ok := topics[name]; ok {
+			continue
+		}
+		ts, ok := amp.MatchPattern(patterns, name)
+		if !ok {
+			continue
+		}
+		if _, ok := oldNames[name]; ok {
+			// already subscribed, keep ts
+			ts = oldNames[name]
+		}
+		topics[name] = ts
+	}
+	return topics
+}
+
+// subscribePatterns subscribes consumers with patterns matching new topic
+func (s *Broker) subscribePatterns(name string, spr *spreader) {
+	for c, patterns := range s.patterns {
+		if _, ok := s.consumerNames[c][name]; ok {
+			continue
+		}
+		if _, ok := amp.MatchPattern(patterns, name); !ok {
+			continue
+		}
+		s.consumerNames[c][name] = 0
+		spr.subscribe(c, 0)
+	}
+}
+
+// unsubscribePatterns removes closed topic from consumers subscribed by pattern,
+// so they are subscribed again if topic is created later
+func (s *Broker) unsubscribePatterns(name string) {
+	for c, patterns := range s.patterns {
+		if _, ok := amp.MatchPattern(patterns, name); ok {
+			delete(s.consumerNames[c], name)
+		}
+	}
+}
+
 func (s *Broker) find(name string, currentOnNew bool) *spreader {
 	if spr, ok := s.spreaders[name]; ok {
 		return spr
 	}
 	start := time.Now()
-	topicCount := 1
-	switch name {
-	case "sportsbook/m", "sportsbook/i_hr":
-		topicCount = 16
-	}
-	spr := newSpreader(name, topicCount)
+	policy := s.policies.Find(name)
+	topicCount := policy.Shards
+	spr := newSpreader(name, policy)
 	s.spreaders[name] = spr
+	s.subscribePatterns(name, spr)
 	if currentOnNew && s.current != nil {
 		log.S("topic", name).I("count", topicCount).Debug("new top current")
 		go s.current(name)
@@ -154,6 +245,8 @@ func (s *Broker) Unsubscribe(c amp.Sender) {
 	s.inLoopWait(func() {
 		oldNames := s.consumerNames[c]
 		delete(s.consumerNames, c)
+		delete(s.patterns, c)
+		delete(s.explicit, c)
 		for name := range oldNames {
 			spr, ok := s.spreaders[name]
 			if !ok {
@@ -211,6 +304,10 @@ func (s *Broker) signalClose() {
 }
 
 func (s *Broker) close() {
+	s.saveSnapshot(false)
+	if s.snapshotTicker != nil {
+		s.snapshotTicker.Stop()
+	}
 	for _, spr := range s.spreaders {
 		spr.close()
 	}
@@ -234,6 +331,7 @@ func (s *Broker) loop() {
 			if m.IsTopicClose() {
 				log.S("topic", name).Info("delete from msg")
 				delete(s.spreaders, name)
+				s.unsubscribePatterns(name)
 				spr.close()
 			} else {
 				spr.publish(m)
@@ -246,11 +344,18 @@ func (s *Broker) loop() {
 		case <-ticker.C:
 			if s.expireDuration != nil {
 				s.removeExpired(*s.expireDuration)
+			} else if s.policies.expires() {
+				s.removeExpired(neverExpire)
 			}
+		case <-s.snapshotC():
+			s.saveSnapshot(true)
 		}
 	}
 }
 
+// neverExpire is used when only topics with policy expire period should be removed
+const neverExpire = time.Duration(math.MaxInt64)
+
 func (s *Broker) removeExpired(expireDuration time.Duration) {
 	for name, spr := range s.spreaders {
 		if spr.isExpired(expireDuration) {
@@ -275,6 +380,31 @@ func (s *Broker) wait(name string) {
 	}
 }
 
+// Flush waits until all published messages are processed by the topics,
+// so subscribers get them. Intended for tests.
+func (s *Broker) Flush() {
+	var sprs []*spreader
+	for drained := false; !drained; {
+		select {
+		case <-s.closed:
+			return
+		default:
+		}
+		s.inLoopWait(func() {
+			if len(s.messages) > 0 {
+				return
+			}
+			drained = true
+			for _, spr := range s.spreaders {
+				sprs = append(sprs, spr)
+			}
+		})
+	}
+	for _, spr := range sprs {
+		spr.wait()
+	}
+}
+
 func (s *Broker) waitClose() {
 	s.signalClose()
 	s.Wait()
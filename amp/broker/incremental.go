@@ -0,0 +1,152 @@
+package broker
+
+import (
+	"github.com/minus5/svckit/amp"
+)
+
+// SubscribeAdd adds names to the consumer subscriptions,
+// without recomputing the whole subscriptions map like Subscribe.
+// Names already subscribed are unchanged (ts is ignored).
+// Patterns are handled same as in Subscribe.
+func (s *Broker) SubscribeAdd(c amp.Sender, names map[string]int64) {
+	metric.Time("broker.subscribe.add.len", len(names))
+	s.inLoop(func() {
+		current, ok := s.consumerNames[c]
+		if !ok {
+			current = make(map[string]int64)
+			s.consumerNames[c] = current
+		}
+		topics := make(map[string]int64)
+		patterns := make(map[string]int64)
+		for name, ts := range names {
+			if amp.IsPattern(name) {
+				patterns[name] = ts
+				continue
+			}
+			topics[name] = ts
+		}
+
+		if len(patterns) > 0 {
+			s.addPatterns(c, current, patterns)
+			for name := range s.spreaders {
+				if _, ok := topics[name]; ok {
+					continue
+				}
+				if ts, ok := amp.MatchPattern(patterns, name); ok {
+					topics[name] = ts
+				}
+			}
+		}
+		if explicit, ok := s.explicit[c]; ok {
+			for name := range names {
+				if _, ok := topics[name]; ok {
+					explicit[name] = struct{}{}
+				}
+			}
+		}
+
+		for name, ts := range topics {
+			if _, ok := current[name]; ok {
+				continue
+			}
+			current[name] = ts
+			s.find(name, true).subscribe(c, ts)
+		}
+	})
+}
+
+// addPatterns adds patterns to the consumer patterns,
+// first pattern marks all current topics as subscribed by name
+func (s *Broker) addPatterns(c amp.Sender, current, patterns map[string]int64) {
+	cps, ok := s.patterns[c]
+	if !ok {
+		cps = make(map[string]int64)
+		s.patterns[c] = cps
+		explicit := make(map[string]struct{})
+		for name := range current {
+			explicit[name] = struct{}{}
+		}
+		s.explicit[c] = explicit
+	}
+	for pattern, ts := range patterns {
+		cps[pattern] = ts
+	}
+}
+
+// SubscribeRemove removes names from the consumer subscriptions.
+// Removing pattern unsubscribes topics matched only by that pattern,
+// topics subscribed by name or matched by other consumer patterns stay subscribed.
+func (s *Broker) SubscribeRemove(c amp.Sender, names []string) {
+	metric.Time("broker.subscribe.remove.len", len(names))
+	s.inLoop(func() {
+		current, ok := s.consumerNames[c]
+		if !ok {
+			return
+		}
+		var removed []string
+		var removedPatterns []string
+		for _, name := range names {
+			if amp.IsPattern(name) {
+				if _, ok := s.patterns[c][name]; ok {
+					delete(s.patterns[c], name)
+					removedPatterns = append(removedPatterns, name)
+				}
+				continue
+			}
+			delete(s.explicit[c], name)
+			if _, ok := amp.MatchPattern(s.patterns[c], name); ok {
+				continue // still subscribed by pattern
+			}
+			removed = append(removed, name)
+		}
+
+		if len(removedPatterns) > 0 {
+			explicit := s.explicit[c]
+			for name := range current {
+				if _, ok := explicit[name]; ok {
+					continue
+				}
+				if _, ok := amp.MatchPattern(s.patterns[c], name); ok {
+					continue
+				}
+				removed = append(removed, name)
+			}
+			if len(s.patterns[c]) == 0 {
+				delete(s.patterns, c)
+				delete(s.explicit, c)
+			}
+		}
+
+		for _, name := range removed {
+			if _, ok := current[name]; !ok {
+				continue
+			}
+			delete(current, name)
+			if spr, ok := s.spreaders[name]; ok {
+				spr.unsubscribe(c)
+			}
+		}
+	})
+}
+
+// Resubscribe subscribes consumer again to the already subscribed topics,
+// consumer gets messages after ts same as on the new subscription.
+// Topic subscribed by pattern is resubscribed alone, other topics matched by
+// the pattern are unchanged. Names which are not subscribed are ignored.
+func (s *Broker) Resubscribe(c amp.Sender, names map[string]int64) {
+	metric.Time("broker.resubscribe.len", len(names))
+	s.inLoop(func() {
+		current := s.consumerNames[c]
+		for name, ts := range names {
+			if _, ok := current[name]; !ok {
+				continue
+			}
+			spr, ok := s.spreaders[name]
+			if !ok {
+				continue
+			}
+			current[name] = ts
+			spr.subscribe(c, ts)
+		}
+	})
+}
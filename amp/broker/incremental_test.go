@@ -0,0 +1,97 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/stretchr/testify/assert"
+)
+
+func (s *Broker) names(c amp.Sender) map[string]int64 {
+	var names map[string]int64
+	s.inLoopWait(func() {
+		names = copyMap(s.consumerNames[c])
+	})
+	return names
+}
+
+func TestSubscribeAddRemove(t *testing.T) {
+	s := New(nil, nil)
+	s.Publish(&amp.Msg{URI: "a", Ts: 1, UpdateType: amp.Full})
+	s.Publish(&amp.Msg{URI: "b", Ts: 1, UpdateType: amp.Full})
+	s.Flush()
+
+	c := &testConsumer{}
+	s.SubscribeAdd(c, map[string]int64{"a": 0})
+	s.SubscribeAdd(c, map[string]int64{"b": 0})
+	s.Flush()
+	assert.Equal(t, map[string]int64{"a": 0, "b": 0}, s.names(c))
+	assert.ElementsMatch(t, []string{"a", "b"}, c.uris())
+
+	// adding again doesn't send anything
+	s.SubscribeAdd(c, map[string]int64{"a": 0})
+	s.Flush()
+	assert.Len(t, c.uris(), 2)
+
+	s.SubscribeRemove(c, []string{"a"})
+	s.Publish(&amp.Msg{URI: "a", Ts: 2, UpdateType: amp.Diff})
+	s.Publish(&amp.Msg{URI: "b", Ts: 2, UpdateType: amp.Diff})
+	s.Flush()
+	assert.Equal(t, map[string]int64{"b": 0}, s.names(c))
+	assert.ElementsMatch(t, []string{"a", "b", "b"}, c.uris())
+}
+
+func TestSubscribeAddRemovePattern(t *testing.T) {
+	s := New(nil, nil)
+	s.Publish(&amp.Msg{URI: "e_1", Ts: 1, UpdateType: amp.Full})
+	s.Publish(&amp.Msg{URI: "e_2", Ts: 1, UpdateType: amp.Full})
+	s.Flush()
+
+	c := &testConsumer{}
+	s.SubscribeAdd(c, map[string]int64{"e_1": 0})
+	s.SubscribeAdd(c, map[string]int64{"e_*": 0})
+	s.Flush()
+	assert.Equal(t, map[string]int64{"e_1": 0, "e_2": 0}, s.names(c))
+	assert.Len(t, c.uris(), 2)
+
+	// new topic is subscribed by pattern
+	s.Publish(&amp.Msg{URI: "e_3", Ts: 1, UpdateType: amp.Full})
+	s.Flush()
+	assert.Equal(t, map[string]int64{"e_1": 0, "e_2": 0, "e_3": 0}, s.names(c))
+
+	// removing topic matched by pattern keeps it subscribed
+	s.SubscribeRemove(c, []string{"e_2"})
+	s.Flush()
+	assert.Equal(t, map[string]int64{"e_1": 0, "e_2": 0, "e_3": 0}, s.names(c))
+
+	// removing pattern keeps only topics subscribed by name
+	s.SubscribeRemove(c, []string{"e_*"})
+	s.Publish(&amp.Msg{URI: "e_4", Ts: 1, UpdateType: amp.Full})
+	s.Flush()
+	assert.Equal(t, map[string]int64{"e_1": 0}, s.names(c))
+	s.inLoopWait(func() {
+		assert.Nil(t, s.patterns[c])
+		assert.Nil(t, s.explicit[c])
+	})
+
+	s.Unsubscribe(c)
+	assert.Empty(t, s.names(c))
+}
+
+func TestResubscribe(t *testing.T) {
+	s := New(nil, nil)
+	s.Publish(&amp.Msg{URI: "e_1", Ts: 1, UpdateType: amp.Full})
+	s.Publish(&amp.Msg{URI: "e_2", Ts: 1, UpdateType: amp.Full})
+	s.Flush()
+
+	c := &testConsumer{}
+	s.Subscribe(c, map[string]int64{"e_*": 0})
+	s.Flush()
+	assert.Len(t, c.uris(), 2)
+
+	// only resubscribed topic is sent again
+	s.Resubscribe(c, map[string]int64{"e_1": 0, "x": 0})
+	s.Flush()
+	assert.ElementsMatch(t, []string{"e_1", "e_2", "e_1"}, c.uris())
+	assert.Equal(t, map[string]int64{"e_1": 0, "e_2": 0}, s.names(c))
+}
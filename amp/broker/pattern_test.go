@@ -0,0 +1,95 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (c *testConsumer) uris() []string {
+	c.Lock()
+	defer c.Unlock()
+	var uris []string
+	for _, m := range c.messages {
+		uris = append(uris, m.URI)
+	}
+	return uris
+}
+
+func TestPatternSubscribe(t *testing.T) {
+	s := New(nil, nil)
+	s.Publish(&amp.Msg{URI: "e_1", Ts: 1, UpdateType: amp.Full})
+	s.Publish(&amp.Msg{URI: "e_1", Ts: 2, UpdateType: amp.Diff})
+	s.Publish(&amp.Msg{URI: "e_1", Ts: 3, UpdateType: amp.Diff})
+	s.Publish(&amp.Msg{URI: "m", Ts: 1, UpdateType: amp.Full})
+	s.wait("e_1")
+	s.wait("m")
+
+	// existing topic is subscribed with pattern ts
+	c := &testConsumer{topics: map[string]int64{"e_*": 2}}
+	s.Subscribe(c, c.topics)
+	s.wait("e_1")
+	require.Len(t, c.messages, 1)
+	assert.Equal(t, int64(3), c.messages[0].Ts)
+
+	// new topic is subscribed from the start
+	s.Publish(&amp.Msg{URI: "e_2", Ts: 10, UpdateType: amp.Full})
+	s.Publish(&amp.Msg{URI: "m", Ts: 2, UpdateType: amp.Diff})
+	s.wait("e_2")
+	s.wait("m")
+	assert.Equal(t, []string{"e_1", "e_2"}, c.uris())
+
+	// resubscribe with the same pattern doesn't send anything again
+	s.Subscribe(c, map[string]int64{"e_*": 2, "m": 2})
+	s.Publish(&amp.Msg{URI: "e_1", Ts: 4, UpdateType: amp.Diff})
+	s.wait("e_1")
+	s.wait("m")
+	assert.Equal(t, []string{"e_1", "e_2", "e_1"}, c.uris())
+
+	// removing pattern unsubscribes matched topics, but not exact ones
+	s.Subscribe(c, map[string]int64{"m": 2, "e_2": 10})
+	s.Publish(&amp.Msg{URI: "e_1", Ts: 5, UpdateType: amp.Diff})
+	s.Publish(&amp.Msg{URI: "e_2", Ts: 11, UpdateType: amp.Diff})
+	s.Publish(&amp.Msg{URI: "e_3", Ts: 1, UpdateType: amp.Full})
+	s.wait("e_1")
+	s.wait("e_2")
+	s.wait("e_3")
+	assert.Equal(t, []string{"e_1", "e_2", "e_1", "e_2"}, c.uris())
+
+	s.inLoopWait(func() {
+		assert.Len(t, s.spreaders["e_1"].consumerTopics, 0)
+		assert.Nil(t, s.patterns[c])
+	})
+}
+
+func TestPatternUnsubscribe(t *testing.T) {
+	s := New(nil, nil)
+	c := &testConsumer{topics: map[string]int64{"e_*": 0}}
+	s.Subscribe(c, c.topics)
+	s.Publish(&amp.Msg{URI: "e_1", Ts: 1, UpdateType: amp.Full})
+	s.wait("e_1")
+	assert.Len(t, c.messages, 1)
+
+	s.Unsubscribe(c)
+	s.Publish(&amp.Msg{URI: "e_2", Ts: 1, UpdateType: amp.Full})
+	s.wait("e_2")
+	assert.Len(t, c.messages, 1)
+	s.inLoopWait(func() {
+		assert.Len(t, s.patterns, 0)
+		assert.Len(t, s.spreaders["e_1"].consumerTopics, 0)
+	})
+}
+
+func TestPatternTopicClose(t *testing.T) {
+	s := New(nil, nil)
+	c := &testConsumer{topics: map[string]int64{"e_*": 0}}
+	s.Subscribe(c, c.topics)
+	s.Publish(&amp.Msg{URI: "e_1", Ts: 1, UpdateType: amp.Full})
+	s.Publish(&amp.Msg{URI: "e_1", UpdateType: amp.Close})
+	s.Publish(&amp.Msg{URI: "e_1", Ts: 2, UpdateType: amp.Full})
+	s.wait("e_1")
+	// recreated topic is subscribed again
+	assert.Len(t, c.messages, 2)
+}
@@ -0,0 +1,103 @@
+package broker
+
+import (
+	"strings"
+	"time"
+
+	"github.com/minus5/svckit/amp"
+)
+
+// topic cache kinds
+const (
+	CacheAuto     uint8 = iota // append cache if first message is Append or Update, otherwise full/diff
+	CacheFullDiff              // last full and diffs after it
+	CacheAppend                // last CacheDepth messages
+)
+
+const (
+	defaultCacheDepth = 64
+	defaultMetricName = "other"
+)
+
+// TopicPolicy defines how broker handles topics matching the Pattern.
+type TopicPolicy struct {
+	// Pattern is exact topic name, or prefix if it ends with '*'.
+	Pattern string
+	// Shards is number of topics in the spreader for the topic.
+	// Consumers are spread over shards, use it for topics with many consumers.
+	Shards int
+	// Cache kind, one of CacheAuto, CacheFullDiff, CacheAppend.
+	Cache uint8
+	// CacheDepth for the append cache, message CacheDepth overrides it.
+	CacheDepth int
+	// Expire topic without consumers after this period of inactivity.
+	// If zero broker expireDuration is used.
+	Expire time.Duration
+	// MetricName returns name used in topic metrics.
+	MetricName func(topic string) string
+	// Throttle caps topic to one Diff message per window.
+	// Diffs arriving within the window are coalesced into one, Full and Close
+	// are sent immediately. Works only after the topic got Full message.
+	// Zero disables throttling.
+	Throttle time.Duration
+}
+
+// TopicPolicies is registry of topic policies.
+// Exact pattern match has precedence, then the longest matching prefix.
+type TopicPolicies []TopicPolicy
+
+// DefaultTopicPolicies are used when no policies are passed to New.
+var DefaultTopicPolicies = TopicPolicies{
+	{Pattern: "sportsbook/m", Shards: 16, MetricName: PrefixMetricName("sportsbook/")},
+	{Pattern: "sportsbook/i_hr", Shards: 16, MetricName: PrefixMetricName("sportsbook/")},
+	{Pattern: "sportsbook/*", MetricName: PrefixMetricName("sportsbook/")},
+}
+
+// PrefixMetricName returns metric name function which uses
+// first character of the topic name after prefix.
+// Example: "sportsbook/m" with prefix "sportsbook/" results in "m".
+func PrefixMetricName(prefix string) func(string) string {
+	return func(topic string) string {
+		if len(topic) > len(prefix) && strings.HasPrefix(topic, prefix) {
+			return topic[len(prefix) : len(prefix)+1]
+		}
+		return defaultMetricName
+	}
+}
+
+// Find returns policy for the topic.
+// Defaults are set for all unset fields.
+func (ps TopicPolicies) Find(topic string) TopicPolicy {
+	var p TopicPolicy
+	specific := -1
+	for _, c := range ps {
+		if s := amp.PatternMatch(c.Pattern, topic); s > specific {
+			p = c
+			specific = s
+		}
+	}
+	return p.withDefaults()
+}
+
+// expires returns true if any policy has own expire period
+func (ps TopicPolicies) expires() bool {
+	for _, p := range ps {
+		if p.Expire > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (p TopicPolicy) withDefaults() TopicPolicy {
+	if p.Shards < 1 {
+		p.Shards = 1
+	}
+	if p.CacheDepth < 1 {
+		p.CacheDepth = defaultCacheDepth
+	}
+	if p.MetricName == nil {
+		p.MetricName = func(string) string { return defaultMetricName }
+	}
+	return p
+}
@@ -0,0 +1,90 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicPoliciesFind(t *testing.T) {
+	ps := TopicPolicies{
+		{Pattern: "a/*", Shards: 2},
+		{Pattern: "a/b*", Shards: 3},
+		{Pattern: "a/bc", Shards: 4},
+		{Pattern: "c", Cache: CacheAppend, CacheDepth: 10},
+	}
+	cases := []struct {
+		topic  string
+		shards int
+		depth  int
+	}{
+		{"a/x", 2, defaultCacheDepth},
+		{"a/b", 3, defaultCacheDepth},
+		{"a/bd", 3, defaultCacheDepth},
+		{"a/bc", 4, defaultCacheDepth},
+		{"c", 1, 10},
+		{"cd", 1, defaultCacheDepth},
+		{"other", 1, defaultCacheDepth},
+	}
+	for _, c := range cases {
+		p := ps.Find(c.topic)
+		assert.Equal(t, c.shards, p.Shards, c.topic)
+		assert.Equal(t, c.depth, p.CacheDepth, c.topic)
+		assert.Equal(t, defaultMetricName, p.MetricName(c.topic))
+	}
+}
+
+func TestDefaultTopicPolicies(t *testing.T) {
+	p := DefaultTopicPolicies.Find("sportsbook/m")
+	assert.Equal(t, 16, p.Shards)
+	assert.Equal(t, "m", p.MetricName("sportsbook/m"))
+	p = DefaultTopicPolicies.Find("sportsbook/e_123")
+	assert.Equal(t, 1, p.Shards)
+	assert.Equal(t, "e", p.MetricName("sportsbook/e_123"))
+	p = DefaultTopicPolicies.Find("other")
+	assert.Equal(t, 1, p.Shards)
+	assert.Equal(t, "other", p.MetricName("other"))
+}
+
+func TestPolicyCache(t *testing.T) {
+	s := New(nil, nil,
+		TopicPolicy{Pattern: "append", Cache: CacheAppend, CacheDepth: 2},
+		TopicPolicy{Pattern: "sharded", Shards: 4},
+	)
+	s.Publish(&amp.Msg{URI: "append", Ts: 1, UpdateType: amp.Full})
+	s.Publish(&amp.Msg{URI: "append", Ts: 2, UpdateType: amp.Diff})
+	s.Publish(&amp.Msg{URI: "append", Ts: 3, UpdateType: amp.Diff})
+	s.Publish(&amp.Msg{URI: "sharded", Ts: 1, UpdateType: amp.Full})
+	s.wait("append")
+	s.wait("sharded")
+
+	c := &testConsumer{topics: map[string]int64{"append": 0}}
+	s.Subscribe(c, c.topics)
+	s.wait("append")
+	assert.Len(t, c.messages, 2)
+
+	s.inLoopWait(func() {
+		assert.Len(t, s.spreaders["sharded"].topics, 4)
+		assert.Len(t, s.spreaders["append"].topics, 1)
+	})
+}
+
+func TestPolicyExpire(t *testing.T) {
+	s := New(nil, nil, TopicPolicy{Pattern: "short*", Expire: time.Nanosecond})
+	s.Publish(&amp.Msg{URI: "short", Ts: 1, UpdateType: amp.Full})
+	s.Publish(&amp.Msg{URI: "long", Ts: 1, UpdateType: amp.Full})
+	s.wait("short")
+	s.wait("long")
+	time.Sleep(time.Millisecond)
+
+	s.inLoopWait(func() {
+		s.removeExpired(neverExpire)
+	})
+	s.inLoopWait(func() {
+		assert.Len(t, s.spreaders, 1)
+		_, ok := s.spreaders["long"]
+		assert.True(t, ok)
+	})
+}
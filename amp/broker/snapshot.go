@@ -0,0 +1,214 @@
+package broker
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/log"
+)
+
+// snapshot of all topic caches, used for warm start after restart
+type snapshot struct {
+	Created time.Time
+	Topics  []topicSnapshot
+}
+
+type topicSnapshot struct {
+	Name   string
+	Append bool     // appendCache, otherwise fullDiffCache
+	Depth  int      // appendCache depth
+	Msgs   [][]byte // cached messages packed with MarshalForBackend
+}
+
+// Persist enables saving topic caches snapshot to the file fn.
+// Snapshot is saved on every interval (if interval > 0) and when broker is closed.
+// Use Restore with the same file on start.
+func (s *Broker) Persist(fn string, interval time.Duration) {
+	s.inLoopWait(func() {
+		s.snapshotFile = fn
+		if s.snapshotTicker != nil {
+			s.snapshotTicker.Stop()
+			s.snapshotTicker = nil
+		}
+		if interval > 0 {
+			s.snapshotTicker = time.NewTicker(interval)
+		}
+	})
+}
+
+// Snapshot saves current state of topic caches to the file fn.
+func (s *Broker) Snapshot(fn string) error {
+	var topics map[string]*topic
+	s.inLoopWait(func() {
+		topics = s.snapshotTopics()
+	})
+	return collectSnapshot(topics).save(fn)
+}
+
+// Restore warm starts broker from the snapshot file saved by Persist or Snapshot.
+// Restored topics are created without calling current, consumers
+// subscribing with known ts get only messages they missed.
+// Snapshot older than maxAge (if maxAge > 0) is ignored.
+// Missing file is not an error, broker starts empty.
+func (s *Broker) Restore(fn string, maxAge time.Duration) error {
+	ss, err := loadSnapshot(fn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if age := time.Since(ss.Created); maxAge > 0 && age > maxAge {
+		log.S("file", fn).I("age", int(age.Seconds())).Info("broker snapshot expired")
+		return nil
+	}
+	s.inLoopWait(func() {
+		s.restore(ss)
+	})
+	log.S("file", fn).I("topics", len(ss.Topics)).Info("broker snapshot restored")
+	return nil
+}
+
+// snapshotTopics returns topics to snapshot, must be called in the broker loop.
+// All shards of the spreader have the same cache, first one is used.
+func (s *Broker) snapshotTopics() map[string]*topic {
+	topics := make(map[string]*topic, len(s.spreaders))
+	for name, spr := range s.spreaders {
+		topics[name] = spr.topics[0]
+	}
+	return topics
+}
+
+// collectSnapshot copies topic caches, each in its own topic loop,
+// so it doesn't block the broker loop
+func collectSnapshot(topics map[string]*topic) *snapshot {
+	start := time.Now()
+	ss := &snapshot{Created: start}
+	for name, t := range topics {
+		ts, ok := t.snapshot()
+		if !ok {
+			continue
+		}
+		ts.Name = name
+		ss.Topics = append(ss.Topics, ts)
+	}
+	metric.Time("broker.snapshot.collect", int(time.Now().Sub(start).Nanoseconds()))
+	return ss
+}
+
+// restore must be called in the broker loop
+func (s *Broker) restore(ss *snapshot) {
+	for _, ts := range ss.Topics {
+		if _, ok := s.spreaders[ts.Name]; ok {
+			continue
+		}
+		var msgs []*amp.Msg
+		for _, buf := range ts.Msgs {
+			if m := amp.ParseFromBackend(buf); m != nil {
+				msgs = append(msgs, m)
+			}
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+		s.find(ts.Name, false).restore(ts.Append, ts.Depth, msgs)
+	}
+	metric.Counter("broker.snapshot.restored", len(ss.Topics))
+}
+
+// saveSnapshot saves snapshot to the configured file, called from the broker loop.
+// Async save collects, encodes and writes snapshot outside of the broker loop,
+// it is skipped while the previous one is still running.
+func (s *Broker) saveSnapshot(async bool) {
+	if s.snapshotFile == "" {
+		return
+	}
+	topics := s.snapshotTopics()
+	fn := s.snapshotFile
+	save := func() {
+		if err := collectSnapshot(topics).save(fn); err != nil {
+			log.S("file", fn).Error(err)
+		}
+	}
+	if !async {
+		save()
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&s.snapshotBusy, 0, 1) {
+		metric.Counter("broker.snapshot.skipped")
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&s.snapshotBusy, 0)
+		save()
+	}()
+}
+
+func (s *Broker) snapshotC() <-chan time.Time {
+	if s.snapshotTicker == nil {
+		return nil
+	}
+	return s.snapshotTicker.C
+}
+
+// save writes snapshot to the temporary file and renames it to fn,
+// so the previous snapshot stays intact if write fails.
+func (ss *snapshot) save(fn string) error {
+	start := time.Now()
+	f, err := os.CreateTemp(filepath.Dir(fn), filepath.Base(fn)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err := ss.write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, fn); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	metric.Time("broker.snapshot.save", int(time.Now().Sub(start).Nanoseconds()))
+	return nil
+}
+
+func (ss *snapshot) write(w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	if err := gob.NewEncoder(zw).Encode(ss); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func loadSnapshot(fn string) (*snapshot, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readSnapshot(f)
+}
+
+func readSnapshot(r io.Reader) (*snapshot, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	ss := &snapshot{}
+	if err := gob.NewDecoder(zr).Decode(ss); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
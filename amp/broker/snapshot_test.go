@@ -0,0 +1,82 @@
+package broker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "broker.snapshot")
+
+	s := New(nil, nil)
+	s.Persist(fn, 0)
+	s.Publish(&amp.Msg{URI: "1", Ts: 101, UpdateType: amp.Full})
+	s.Publish(&amp.Msg{URI: "1", Ts: 105, UpdateType: amp.Diff})
+	s.Publish(&amp.Msg{URI: "1", Ts: 107, UpdateType: amp.Diff})
+	s.Publish(&amp.Msg{URI: "2", Ts: 1, UpdateType: amp.Append, CacheDepth: 2})
+	s.Publish(&amp.Msg{URI: "2", Ts: 2, UpdateType: amp.Append})
+	s.Publish(&amp.Msg{URI: "2", Ts: 3, UpdateType: amp.Append})
+	s.wait("1")
+	s.wait("2")
+	s.waitClose() // snapshot is saved on close
+
+	var currentCalls []string
+	s = New(func(name string) { currentCalls = append(currentCalls, name) }, nil)
+	require.NoError(t, s.Restore(fn, time.Minute))
+
+	// consumer with known ts gets only missed diffs
+	c := &testConsumer{topics: map[string]int64{"1": 105}}
+	s.Subscribe(c, c.topics)
+	s.wait("1")
+	require.Len(t, c.messages, 1)
+	assert.Equal(t, int64(107), c.messages[0].Ts)
+
+	// new consumer gets full and diffs
+	c = &testConsumer{topics: map[string]int64{"1": 0}}
+	s.Subscribe(c, c.topics)
+	s.wait("1")
+	require.Len(t, c.messages, 5) // burst start, full, two diffs, burst end
+	assert.Equal(t, int64(101), c.messages[1].Ts)
+	assert.True(t, c.messages[1].IsFull())
+
+	// append cache depth is preserved
+	c = &testConsumer{topics: map[string]int64{"2": 0}}
+	s.Subscribe(c, c.topics)
+	s.wait("2")
+	require.Len(t, c.messages, 2)
+	assert.Equal(t, int64(2), c.messages[0].Ts)
+	assert.Equal(t, int64(3), c.messages[1].Ts)
+
+	// restored topics don't call current
+	s.waitClose()
+	assert.Len(t, currentCalls, 0)
+}
+
+func TestRestoreMissingOrExpired(t *testing.T) {
+	dir := t.TempDir()
+	s := New(nil, nil)
+	assert.NoError(t, s.Restore(filepath.Join(dir, "missing"), 0))
+
+	fn := filepath.Join(dir, "broker.snapshot")
+	ss := &snapshot{
+		Created: time.Now().Add(-time.Hour),
+		Topics: []topicSnapshot{
+			{Name: "1", Msgs: [][]byte{(&amp.Msg{URI: "1", Ts: 1, UpdateType: amp.Full}).MarshalForBackend()}},
+		},
+	}
+	require.NoError(t, ss.save(fn))
+	require.NoError(t, s.Restore(fn, time.Minute))
+	assert.Len(t, s.spreaders, 0)
+
+	require.NoError(t, s.Restore(fn, 0))
+	assert.Len(t, s.spreaders, 1)
+
+	require.NoError(t, os.WriteFile(fn, []byte("not a snapshot"), 0644))
+	assert.Error(t, s.Restore(fn, 0))
+}
@@ -12,22 +12,34 @@ type spreader struct {
 	consumerTopics map[amp.Sender]*topic
 	pos            int
 	lastUsed       time.Time
+	expire         time.Duration
+	throttle       *throttle // nil if topic is not throttled
 }
 
-func newSpreader(name string, topicCount int) *spreader {
+func newSpreader(name string, policy TopicPolicy) *spreader {
+	policy = policy.withDefaults()
 	s := &spreader{
-		topicCount:     topicCount,
+		topicCount:     policy.Shards,
 		topics:         []*topic{},
 		consumerTopics: make(map[amp.Sender]*topic),
 		lastUsed:       time.Now(),
+		expire:         policy.Expire,
 	}
-	for i := 0; i < topicCount; i++ {
-		s.topics = append(s.topics, newTopic(name))
+	for i := 0; i < s.topicCount; i++ {
+		s.topics = append(s.topics, newTopic(name, policy))
+	}
+	if policy.Throttle > 0 {
+		s.throttle = newThrottle(policy.Throttle, policy.MetricName(name), s.fanout)
 	}
 	return s
 }
 
+// isExpired checks if spreader is unused for expirePeriod,
+// expire period from topic policy has precedence
 func (spr *spreader) isExpired(expirePeriod time.Duration) bool {
+	if spr.expire > 0 {
+		expirePeriod = spr.expire
+	}
 	return len(spr.consumerTopics) == 0 && time.Now().Sub(spr.lastUsed) > expirePeriod
 }
 
@@ -49,12 +61,24 @@ func (spr *spreader) subscribe(c amp.Sender, ts int64) {
 
 func (spr *spreader) publish(m *amp.Msg) {
 	spr.lastUsed = time.Now()
+	if spr.throttle != nil {
+		spr.throttle.publish(m)
+		return
+	}
+	spr.fanout(m)
+}
+
+// fanout sends message to all topics
+func (spr *spreader) fanout(m *amp.Msg) {
 	for _, t := range spr.topics {
 		t.messages <- m
 	}
 }
 
 func (spr *spreader) close() {
+	if spr.throttle != nil {
+		spr.throttle.close()
+	}
 	for _, t := range spr.topics {
 		t.close()
 	}
@@ -71,6 +95,13 @@ func (spr *spreader) replay() []*amp.Msg {
 	return spr.topics[0].replay()
 }
 
+// restore fills caches of all topics with msgs from snapshot
+func (spr *spreader) restore(isAppend bool, depth int, msgs []*amp.Msg) {
+	for _, t := range spr.topics {
+		t.restore(isAppend, depth, msgs)
+	}
+}
+
 // samo za testove
 func (spr *spreader) wait() {
 	for _, t := range spr.topics {
@@ -33,7 +33,7 @@ func (c *counter) Headers() map[string]string {
 }
 
 func TestSpreader(t *testing.T) {
-	s := newSpreader("m", 16)
+	s := newSpreader("m", TopicPolicy{Shards: 16})
 	m1 := &amp.Msg{Ts: 10, UpdateType: amp.Full}
 	m2 := &amp.Msg{Ts: 11, UpdateType: amp.Diff}
 	m3 := &amp.Msg{Ts: 12, UpdateType: amp.Diff}
@@ -84,7 +84,7 @@ func TestSpreader(t *testing.T) {
 }
 
 func TestSpreaderClose(t *testing.T) {
-	s := newSpreader("m", 16)
+	s := newSpreader("m", TopicPolicy{Shards: 16})
 	cs := []*counter{}
 	for i := 0; i < 100; i++ {
 		c := counter{}
@@ -119,9 +119,9 @@ func benchPublisher(p publisher) {
 }
 
 func BenchmarkTopic(b *testing.B) {
-	benchPublisher(newTopic("m"))
+	benchPublisher(newTopic("m", TopicPolicy{}))
 }
 
 func BenchmarkSpreader(b *testing.B) {
-	benchPublisher(newSpreader("m", 16))
+	benchPublisher(newSpreader("m", TopicPolicy{Shards: 16}))
 }
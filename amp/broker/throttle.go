@@ -0,0 +1,154 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/log"
+	"github.com/minus5/svckit/pkg/jsonu"
+)
+
+// throttle coalesces topic diffs within the window into one message.
+// It keeps topic state (Full with diffs merged) and the state sent to
+// consumers, coalesced diff is the difference between them.
+// First diff after quiet period is sent immediately and opens the window,
+// diffs within the window are merged and sent when window ends.
+type throttle struct {
+	window     time.Duration
+	send       func(*amp.Msg)
+	state      map[string]interface{} // current topic state, nil until the first Full
+	sent       map[string]interface{} // copy of the state consumers have
+	last       *amp.Msg               // last diff merged into state and not sent
+	merged     int                    // number of diffs merged into state and not sent
+	timer      *time.Timer            // active window, nil if window is closed
+	closed     bool
+	mCoalesced string
+	mSent      string
+	sync.Mutex
+}
+
+func newThrottle(window time.Duration, metricName string, send func(*amp.Msg)) *throttle {
+	return &throttle{
+		window:     window,
+		send:       send,
+		mCoalesced: fmt.Sprintf("topic.throttle.%s.coalesced", metricName),
+		mSent:      fmt.Sprintf("topic.throttle.%s.sent", metricName),
+	}
+}
+
+func (t *throttle) publish(m *amp.Msg) {
+	t.Lock()
+	defer t.Unlock()
+	if t.closed {
+		return
+	}
+	switch m.UpdateType {
+	case amp.Full:
+		state, err := parseBody(m)
+		if err != nil {
+			log.S("topic", m.URI).Error(err)
+		}
+		t.drop()
+		t.state = state
+		t.sent = jsonu.DeepCopyMap(state)
+		t.send(m)
+	case amp.Diff:
+		if t.state == nil {
+			t.send(m)
+			return
+		}
+		diff, err := parseBody(m)
+		if err != nil {
+			log.S("topic", m.URI).Error(err)
+			t.flush()
+			t.state = nil // pass through until next Full
+			t.send(m)
+			return
+		}
+		jsonu.JsonMerge(t.state, diff)
+		if t.timer == nil {
+			t.sent = jsonu.DeepCopyMap(t.state)
+			t.send(m)
+			t.timer = time.AfterFunc(t.window, t.windowEnd)
+			return
+		}
+		t.last = m
+		t.merged++
+	case amp.Close:
+		t.drop()
+		t.state = nil
+		t.sent = nil
+		t.send(m)
+	default:
+		t.flush()
+		t.send(m)
+	}
+}
+
+// windowEnd sends coalesced diff and opens new window if there was one
+func (t *throttle) windowEnd() {
+	t.Lock()
+	defer t.Unlock()
+	t.timer = nil
+	if t.closed || t.last == nil {
+		return
+	}
+	t.flush()
+	t.timer = time.AfterFunc(t.window, t.windowEnd)
+}
+
+// flush sends diff between sent and current state
+func (t *throttle) flush() {
+	if t.last == nil {
+		return
+	}
+	diff := jsonu.Diff(jsonu.MapToSimplejson(t.sent), jsonu.MapToSimplejson(t.state))
+	m := t.last
+	merged := t.merged
+	t.last = nil
+	t.merged = 0
+	t.sent = jsonu.DeepCopyMap(t.state)
+	if jsonu.Empty(diff) {
+		metric.Counter(t.mCoalesced, merged)
+		return
+	}
+	metric.Counter(t.mCoalesced, merged-1)
+	metric.Counter(t.mSent)
+	t.send(amp.NewPublish(m.Topic(), m.Path(), m.Ts, amp.Diff, diff))
+}
+
+// drop removes diffs not sent, they are replaced by Full or topic is closed
+func (t *throttle) drop() {
+	if t.last == nil {
+		return
+	}
+	metric.Counter(t.mCoalesced, t.merged)
+	t.last = nil
+	t.merged = 0
+}
+
+func (t *throttle) close() {
+	t.Lock()
+	defer t.Unlock()
+	t.closed = true
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+}
+
+// parseBody returns message body as json map
+func parseBody(m *amp.Msg) (map[string]interface{}, error) {
+	body := m.RawBody()
+	o := make(map[string]interface{})
+	if len(body) == 0 {
+		return o, nil
+	}
+	if err := json.Unmarshal(body, &o); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
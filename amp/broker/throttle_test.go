@@ -0,0 +1,94 @@
+package broker
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sentMsgs struct {
+	msgs []*amp.Msg
+	sync.Mutex
+}
+
+func (s *sentMsgs) send(m *amp.Msg) {
+	s.Lock()
+	defer s.Unlock()
+	s.msgs = append(s.msgs, m)
+}
+
+func (s *sentMsgs) get() []*amp.Msg {
+	s.Lock()
+	defer s.Unlock()
+	return append([]*amp.Msg(nil), s.msgs...)
+}
+
+func msgBody(m *amp.Msg) string {
+	return string(amp.Parse(m.Marshal()).Body())
+}
+
+func TestThrottle(t *testing.T) {
+	sent := &sentMsgs{}
+	th := newThrottle(time.Hour, "a", sent.send)
+	defer th.close()
+
+	// without Full diffs pass through
+	th.publish(amp.NewPublish("a", "", 1, amp.Diff, map[string]int{"x": 1}))
+	th.publish(amp.NewPublish("a", "", 2, amp.Diff, map[string]int{"x": 2}))
+	require.Len(t, sent.get(), 2)
+
+	th.publish(amp.NewPublish("a", "", 3, amp.Full, map[string]interface{}{"x": 1, "y": map[string]int{"z": 1}}))
+	// first diff is sent immediately and opens window
+	th.publish(amp.NewPublish("a", "", 4, amp.Diff, map[string]int{"x": 2}))
+	require.Len(t, sent.get(), 4)
+	// diffs in the window are coalesced
+	th.publish(amp.NewPublish("a", "", 5, amp.Diff, map[string]interface{}{"x": 3, "y": map[string]int{"w": 2}}))
+	th.publish(amp.NewPublish("a", "", 6, amp.Diff, map[string]interface{}{"x": nil, "y": map[string]interface{}{"z": nil}}))
+	require.Len(t, sent.get(), 4)
+
+	th.windowEnd()
+	msgs := sent.get()
+	require.Len(t, msgs, 5)
+	m := msgs[4]
+	assert.Equal(t, amp.Diff, m.UpdateType)
+	assert.Equal(t, int64(6), m.Ts)
+	assert.JSONEq(t, `{"x":null,"y":{"z":null,"w":2}}`, msgBody(m))
+
+	// diffs which cancel each other are not sent
+	th.publish(amp.NewPublish("a", "", 7, amp.Diff, map[string]int{"x": 1}))
+	th.publish(amp.NewPublish("a", "", 8, amp.Diff, map[string]interface{}{"x": nil}))
+	th.windowEnd()
+	assert.Len(t, sent.get(), 5)
+
+	// Full replaces pending diffs
+	th.publish(amp.NewPublish("a", "", 9, amp.Diff, map[string]int{"x": 1}))
+	th.publish(amp.NewPublish("a", "", 10, amp.Full, map[string]int{"x": 10}))
+	th.windowEnd()
+	msgs = sent.get()
+	require.Len(t, msgs, 6)
+	assert.Equal(t, amp.Full, msgs[5].UpdateType)
+}
+
+func TestThrottleBroker(t *testing.T) {
+	s := New(nil, nil, TopicPolicy{Pattern: "a", Throttle: 20 * time.Millisecond})
+	c := &testConsumer{}
+	s.Subscribe(c, map[string]int64{"a": 0})
+	s.Publish(amp.NewPublish("a", "", 1, amp.Full, map[string]int{"x": 0}))
+	for i := 2; i <= 10; i++ {
+		s.Publish(amp.NewPublish("a", "", int64(i), amp.Diff, map[string]int{"x": i}))
+	}
+	s.Flush()
+	time.Sleep(50 * time.Millisecond)
+	s.Flush()
+
+	c.Lock()
+	defer c.Unlock()
+	require.Len(t, c.messages, 3)
+	assert.Equal(t, int64(2), c.messages[1].Ts)
+	assert.Equal(t, int64(10), c.messages[2].Ts)
+	assert.JSONEq(t, `{"x":10}`, msgBody(c.messages[2]))
+}
@@ -3,7 +3,6 @@ package broker
 import (
 	"fmt"
 	"math"
-	"strings"
 	"time"
 
 	"github.com/minus5/svckit/amp"
@@ -32,6 +31,7 @@ type topic struct {
 	consumers       map[amp.Sender]int64
 	closed          chan struct{}
 	cache           cache
+	policy          TopicPolicy
 	updatedAt       time.Time
 	metricName      string
 	mOnMsgDuration  string
@@ -44,16 +44,15 @@ type topic struct {
 	mSubPerMsg      string
 }
 
-func newTopic(name string) *topic {
+func newTopic(name string, policy TopicPolicy) *topic {
+	policy = policy.withDefaults()
 	t := &topic{
 		messages:   make(chan *amp.Msg, 128),
 		consumers:  make(map[amp.Sender]int64),
 		closed:     make(chan struct{}),
 		loopWork:   make(chan func()),
-		metricName: "other",
-	}
-	if strings.HasPrefix(name, "sportsbook/") {
-		t.metricName = name[11:12]
+		policy:     policy,
+		metricName: policy.MetricName(name),
 	}
 	t.mOnMsgDuration = fmt.Sprintf("topic.onMessage.%s.duration", t.metricName)
 	t.mOnMsgConsumers = fmt.Sprintf("topic.onMessage.%s.consumers", t.metricName)
@@ -171,11 +170,7 @@ func (t *topic) onMessage(m *amp.Msg) {
 		return
 	}
 	if t.cache == nil {
-		if m.UpdateType == amp.Append || m.UpdateType == amp.Update {
-			t.cache = newAppendCache()
-		} else {
-			t.cache = newFullDiffCache()
-		}
+		t.cache = t.newCache(m)
 	}
 	t.cache.Add(m)
 	var current []*amp.Msg
@@ -195,15 +190,46 @@ func (t *topic) onMessage(m *amp.Msg) {
 	t.updatedAt = time.Now()
 }
 
+// newCache creates cache by topic policy, kind of the first message decides if not set
+func (t *topic) newCache(m *amp.Msg) cache {
+	switch t.policy.Cache {
+	case CacheAppend:
+		return t.newAppendCache(0)
+	case CacheFullDiff:
+		return newFullDiffCache()
+	}
+	if m.UpdateType == amp.Append || m.UpdateType == amp.Update {
+		return t.newAppendCache(0)
+	}
+	return newFullDiffCache()
+}
+
+// newAppendCache creates append cache with depth, policy depth is used if depth is not set
+func (t *topic) newAppendCache(depth int) *appendCache {
+	c := newAppendCache()
+	c.depth = t.policy.CacheDepth
+	if depth > 0 {
+		c.depth = depth
+	}
+	return c
+}
+
+// replay returns current messages as replay, nil if topic has no cache or it is closed.
 func (t *topic) replay() []*amp.Msg {
-	if t.cache == nil {
-		return nil
+	var msgs []*amp.Msg
+	done := make(chan struct{})
+	work := func() {
+		if t.cache != nil {
+			msgs = t.cache.Current()
+		}
+		close(done)
 	}
-	ret := make(chan []*amp.Msg, 1)
-	t.loopWork <- func() {
-		ret <- t.cache.Current()
+	select {
+	case t.loopWork <- work:
+	case <-t.closed:
+		return nil
 	}
-	msgs := <-ret
+	<-done
 	var rmsgs []*amp.Msg
 	for _, m := range msgs {
 		rmsgs = append(rmsgs, m.AsReplay())
@@ -211,6 +237,59 @@ func (t *topic) replay() []*amp.Msg {
 	return rmsgs
 }
 
+// snapshot packs cached messages, returns false if topic has no cache or it is closed.
+// Only message list is copied in the topic loop, messages are packed outside of it.
+func (t *topic) snapshot() (topicSnapshot, bool) {
+	var ts topicSnapshot
+	var msgs []*amp.Msg
+	done := make(chan struct{})
+	work := func() {
+		switch c := t.cache.(type) {
+		case *appendCache:
+			ts.Append = true
+			ts.Depth = c.depth
+			msgs = append(msgs, c.msgs...)
+		case *fullDiffCache:
+			if c.full != nil {
+				msgs = append(msgs, c.full)
+			}
+			msgs = append(msgs, c.diffs...)
+		}
+		close(done)
+	}
+	select {
+	case t.loopWork <- work:
+	case <-t.closed:
+		return ts, false
+	}
+	<-done
+	if len(msgs) == 0 {
+		return ts, false
+	}
+	for _, m := range msgs {
+		ts.Msgs = append(ts.Msgs, m.MarshalForBackend())
+	}
+	return ts, true
+}
+
+// restore creates cache from snapshot messages
+func (t *topic) restore(isAppend bool, depth int, msgs []*amp.Msg) {
+	done := make(chan struct{})
+	t.loopWork <- func() {
+		if isAppend {
+			t.cache = t.newAppendCache(depth)
+		} else {
+			t.cache = newFullDiffCache()
+		}
+		for _, m := range msgs {
+			t.cache.Add(m)
+		}
+		t.updatedAt = time.Now()
+		close(done)
+	}
+	<-done
+}
+
 // func (t *topic) metrics() (diffs, firstDiffTs, lastDiffTs, fullTs int64) {
 // 	done := make(chan struct{})
 // 	t.loopWork <- func() {
@@ -8,7 +8,7 @@ import (
 )
 
 func TestTopicReplay(t *testing.T) {
-	topic := newTopic("m")
+	topic := newTopic("m", TopicPolicy{})
 	m1 := &amp.Msg{Ts: 10, UpdateType: amp.Full}
 	m2 := &amp.Msg{Ts: 11, UpdateType: amp.Diff}
 	m3 := &amp.Msg{Ts: 12, UpdateType: amp.Diff}
@@ -25,3 +25,11 @@ func TestTopicReplay(t *testing.T) {
 	assert.Equal(t, m3.Ts, msgs[2].Ts)
 	assert.Equal(t, m4.Ts, msgs[3].Ts)
 }
+
+func TestTopicReplayClosed(t *testing.T) {
+	topic := newTopic("m", TopicPolicy{})
+	assert.Nil(t, topic.replay())
+	topic.publish(&amp.Msg{Ts: 10, UpdateType: amp.Full})
+	topic.close()
+	assert.Nil(t, topic.replay())
+}
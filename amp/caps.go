@@ -0,0 +1,148 @@
+package amp
+
+import "encoding/json"
+
+// Capability features
+const (
+	FeatureBursts = "bursts" // BurstStart and BurstEnd messages
+	FeatureAppend = "append" // cache depth in append messages
+	FeatureMeta   = "meta"   // Meta response messages
+)
+
+// CompressionDeflateName is the name of per message deflate compression in Capabilities.
+const CompressionDeflateName = "deflate"
+
+// Capabilities are exchanged in Hello message at the session start.
+// Client sends supported values in order of preference,
+// server responds with the chosen ones.
+// Nil (missing or null) list means that the client doesn't care and server
+// keeps its defaults, empty list means none.
+type Capabilities struct {
+	Versions    []int    `json:"v,omitempty"` // compatibility versions
+	Codecs      []string `json:"c,omitempty"` // codec names (json, msgpack)
+	Compression []string `json:"z"`           // compression names (deflate)
+	Features    []string `json:"f"`           // features (bursts, append, meta)
+}
+
+// AllFeatures lists all features supported by the server.
+var AllFeatures = []string{FeatureBursts, FeatureAppend, FeatureMeta}
+
+// NewHello creates handshake message.
+func NewHello(c Capabilities) *Msg {
+	return &Msg{
+		Type: Hello,
+		src:  toBodyMarshaler(c),
+	}
+}
+
+// Capabilities returns capabilities from the Hello message body.
+func (m *Msg) Capabilities() (Capabilities, error) {
+	var c Capabilities
+	if len(m.body) == 0 {
+		return c, nil
+	}
+	err := json.Unmarshal(m.body, &c)
+	return c, err
+}
+
+// Negotiate chooses capabilities for the client from the server ones.
+// First client version and codec supported by server is chosen, or the
+// first server one if there is no match. Compression and features are
+// those supported by both.
+func (s Capabilities) Negotiate(client Capabilities) Capabilities {
+	c := Capabilities{
+		Versions:    s.Versions,
+		Codecs:      s.Codecs,
+		Compression: s.Compression,
+		Features:    s.Features,
+	}
+	if client.Versions != nil {
+		c.Versions = nil
+		for _, v := range client.Versions {
+			if containsInt(s.Versions, v) {
+				c.Versions = []int{v}
+				break
+			}
+		}
+		if c.Versions == nil && len(s.Versions) > 0 {
+			c.Versions = s.Versions[:1]
+		}
+	}
+	if client.Codecs != nil {
+		c.Codecs = nil
+		for _, n := range client.Codecs {
+			if contains(s.Codecs, n) {
+				c.Codecs = []string{n}
+				break
+			}
+		}
+		if c.Codecs == nil && len(s.Codecs) > 0 {
+			c.Codecs = s.Codecs[:1]
+		}
+	}
+	if client.Compression != nil {
+		c.Compression = intersect(client.Compression, s.Compression)
+	}
+	if client.Features != nil {
+		c.Features = intersect(client.Features, s.Features)
+	}
+	return c
+}
+
+// Version returns the first version, or CompatibilityVersionDefault.
+func (c Capabilities) Version() uint8 {
+	if len(c.Versions) == 0 {
+		return CompatibilityVersionDefault
+	}
+	return uint8(c.Versions[0])
+}
+
+// Codec returns the first codec, or CodecJSON.
+func (c Capabilities) Codec() uint8 {
+	if len(c.Codecs) == 0 {
+		return CodecJSON
+	}
+	return ParseCodecName(c.Codecs[0])
+}
+
+// Deflate returns true if deflate compression is listed.
+func (c Capabilities) Deflate() bool {
+	return contains(c.Compression, CompressionDeflateName)
+}
+
+// Has returns true if feature is listed, nil Features means all features.
+func (c Capabilities) Has(feature string) bool {
+	if c.Features == nil {
+		return true
+	}
+	return contains(c.Features, feature)
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(l []int, i int) bool {
+	for _, e := range l {
+		if e == i {
+			return true
+		}
+	}
+	return false
+}
+
+// intersect returns elements of a which are in b, never nil
+func intersect(a, b []string) []string {
+	r := []string{}
+	for _, e := range a {
+		if contains(b, e) {
+			r = append(r, e)
+		}
+	}
+	return r
+}
@@ -0,0 +1,57 @@
+package amp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	server := Capabilities{
+		Versions:    []int{0},
+		Codecs:      []string{"json", "msgpack"},
+		Compression: []string{CompressionDeflateName},
+		Features:    AllFeatures,
+	}
+
+	// client doesn't care
+	c := server.Negotiate(Capabilities{})
+	assert.Equal(t, server, c)
+	assert.Equal(t, CodecJSON, c.Codec())
+	assert.True(t, c.Deflate())
+
+	c = server.Negotiate(Capabilities{
+		Versions:    []int{2, 0},
+		Codecs:      []string{"cbor", "msgpack", "json"},
+		Compression: []string{},
+		Features:    []string{FeatureMeta, "unknown"},
+	})
+	assert.Equal(t, []int{0}, c.Versions)
+	assert.Equal(t, CodecMsgpack, c.Codec())
+	assert.False(t, c.Deflate())
+	assert.Equal(t, []string{FeatureMeta}, c.Features)
+	assert.True(t, c.Has(FeatureMeta))
+	assert.False(t, c.Has(FeatureBursts))
+
+	// nothing supported falls back to server default
+	c = server.Negotiate(Capabilities{Versions: []int{5}, Codecs: []string{"cbor"}})
+	assert.Equal(t, CompatibilityVersionDefault, c.Version())
+	assert.Equal(t, CodecJSON, c.Codec())
+}
+
+func TestHello(t *testing.T) {
+	m := Parse(NewHello(Capabilities{Codecs: []string{"msgpack"}, Features: []string{}}).Marshal())
+	require.NotNil(t, m)
+	assert.Equal(t, Hello, m.Type)
+	c, err := m.Capabilities()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"msgpack"}, c.Codecs)
+	assert.Equal(t, []string{}, c.Features)
+	assert.False(t, c.Has(FeatureBursts))
+
+	c, err = (&Msg{Type: Hello}).Capabilities()
+	require.NoError(t, err)
+	assert.Equal(t, Capabilities{}, c)
+	assert.True(t, c.Has(FeatureBursts))
+}
@@ -0,0 +1,369 @@
+// Package client implements amp websocket client.
+//
+// Client subscribes to topics, keeps merged topic state (Full and Diff messages),
+// delivers bursts as single update, sends requests and waits for responses.
+// After connection is lost it reconnects and subscribes again with ts of the
+// last received message for each topic, so it gets only missed messages.
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/log"
+	"github.com/minus5/svckit/pkg/jsonu"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrClosed is returned for requests when client is closed.
+	ErrClosed = errors.New("client closed")
+	// ErrNotConnected is returned for requests while client is reconnecting.
+	ErrNotConnected = errors.New("not connected")
+)
+
+// Client is amp websocket client.
+type Client struct {
+	url               string
+	reconnectInterval time.Duration
+	readTimeout       time.Duration
+	onConnect         func()
+
+	conn      net.Conn
+	reader    io.Reader
+	writeLock sync.Mutex
+
+	subscriptions map[string]*subscription
+	topics        map[string]*topic
+	requests      map[uint64]chan *amp.Msg
+	correlationID uint64
+	meta          map[string]string
+	sync.Mutex
+
+	ctx    context.Context
+	cancel func()
+	closed chan struct{}
+}
+
+// ReconnectInterval sets pause between reconnect attempts.
+func ReconnectInterval(d time.Duration) func(*Client) {
+	return func(c *Client) {
+		c.reconnectInterval = d
+	}
+}
+
+// ReadTimeout sets max period without any message from the server,
+// after that connection is considered broken and client reconnects.
+// Server sends alive message every 32 seconds.
+func ReadTimeout(d time.Duration) func(*Client) {
+	return func(c *Client) {
+		c.readTimeout = d
+	}
+}
+
+// OnConnect sets function called after each successful (re)connect.
+func OnConnect(f func()) func(*Client) {
+	return func(c *Client) {
+		c.onConnect = f
+	}
+}
+
+// Dial connects to the amp websocket endpoint url.
+// Query string of the url is session meta on the server side.
+// Client reconnects until ctx is done or Close is called.
+func Dial(ctx context.Context, url string, opts ...func(*Client)) (*Client, error) {
+	c := &Client{
+		url:               url,
+		reconnectInterval: time.Second,
+		readTimeout:       64 * time.Second,
+		subscriptions:     make(map[string]*subscription),
+		topics:            make(map[string]*topic),
+		requests:          make(map[uint64]chan *amp.Msg),
+		closed:            make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	if err := c.connect(); err != nil {
+		c.cancel()
+		return nil, err
+	}
+	go c.loop()
+	return c, nil
+}
+
+func (c *Client) connect() error {
+	conn, br, _, err := ws.Dial(c.ctx, c.url)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var r io.Reader = conn
+	if br != nil {
+		r = io.MultiReader(br, conn)
+	}
+	c.Lock()
+	c.conn = conn
+	c.reader = r
+	subs := c.subscriptionsMap()
+	meta := c.meta
+	c.Unlock()
+
+	if meta != nil {
+		if err := c.write(&amp.Msg{Type: amp.Meta, Meta: meta}); err != nil {
+			return err
+		}
+	}
+	if len(subs) > 0 {
+		if err := c.write(&amp.Msg{Type: amp.Subscribe, Subscriptions: subs}); err != nil {
+			return err
+		}
+	}
+	if c.onConnect != nil {
+		c.onConnect()
+	}
+	return nil
+}
+
+func (c *Client) loop() {
+	defer close(c.closed)
+	for {
+		c.read()
+		c.disconnected()
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(c.reconnectInterval):
+			}
+			if err := c.connect(); err != nil {
+				log.S("url", c.url).Error(err)
+				continue
+			}
+			break
+		}
+	}
+}
+
+// read reads messages until connection is broken
+func (c *Client) read() {
+	c.Lock()
+	conn := c.conn
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{c.reader, &lockedWriter{c: c}}
+	c.Unlock()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-c.ctx.Done():
+			_ = conn.Close()
+		case <-stop:
+		}
+	}()
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+		buf, _, err := wsutil.ReadServerData(rw)
+		if err != nil {
+			return
+		}
+		if m := amp.Parse(buf); m != nil {
+			c.receive(m)
+		}
+	}
+}
+
+// disconnected closes connection and fails all pending requests
+func (c *Client) disconnected() {
+	c.Lock()
+	_ = c.conn.Close()
+	c.conn = nil
+	requests := c.requests
+	c.requests = make(map[uint64]chan *amp.Msg)
+	c.Unlock()
+	for id, ch := range requests {
+		ch <- (&amp.Msg{CorrelationID: id}).ResponseTransportError(errors.New("connection lost"))
+	}
+}
+
+func (c *Client) receive(m *amp.Msg) {
+	switch m.Type {
+	case amp.Response:
+		c.Lock()
+		ch, ok := c.requests[m.CorrelationID]
+		delete(c.requests, m.CorrelationID)
+		c.Unlock()
+		if ok {
+			ch <- m
+		}
+	case amp.Publish:
+		c.Lock()
+		h, u := c.publish(m)
+		c.Unlock()
+		if h != nil {
+			h(u)
+		}
+	}
+}
+
+// Subscribe subscribes to the topic (or prefix pattern ending with '*').
+// Use ts of the last message client has, or 0 to get current state.
+// Subscribing again to the same topic replaces handler.
+func (c *Client) Subscribe(topic string, ts int64, h Handler) error {
+	c.Lock()
+	c.subscriptions[topic] = &subscription{ts: ts, handler: h}
+	subs := c.subscriptionsMap()
+	c.Unlock()
+	return c.writeState(&amp.Msg{Type: amp.Subscribe, Subscriptions: subs})
+}
+
+// Unsubscribe removes topic subscription.
+func (c *Client) Unsubscribe(topic string) error {
+	c.Lock()
+	delete(c.subscriptions, topic)
+	for name := range c.topics {
+		if _, ok := c.findSubscription(name); !ok {
+			delete(c.topics, name)
+		}
+	}
+	subs := c.subscriptionsMap()
+	c.Unlock()
+	return c.writeState(&amp.Msg{Type: amp.Subscribe, Subscriptions: subs})
+}
+
+// State returns copy of the merged state and ts of the last message for the topic.
+// State is nil for Append and Update topics.
+func (c *Client) State(topic string) (map[string]interface{}, int64) {
+	c.Lock()
+	defer c.Unlock()
+	t, ok := c.topics[topic]
+	if !ok {
+		return nil, 0
+	}
+	if t.state == nil {
+		return nil, t.ts
+	}
+	return jsonu.DeepCopyMap(t.state), t.ts
+}
+
+// SetMeta sets session meta, it is sent again after each reconnect.
+func (c *Client) SetMeta(meta map[string]string) error {
+	c.Lock()
+	if c.meta == nil {
+		c.meta = make(map[string]string)
+	}
+	for k, v := range meta {
+		c.meta[k] = v
+	}
+	c.Unlock()
+	return c.writeState(&amp.Msg{Type: amp.Meta, Meta: meta})
+}
+
+// Request sends request to the uri with req body and unmarshals response body into rsp.
+// Response error is returned as *amp.Error,
+// connection problems as *amp.Error with TransportError source.
+func (c *Client) Request(ctx context.Context, uri string, req, rsp interface{}) error {
+	m, err := c.Call(ctx, amp.NewRequest(uri, req))
+	if err != nil {
+		return err
+	}
+	if rsp == nil || len(m.Body()) == 0 {
+		return nil
+	}
+	return m.Unmarshal(rsp)
+}
+
+// Call sends request message and returns response message.
+// CorrelationID of the message is set by client.
+func (c *Client) Call(ctx context.Context, m *amp.Msg) (*amp.Msg, error) {
+	ch := make(chan *amp.Msg, 1)
+	c.Lock()
+	c.correlationID++
+	m.CorrelationID = c.correlationID
+	c.requests[m.CorrelationID] = ch
+	c.Unlock()
+
+	if err := c.write(m); err != nil {
+		c.removeRequest(m.CorrelationID)
+		return nil, transportError(err)
+	}
+	select {
+	case rsp := <-ch:
+		if rsp.Error != nil {
+			return nil, rsp.Error
+		}
+		return rsp, nil
+	case <-ctx.Done():
+		c.removeRequest(m.CorrelationID)
+		return nil, transportError(ctx.Err())
+	case <-c.closed:
+		return nil, transportError(ErrClosed)
+	}
+}
+
+func (c *Client) removeRequest(id uint64) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.requests, id)
+}
+
+func transportError(err error) *amp.Error {
+	return &amp.Error{Source: amp.TransportError, Message: err.Error()}
+}
+
+// write sends message to the server.
+// While reconnecting message is not sent, subscriptions and meta are sent after reconnect.
+func (c *Client) write(m *amp.Msg) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.Lock()
+	conn := c.conn
+	c.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	return errors.WithStack(wsutil.WriteClientText(conn, m.Marshal()))
+}
+
+// writeState writes subscribe or meta message,
+// while reconnecting it is not an error, they are sent after reconnect.
+func (c *Client) writeState(m *amp.Msg) error {
+	if err := c.write(m); err != ErrNotConnected {
+		return err
+	}
+	return nil
+}
+
+// Close closes connection and stops reconnecting.
+func (c *Client) Close() error {
+	c.cancel()
+	<-c.closed
+	return nil
+}
+
+// lockedWriter is used for control frames (pong, close) written from the read loop
+type lockedWriter struct {
+	c *Client
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.c.writeLock.Lock()
+	defer w.c.writeLock.Unlock()
+	w.c.Lock()
+	conn := w.c.conn
+	w.c.Unlock()
+	if conn == nil {
+		return 0, ErrNotConnected
+	}
+	return conn.Write(p)
+}
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/amp/broker"
+	"github.com/minus5/svckit/amp/session"
+	"github.com/minus5/svckit/amp/ws"
+	"github.com/minus5/svckit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoRequester responds with request body, or error for uri "fail"
+type echoRequester struct{}
+
+func (r *echoRequester) Send(s amp.Subscriber, m *amp.Msg) {
+	if m.URI == "fail" {
+		s.Send(m.ResponseError(errors.New("failed")))
+		return
+	}
+	var o interface{}
+	_ = m.Unmarshal(&o)
+	s.Send(m.Response(o))
+}
+func (r *echoRequester) Unsubscribe(amp.Subscriber) {}
+func (r *echoRequester) Wait()                      {}
+
+func TestMain(m *testing.M) {
+	log.Discard()
+	os.Exit(m.Run())
+}
+
+type testServer struct {
+	url    string
+	broker *broker.Broker
+	conns  chan *ws.Conn
+}
+
+// newTestServer starts ws listener, it is closed at the test cleanup
+func newTestServer(t *testing.T) *testServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &testServer{
+		url:    fmt.Sprintf("ws://%s/", ln.Addr().String()),
+		broker: broker.New(nil, nil),
+		conns:  make(chan *ws.Conn, 16),
+	}
+	sessions := session.Factory(ctx, s.broker, &echoRequester{}, session.AllowAll)
+	done := make(chan struct{})
+	go func() {
+		ws.Listen(ctx, ln, func(c *ws.Conn) {
+			s.conns <- c
+			sessions.Serve(c)
+		})
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return s
+}
+
+// updates collects topic updates
+type updates struct {
+	list []Update
+	sync.Mutex
+}
+
+func (u *updates) handler(up Update) {
+	u.Lock()
+	defer u.Unlock()
+	u.list = append(u.list, up)
+}
+
+func (u *updates) wait(t *testing.T, n int) []Update {
+	for i := 0; i < 200; i++ {
+		u.Lock()
+		l := len(u.list)
+		u.Unlock()
+		if l >= n {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	u.Lock()
+	defer u.Unlock()
+	require.Len(t, u.list, n)
+	return append([]Update(nil), u.list...)
+}
+
+func TestClient(t *testing.T) {
+	srv := newTestServer(t)
+	srv.broker.Publish(amp.NewPublish("a", "", 1, amp.Full, map[string]interface{}{"x": 1, "y": map[string]int{"z": 2}}))
+	srv.broker.Publish(amp.NewPublish("a", "", 2, amp.Diff, map[string]interface{}{"x": nil, "y": map[string]int{"w": 3}}))
+	srv.broker.Publish(amp.NewPublish("a", "", 3, amp.Diff, map[string]interface{}{"v": 4}))
+
+	c, err := Dial(context.Background(), srv.url, ReconnectInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	defer c.Close()
+
+	// burst is delivered as single update
+	ups := &updates{}
+	require.NoError(t, c.Subscribe("a", 0, ups.handler))
+	u := ups.wait(t, 1)[0]
+	assert.Equal(t, "a", u.Topic)
+	assert.Equal(t, int64(3), u.Ts)
+	assert.Len(t, u.Msgs, 3)
+	assert.Equal(t, map[string]interface{}{"y": map[string]interface{}{"z": 2.0, "w": 3.0}, "v": 4.0}, u.State)
+
+	// request response
+	var rsp map[string]int
+	require.NoError(t, c.Request(context.Background(), "echo", map[string]int{"a": 1}, &rsp))
+	assert.Equal(t, map[string]int{"a": 1}, rsp)
+	err = c.Request(context.Background(), "fail", nil, nil)
+	var ae *amp.Error
+	require.True(t, errors.As(err, &ae))
+	assert.Equal(t, amp.ApplicationError, ae.Source)
+	assert.Equal(t, "failed", ae.Message)
+
+	// after reconnect client gets only missed diffs
+	(<-srv.conns).Close()
+	srv.broker.Publish(amp.NewPublish("a", "", 4, amp.Diff, map[string]interface{}{"v": 5}))
+	u = ups.wait(t, 2)[1]
+	assert.Equal(t, int64(4), u.Ts)
+	assert.Equal(t, amp.Diff, u.UpdateType)
+	assert.Equal(t, 5.0, u.State["v"])
+}
+
+func TestPublish(t *testing.T) {
+	c := &Client{
+		subscriptions: make(map[string]*subscription),
+		topics:        make(map[string]*topic),
+	}
+	ups := &updates{}
+	c.subscriptions["e_*"] = &subscription{handler: ups.handler}
+	c.subscriptions["m"] = &subscription{handler: ups.handler}
+
+	publish := func(m *amp.Msg) {
+		if h, u := c.publish(m); h != nil {
+			h(u)
+		}
+	}
+	publish(amp.NewPublish("e_1", "", 1, amp.Append, nil))
+	publish(amp.NewPublish("m", "", 10, amp.Full, map[string]int{"a": 1}))
+	publish(amp.NewPublish("other", "", 1, amp.Full, nil))
+	assert.Len(t, ups.list, 2)
+	assert.Equal(t, map[string]int64{"e_*": 0, "e_1": 1, "m": 10}, c.subscriptionsMap())
+
+	publish(&amp.Msg{Type: amp.Publish, URI: "m", Ts: 11, UpdateType: amp.Close})
+	require.Len(t, ups.list, 3)
+	assert.True(t, ups.list[2].Closed)
+	state, _ := c.State("m")
+	assert.Nil(t, state)
+	assert.Equal(t, map[string]int64{"e_*": 0, "e_1": 1}, c.subscriptionsMap())
+}
+
+func TestAppendState(t *testing.T) {
+	c := &Client{
+		subscriptions: make(map[string]*subscription),
+		topics:        make(map[string]*topic),
+	}
+	ups := &updates{}
+	c.subscriptions["chat"] = &subscription{handler: ups.handler}
+	for ts, typ := range []uint8{amp.Append, amp.Append, amp.Update} {
+		m := amp.NewPublish("chat", "", int64(ts+1), typ, map[string]int{"id": ts})
+		if h, u := c.publish(m); h != nil {
+			h(u)
+		}
+	}
+	// append and update messages are only passed to the handler
+	require.Len(t, ups.list, 3)
+	for i, u := range ups.list {
+		assert.Nil(t, u.State)
+		require.Len(t, u.Msgs, 1)
+		assert.Equal(t, int64(i+1), u.Msgs[0].Ts)
+	}
+	state, ts := c.State("chat")
+	assert.Nil(t, state)
+	assert.Equal(t, int64(3), ts)
+}
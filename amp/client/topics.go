@@ -0,0 +1,131 @@
+package client
+
+import (
+	"encoding/json"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/log"
+	"github.com/minus5/svckit/pkg/jsonu"
+)
+
+// Update of the subscribed topic, passed to the Handler.
+type Update struct {
+	Topic      string
+	Ts         int64                  // ts of the last message
+	UpdateType uint8                  // update type of the last message
+	Msgs       []*amp.Msg             // messages in update, burst is delivered as single update
+	State      map[string]interface{} // merged topic state after Full and Diff messages, valid only during handler call, nil for Append and Update topics
+	Closed     bool                   // topic is closed by server, subscription is removed
+}
+
+// Handler is called for each topic update.
+// Handlers are called sequentially from the client read loop.
+// Client keeps state only for Full and Diff topics. Append and Update messages
+// are passed in Msgs and don't change State, handler keeps its own list
+// (server sends the last messages of the topic on subscribe).
+type Handler func(Update)
+
+// subscription is client subscription to the topic name or pattern (ending with '*')
+type subscription struct {
+	ts      int64
+	handler Handler
+}
+
+// topic state of the topic we got messages for
+type topic struct {
+	name    string
+	ts      int64
+	state   map[string]interface{}
+	burst   []*amp.Msg // messages between BurstStart and BurstEnd
+	inBurst bool
+}
+
+// subscriptions returns subscribe message map, ts of the known topics
+// subscribed by pattern are sent too so we get only missed messages after reconnect.
+func (c *Client) subscriptionsMap() map[string]int64 {
+	subs := make(map[string]int64)
+	for name, s := range c.subscriptions {
+		subs[name] = s.ts
+	}
+	for name, t := range c.topics {
+		if _, ok := c.subscriptions[name]; ok {
+			subs[name] = t.ts
+			continue
+		}
+		if _, ok := c.findSubscription(name); ok {
+			subs[name] = t.ts
+		}
+	}
+	return subs
+}
+
+// findSubscription finds exact or the longest pattern subscription for the topic
+func (c *Client) findSubscription(name string) (*subscription, bool) {
+	return amp.MatchPattern(c.subscriptions, name)
+}
+
+// publish applies message to the topic state,
+// returns update and handler to call, or nil handler if there is nothing to call.
+// Must be called under c.Lock.
+func (c *Client) publish(m *amp.Msg) (Handler, Update) {
+	s, ok := c.findSubscription(m.URI)
+	if !ok {
+		return nil, Update{}
+	}
+	t, ok := c.topics[m.URI]
+	if !ok {
+		t = &topic{name: m.URI}
+		c.topics[m.URI] = t
+	}
+	if m.Ts != 0 {
+		t.ts = m.Ts
+	}
+	if es, ok := c.subscriptions[m.URI]; ok {
+		es.ts = t.ts
+	}
+
+	switch m.UpdateType {
+	case amp.BurstStart:
+		t.inBurst = true
+		t.burst = nil
+		return nil, Update{}
+	case amp.BurstEnd:
+		t.inBurst = false
+		msgs := t.burst
+		t.burst = nil
+		if len(msgs) == 0 {
+			return nil, Update{}
+		}
+		last := msgs[len(msgs)-1]
+		return s.handler, Update{Topic: t.name, Ts: t.ts, UpdateType: last.UpdateType, Msgs: msgs, State: t.state}
+	case amp.Close:
+		delete(c.topics, m.URI)
+		delete(c.subscriptions, m.URI)
+		return s.handler, Update{Topic: t.name, Ts: t.ts, UpdateType: m.UpdateType, Msgs: []*amp.Msg{m}, Closed: true}
+	case amp.Full:
+		t.state = make(map[string]interface{})
+		t.apply(m)
+	case amp.Diff:
+		if t.state == nil {
+			t.state = make(map[string]interface{})
+		}
+		t.apply(m)
+	}
+	if t.inBurst {
+		t.burst = append(t.burst, m)
+		return nil, Update{}
+	}
+	return s.handler, Update{Topic: t.name, Ts: t.ts, UpdateType: m.UpdateType, Msgs: []*amp.Msg{m}, State: t.state}
+}
+
+func (t *topic) apply(m *amp.Msg) {
+	if len(m.Body()) == 0 {
+		return
+	}
+	var diff map[string]interface{}
+	if err := json.Unmarshal(m.Body(), &diff); err != nil {
+		log.S("topic", t.name).Error(err)
+		return
+	}
+	jsonu.JsonMerge(t.state, diff)
+}
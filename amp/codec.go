@@ -0,0 +1,288 @@
+package amp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/minus5/svckit/log"
+)
+
+// supported wire codecs
+const (
+	CodecJSON    uint8 = iota // header and body as JSON, separated by new line
+	CodecMsgpack              // header and body as two consecutive MessagePack values
+)
+
+// CodecQueryKey is the name of the ws query string parameter used to select codec.
+const CodecQueryKey = "codec"
+
+var codecNames = map[string]uint8{
+	"json":    CodecJSON,
+	"msgpack": CodecMsgpack,
+}
+
+// ParseCodecName returns codec for the name.
+// Unknown or empty name falls back to CodecJSON.
+func ParseCodecName(name string) uint8 {
+	if c, ok := codecNames[strings.ToLower(name)]; ok {
+		return c
+	}
+	return CodecJSON
+}
+
+// CodecName returns name for the codec.
+func CodecName(codec uint8) string {
+	for name, c := range codecNames {
+		if c == codec {
+			return name
+		}
+	}
+	return "json"
+}
+
+// IsBinaryCodec returns true for codecs which should be sent in websocket binary frames.
+func IsBinaryCodec(codec uint8) bool {
+	return codec == CodecMsgpack
+}
+
+// MarshalCodec packs message for the client with compatibility version using codec.
+func (m *Msg) MarshalCodec(version, codec uint8) []byte {
+	buf, _ := m.marshal(CompressionNone, version, codec, false)
+	return buf
+}
+
+// MarshalDeflateCodec packs and compress message for the client with compatibility version using codec.
+func (m *Msg) MarshalDeflateCodec(version, codec uint8) ([]byte, bool) {
+	return m.marshal(CompressionDeflate, version, codec, false)
+}
+
+// MarshalForBackendCodec packs message for backend to backend communication using codec.
+// ParseFromBackend recognizes codec so consumers don't need to know which one is used.
+func (m *Msg) MarshalForBackendCodec(codec uint8) []byte {
+	buf, _ := m.marshal(CompressionNone, CompatibilityVersionDefault, codec, true)
+	return buf
+}
+
+func parseMsgpack(buf []byte, backend bool) *Msg {
+	if buf == nil {
+		return nil
+	}
+	d := &msgpackDecoder{buf: buf}
+	h, err := d.value()
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	header, ok := h.(map[string]interface{})
+	if !ok {
+		log.Errorf("msgpack header is not a map")
+		return nil
+	}
+	m := &Msg{}
+	if err := m.setMsgpackHeader(header, backend); err != nil {
+		log.Error(err)
+		return nil
+	}
+	if d.more() {
+		body, err := d.value()
+		if err != nil {
+			log.Error(err)
+			return nil
+		}
+		if m.body, err = json.Marshal(body); err != nil {
+			log.Error(err)
+			return nil
+		}
+	}
+	return m
+}
+
+func (m *Msg) msgpackPayload(backend bool) []byte {
+	e := &msgpackEncoder{}
+	m.msgpackHeader(e, backend)
+	var body []byte
+	if m.body != nil {
+		body = m.body
+	}
+	if m.src != nil {
+		body, _ = m.src.MarshalJSON()
+	}
+	if len(body) == 0 {
+		return e.buf
+	}
+	var o interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&o); err != nil {
+		log.Error(err)
+		return e.buf
+	}
+	if err := e.value(o); err != nil {
+		log.Error(err)
+	}
+	return e.buf
+}
+
+// msgpackHeader encodes header fields with the same keys and
+// omitempty rules as json/backend struct tags of the Msg.
+func (m *Msg) msgpackHeader(e *msgpackEncoder, backend bool) {
+	type field struct {
+		key   string
+		value interface{}
+	}
+	var fs []field
+	add := func(key string, value interface{}, empty bool) {
+		if !empty {
+			fs = append(fs, field{key, value})
+		}
+	}
+	add("t", m.Type, m.Type == 0)
+	add("r", m.ReplyTo, m.ReplyTo == "")
+	add("i", m.CorrelationID, m.CorrelationID == 0)
+	if m.Error != nil {
+		em := make(map[string]interface{})
+		if m.Error.Source != 0 {
+			em["s"] = m.Error.Source
+		}
+		if m.Error.Message != "" {
+			em["m"] = m.Error.Message
+		}
+		if m.Error.Code != 0 {
+			em["c"] = m.Error.Code
+		}
+		add("e", em, false)
+	}
+	add("u", m.URI, m.URI == "")
+	add("s", m.Ts, m.Ts == 0)
+	add("p", m.UpdateType, m.UpdateType == 0)
+	add("l", m.Replay, m.Replay == 0)
+	add("b", m.Subscriptions, len(m.Subscriptions) == 0)
+	add("d", m.CacheDepth, m.CacheDepth == 0)
+	add("m", m.Meta, len(m.Meta) == 0)
+	if backend {
+		add("h", m.BackendHeaders, len(m.BackendHeaders) == 0)
+	}
+
+	e.mapLen(len(fs))
+	for _, f := range fs {
+		e.string(f.key)
+		_ = e.value(f.value)
+	}
+}
+
+func (m *Msg) setMsgpackHeader(h map[string]interface{}, backend bool) error {
+	r := &headerReader{h: h}
+	m.Type = uint8(r.int("t"))
+	m.ReplyTo = r.string("r")
+	m.CorrelationID = uint64(r.int("i"))
+	if em := r.mp("e"); em != nil {
+		er := &headerReader{h: em}
+		m.Error = &Error{
+			Source:  uint8(er.int("s")),
+			Message: er.string("m"),
+			Code:    int(er.int("c")),
+		}
+		if er.err != nil {
+			return er.err
+		}
+	}
+	m.URI = r.string("u")
+	m.Ts = r.int("s")
+	m.UpdateType = uint8(r.int("p"))
+	m.Replay = uint8(r.int("l"))
+	if b := r.mp("b"); b != nil {
+		br := &headerReader{h: b}
+		m.Subscriptions = make(map[string]int64, len(b))
+		for k := range b {
+			m.Subscriptions[k] = br.int(k)
+		}
+		if br.err != nil {
+			return br.err
+		}
+	}
+	m.CacheDepth = int(r.int("d"))
+	m.Meta = r.stringMap("m")
+	if backend {
+		m.BackendHeaders = r.stringMap("h")
+	}
+	return r.err
+}
+
+// headerReader reads typed values from decoded msgpack header,
+// remembers first type mismatch error.
+type headerReader struct {
+	h   map[string]interface{}
+	err error
+}
+
+func (r *headerReader) get(key string) (interface{}, bool) {
+	v, ok := r.h[key]
+	if !ok || v == nil || r.err != nil {
+		return nil, false
+	}
+	return v, true
+}
+
+func (r *headerReader) unexpected(key string, v interface{}) {
+	r.err = fmt.Errorf("msgpack header %s: unexpected type %T", key, v)
+}
+
+func (r *headerReader) int(key string) int64 {
+	v, ok := r.get(key)
+	if !ok {
+		return 0
+	}
+	switch i := v.(type) {
+	case int64:
+		return i
+	case uint64:
+		return int64(i)
+	case float64:
+		return int64(i)
+	}
+	r.unexpected(key, v)
+	return 0
+}
+
+func (r *headerReader) string(key string) string {
+	v, ok := r.get(key)
+	if !ok {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	r.unexpected(key, v)
+	return ""
+}
+
+func (r *headerReader) mp(key string) map[string]interface{} {
+	v, ok := r.get(key)
+	if !ok {
+		return nil
+	}
+	if mp, ok := v.(map[string]interface{}); ok {
+		return mp
+	}
+	r.unexpected(key, v)
+	return nil
+}
+
+func (r *headerReader) stringMap(key string) map[string]string {
+	src := r.mp(key)
+	if src == nil {
+		return nil
+	}
+	dst := make(map[string]string, len(src))
+	for k, v := range src {
+		s, ok := v.(string)
+		if !ok {
+			r.unexpected(key+"."+k, v)
+			return nil
+		}
+		dst[k] = s
+	}
+	return dst
+}
@@ -0,0 +1,100 @@
+package amp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	o := struct {
+		First string
+		Last  string
+		Age   int
+		Score float64
+		Tags  []string
+	}{First: "jozo", Last: "bozo", Age: 42, Score: 1.5, Tags: []string{"a", "b"}}
+	m := NewPublish("hr.mnu5", "resource/method", 123, Full, o)
+	m.Meta = map[string]string{"a": "b"}
+	m.BackendHeaders = map[string]string{"c": "d"}
+
+	buf := m.MarshalCodec(CompatibilityVersionDefault, CodecMsgpack)
+	assert.True(t, isMsgpackMap(buf))
+
+	p := Parse(buf)
+	require.NotNil(t, p)
+	assert.Equal(t, Publish, p.Type)
+	assert.Equal(t, "hr.mnu5/resource/method", p.URI)
+	assert.Equal(t, int64(123), p.Ts)
+	assert.Equal(t, Full, p.UpdateType)
+	assert.Equal(t, map[string]string{"a": "b"}, p.Meta)
+	assert.Nil(t, p.BackendHeaders)
+	assert.JSONEq(t, `{"First":"jozo","Last":"bozo","Age":42,"Score":1.5,"Tags":["a","b"]}`, string(p.Body()))
+}
+
+func TestMsgpackForBackend(t *testing.T) {
+	m := &Msg{
+		Type:           Request,
+		CorrelationID:  4,
+		URI:            "some.topic/method",
+		Subscriptions:  map[string]int64{"topic.one": 1, "topic.two": -1},
+		Error:          &Error{Source: TransportError, Message: "error", Code: 500},
+		BackendHeaders: map[string]string{"a": "b"},
+	}
+	p := ParseFromBackend(m.MarshalForBackendCodec(CodecMsgpack))
+	require.NotNil(t, p)
+	assert.Equal(t, m.Type, p.Type)
+	assert.Equal(t, m.CorrelationID, p.CorrelationID)
+	assert.Equal(t, m.URI, p.URI)
+	assert.Equal(t, m.Subscriptions, p.Subscriptions)
+	assert.Equal(t, m.Error, p.Error)
+	assert.Equal(t, m.BackendHeaders, p.BackendHeaders)
+	assert.Nil(t, p.Body())
+
+	// json is still recognized
+	p = ParseFromBackend(m.MarshalForBackend())
+	require.NotNil(t, p)
+	assert.Equal(t, m.BackendHeaders, p.BackendHeaders)
+}
+
+func TestMsgpackPayloadCache(t *testing.T) {
+	m := &Msg{Type: Publish, URI: "sportsbook/m", Ts: 1, body: []byte(`{"a":1}`)}
+	j := m.Marshal()
+	b := m.MarshalCodec(CompatibilityVersionDefault, CodecMsgpack)
+	assert.NotEqual(t, j, b)
+	assert.Equal(t, j, m.MarshalCodec(CompatibilityVersionDefault, CodecJSON))
+	assert.Equal(t, b, m.MarshalCodec(CompatibilityVersionDefault, CodecMsgpack))
+	assert.Len(t, m.payloads, 2)
+
+	// v1 clients always get json
+	assert.Equal(t, m.MarshalV1(), m.MarshalCodec(CompatibilityVersion1, CodecMsgpack))
+}
+
+func TestMsgpackValues(t *testing.T) {
+	values := []interface{}{
+		nil, true, false,
+		int64(0), int64(127), int64(128), int64(65536), int64(1 << 40),
+		int64(-1), int64(-33), int64(-129), int64(-40000), int64(-1 << 40),
+		1.25, "", "short", string(make([]byte, 300)),
+		[]interface{}{int64(1), "a", nil},
+		map[string]interface{}{"a": int64(1), "b": []interface{}{}},
+	}
+	for _, v := range values {
+		e := &msgpackEncoder{}
+		require.NoError(t, e.value(v))
+		d := &msgpackDecoder{buf: e.buf}
+		got, err := d.value()
+		require.NoError(t, err)
+		assert.Equal(t, v, got)
+		assert.False(t, d.more())
+	}
+}
+
+func TestParseCodecName(t *testing.T) {
+	assert.Equal(t, CodecMsgpack, ParseCodecName("msgpack"))
+	assert.Equal(t, CodecJSON, ParseCodecName("json"))
+	assert.Equal(t, CodecJSON, ParseCodecName(""))
+	assert.Equal(t, CodecJSON, ParseCodecName("cbor"))
+	assert.Equal(t, "msgpack", CodecName(CodecMsgpack))
+}
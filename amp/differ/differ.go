@@ -0,0 +1,143 @@
+// Package differ converts Full messages into Diff messages.
+//
+// Publishers which know only full state of the topic publish Full messages,
+// differ keeps previous state of each topic and emits Diff against it
+// (pkg/jsonu Diff semantics: null deletes key, objects are diffed recursively).
+// Unchanged state is not published at all. Every FullEvery diffs original
+// Full is published, so the broker cache (Full followed by diffs) stays bounded.
+package differ
+
+import (
+	"github.com/minus5/go-simplejson"
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/log"
+	"github.com/minus5/svckit/metric"
+	"github.com/minus5/svckit/pkg/jsonu"
+)
+
+// DefaultFullEvery is default number of diffs between two Full messages.
+const DefaultFullEvery = 100
+
+// Differ keeps the last state of each topic.
+// Not safe for concurrent use, use Pipe or call Msg from single goroutine.
+type Differ struct {
+	fullEvery int
+	topics    map[string]*topic
+}
+
+type topic struct {
+	state *simplejson.Json
+	diffs int // diffs since the last Full
+}
+
+// FullEvery sets number of diffs after which Full is published again.
+func FullEvery(n int) func(*Differ) {
+	return func(d *Differ) {
+		d.fullEvery = n
+	}
+}
+
+// New creates Differ.
+func New(opts ...func(*Differ)) *Differ {
+	d := &Differ{
+		fullEvery: DefaultFullEvery,
+		topics:    make(map[string]*topic),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Msg returns message which should be published instead of m,
+// or nil if topic state is unchanged.
+// Full message is converted to Diff against the previous state of the topic,
+// Diff is merged into the state, Close removes the state,
+// all other messages are returned unchanged.
+func (d *Differ) Msg(m *amp.Msg) *amp.Msg {
+	if m.Type != amp.Publish {
+		return m
+	}
+	switch m.UpdateType {
+	case amp.Full:
+		return d.full(m)
+	case amp.Diff:
+		if t, ok := d.topics[m.URI]; ok {
+			if diff, err := parse(m); err == nil {
+				t.state = merge(t.state, diff)
+			} else {
+				log.S("uri", m.URI).Error(err)
+				delete(d.topics, m.URI) // start again from the next Full
+			}
+		}
+		return m
+	case amp.Close:
+		delete(d.topics, m.URI)
+		return m
+	}
+	return m
+}
+
+func (d *Differ) full(m *amp.Msg) *amp.Msg {
+	state, err := parse(m)
+	if err != nil {
+		log.S("uri", m.URI).Error(err)
+		delete(d.topics, m.URI)
+		return m
+	}
+	t, ok := d.topics[m.URI]
+	if !ok || t.diffs >= d.fullEvery {
+		d.topics[m.URI] = &topic{state: state}
+		metric.Counter("differ.full")
+		return m
+	}
+	diff := jsonu.Diff(t.state, state)
+	t.state = state
+	if jsonu.Empty(diff) {
+		metric.Counter("differ.unchanged")
+		return nil
+	}
+	t.diffs++
+	metric.Counter("differ.diff")
+	return amp.NewPublish(m.Topic(), m.Path(), m.Ts, amp.Diff, diff)
+}
+
+// merge applies diff to the state.
+// jsonu.Merge keeps diff values wrapped in *simplejson.Json which jsonu.Diff
+// can't compare, so merged state is unwrapped by encoding.
+func merge(state, diff *simplejson.Json) *simplejson.Json {
+	buf, err := jsonu.Merge(state, diff).Encode()
+	if err != nil {
+		return state
+	}
+	merged, err := simplejson.NewJson(buf)
+	if err != nil {
+		return state
+	}
+	return merged
+}
+
+// parse returns message body as json
+func parse(m *amp.Msg) (*simplejson.Json, error) {
+	body := m.RawBody()
+	if len(body) == 0 {
+		return simplejson.New(), nil
+	}
+	return simplejson.NewJson(body)
+}
+
+// Pipe converts Full messages from in into Diffs, use it in front of
+// amp/nsq Publisher or broker.
+func Pipe(in <-chan *amp.Msg, opts ...func(*Differ)) <-chan *amp.Msg {
+	d := New(opts...)
+	out := make(chan *amp.Msg)
+	go func() {
+		defer close(out)
+		for m := range in {
+			if m = d.Msg(m); m != nil {
+				out <- m
+			}
+		}
+	}()
+	return out
+}
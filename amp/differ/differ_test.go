@@ -0,0 +1,85 @@
+package differ
+
+import (
+	"testing"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func full(ts int64, o interface{}) *amp.Msg {
+	return amp.NewPublish("a", "b", ts, amp.Full, o)
+}
+
+func body(m *amp.Msg) string {
+	return string(amp.Parse(m.Marshal()).Body())
+}
+
+func TestDiffer(t *testing.T) {
+	d := New(FullEvery(2))
+
+	m := d.Msg(full(1, map[string]interface{}{"x": 1, "y": map[string]int{"z": 2, "w": 3}}))
+	require.NotNil(t, m)
+	assert.Equal(t, amp.Full, m.UpdateType)
+
+	m = d.Msg(full(2, map[string]interface{}{"x": 1, "y": map[string]int{"z": 3}}))
+	require.NotNil(t, m)
+	assert.Equal(t, amp.Diff, m.UpdateType)
+	assert.Equal(t, "a/b", m.URI)
+	assert.Equal(t, int64(2), m.Ts)
+	assert.JSONEq(t, `{"y":{"z":3,"w":null}}`, body(m))
+
+	// unchanged state is not published
+	assert.Nil(t, d.Msg(full(3, map[string]interface{}{"x": 1, "y": map[string]int{"z": 3}})))
+
+	m = d.Msg(full(4, map[string]interface{}{"x": 2, "y": map[string]int{"z": 3}}))
+	assert.Equal(t, amp.Diff, m.UpdateType)
+	assert.JSONEq(t, `{"x":2}`, body(m))
+
+	// after FullEvery diffs Full is sent again
+	m = d.Msg(full(5, map[string]interface{}{"x": 3}))
+	assert.Equal(t, amp.Full, m.UpdateType)
+	assert.JSONEq(t, `{"x":3}`, body(m))
+}
+
+func TestDifferPassThrough(t *testing.T) {
+	d := New()
+	d.Msg(full(1, map[string]int{"x": 1}))
+
+	// publisher diff is merged into the state
+	diff := amp.NewPublish("a", "b", 2, amp.Diff, map[string]int{"y": 2})
+	assert.Equal(t, diff, d.Msg(diff))
+	assert.Nil(t, d.Msg(full(3, map[string]int{"x": 1, "y": 2})))
+	d.Msg(amp.NewPublish("a", "b", 3, amp.Diff, map[string]int{"y": 3}))
+	m := d.Msg(full(3, map[string]int{"x": 1, "y": 4}))
+	assert.Equal(t, amp.Diff, m.UpdateType)
+	assert.JSONEq(t, `{"y":4}`, body(m))
+
+	// after close topic starts with Full again
+	cl := &amp.Msg{Type: amp.Publish, URI: "a/b", UpdateType: amp.Close}
+	assert.Equal(t, cl, d.Msg(cl))
+	m = d.Msg(full(4, map[string]int{"x": 1, "y": 2}))
+	assert.Equal(t, amp.Full, m.UpdateType)
+
+	// parsed messages from backend
+	m = d.Msg(amp.ParseFromBackend(full(5, map[string]int{"x": 2, "y": 2}).MarshalForBackend()))
+	assert.Equal(t, amp.Diff, m.UpdateType)
+	assert.JSONEq(t, `{"x":2}`, body(m))
+
+	other := &amp.Msg{Type: amp.Publish, URI: "c", UpdateType: amp.Append}
+	assert.Equal(t, other, d.Msg(other))
+}
+
+func TestPipe(t *testing.T) {
+	in := make(chan *amp.Msg, 3)
+	in <- full(1, map[string]int{"x": 1})
+	in <- full(2, map[string]int{"x": 1})
+	in <- full(3, map[string]int{"x": 2})
+	close(in)
+	var types []uint8
+	for m := range Pipe(in) {
+		types = append(types, m.UpdateType)
+	}
+	assert.Equal(t, []uint8{amp.Full, amp.Diff}, types)
+}
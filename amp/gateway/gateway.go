@@ -0,0 +1,299 @@
+// Package gateway exposes amp topics and requests over plain http.
+//
+// GET returns current state of the topic built from the broker cache:
+// Full and Diff messages are merged into single json object,
+// Append messages are returned as json array.
+// Topic ts is used as ETag, If-None-Match request gets 304 for unchanged topic.
+// Only exact topic names are allowed, patterns are rejected.
+//
+// POST sends amp Request to the uri with request body and returns response body.
+// Query string values of the keys set by MetaKeys are request Meta.
+//
+// Both are checked by session Authorizer, GET as Subscribe and POST as Request
+// message. Without Authorizer option GET is allowed and POST rejected, same as
+// empty session.TopicWhitelist.
+//
+// Topic name or request uri is the url path without leading slash,
+// use http.StripPrefix when mounting under prefix:
+//
+//	gw := gateway.New(broker, requester, gateway.Authorizer(session.TopicWhitelist(topics)))
+//	router.HandlePath("/amp/", http.StripPrefix("/amp", gw))
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/amp/rpc"
+	"github.com/minus5/svckit/amp/session"
+	"github.com/minus5/svckit/httpu"
+	"github.com/minus5/svckit/log"
+	"github.com/minus5/svckit/pkg/jsonu"
+)
+
+const (
+	// DefaultTimeout is max time to wait for the request response.
+	DefaultTimeout = 30 * time.Second
+
+	maxBodySize = 1000000
+)
+
+// Replayer returns current messages of the topic, implemented by broker.Broker.
+type Replayer interface {
+	Replay(name string) []*amp.Msg
+}
+
+// Handler is http handler for amp topics and requests.
+type Handler struct {
+	replayer   Replayer
+	requester  rpc.Requester
+	timeout    time.Duration
+	authorizer session.Authorizer
+	metaKeys   []string
+}
+
+// Timeout sets max time to wait for the request response.
+func Timeout(d time.Duration) func(*Handler) {
+	return func(h *Handler) {
+		h.timeout = d
+	}
+}
+
+// Authorizer sets authorizer for topics and requests.
+func Authorizer(a session.Authorizer) func(*Handler) {
+	return func(h *Handler) {
+		h.authorizer = a
+	}
+}
+
+// MetaKeys sets query string keys which are passed to the request Meta.
+// Meta is seen by the backend as session meta so whitelist only keys
+// which backend doesn't use as identity.
+func MetaKeys(keys ...string) func(*Handler) {
+	return func(h *Handler) {
+		h.metaKeys = keys
+	}
+}
+
+// New creates handler.
+// Without replayer GET is not allowed, without requester POST.
+func New(replayer Replayer, requester rpc.Requester, opts ...func(*Handler)) *Handler {
+	h := &Handler{
+		replayer:   replayer,
+		requester:  requester,
+		timeout:    DefaultTimeout,
+		authorizer: session.TopicWhitelist(nil),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// ServeHTTP returns topic state on GET and sends request on POST.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if name == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && h.replayer != nil:
+		h.get(w, r, name)
+	case r.Method == http.MethodPost && h.requester != nil:
+		h.post(w, r, name)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, name string) {
+	if amp.IsPattern(name) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	m := &amp.Msg{Type: amp.Subscribe, Subscriptions: map[string]int64{name: 0}}
+	if !h.authorize(w, r, m, h.meta(r), name) {
+		return
+	}
+	msgs := h.replayer.Replay(name)
+	if len(msgs) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var ts int64
+	for _, m := range msgs {
+		if m.Ts > ts {
+			ts = m.Ts
+		}
+	}
+	etag := strconv.Quote(strconv.FormatInt(ts, 10))
+	w.Header().Set("ETag", etag)
+	if !httpu.NoneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	buf, err := json.Marshal(state(msgs))
+	if err != nil {
+		log.S("topic", name).Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(buf)
+}
+
+func (h *Handler) post(w http.ResponseWriter, r *http.Request, uri string) {
+	defer r.Body.Close()
+	buf, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(buf) > maxBodySize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	var req interface{}
+	if len(buf) > 0 {
+		if !json.Valid(buf) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		req = json.RawMessage(buf)
+	}
+	m := amp.NewRequest(uri, req)
+	m.Meta = h.meta(r)
+	if !h.authorize(w, r, m, m.Meta, m.Topic()) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+	rm, err := rpc.CallMsg(ctx, h.requester, m)
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	_, _ = w.Write(rm.RawBody())
+}
+
+// writeError writes amp error as json, error code is used as http status
+func writeError(w http.ResponseWriter, err error) {
+	e := rpc.AsError(err)
+	status := http.StatusInternalServerError
+	switch {
+	case e.Code == rpc.CodeTimeout && e.Source == amp.TransportError:
+		status = http.StatusGatewayTimeout
+	case e.Code >= 400 && e.Code < 600:
+		status = e.Code
+	case e.Source == amp.TransportError:
+		status = http.StatusBadGateway
+	}
+	buf, _ := json.Marshal(e)
+	w.WriteHeader(status)
+	_, _ = w.Write(buf)
+}
+
+// authorize checks message with the authorizer, writes error response if it is rejected
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, m *amp.Msg, meta map[string]string, topic string) bool {
+	err := h.authorizer.Authorize(newClient(r, meta), m, topic)
+	if err == nil {
+		return true
+	}
+	e := rpc.AsError(err)
+	if e.Code == 0 {
+		e.Code = rpc.CodeForbidden
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeError(w, e)
+	return false
+}
+
+// meta returns whitelisted query string values as amp meta
+func (h *Handler) meta(r *http.Request) map[string]string {
+	q := r.URL.Query()
+	var m map[string]string
+	for _, k := range h.metaKeys {
+		if _, ok := q[k]; !ok {
+			continue
+		}
+		if m == nil {
+			m = make(map[string]string)
+		}
+		m[k] = q.Get(k)
+	}
+	return m
+}
+
+// client is http request information for the Authorizer
+type client struct {
+	meta         map[string]string
+	headers      map[string]string
+	cookie       string
+	forwardedFor string
+}
+
+func newClient(r *http.Request, meta map[string]string) *client {
+	c := &client{
+		meta:         meta,
+		headers:      make(map[string]string, len(r.Header)),
+		cookie:       r.Header.Get("Cookie"),
+		forwardedFor: strings.Join(r.Header.Values("X-Forwarded-For"), " "),
+	}
+	for k, v := range r.Header {
+		c.headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	if c.forwardedFor == "" {
+		c.forwardedFor = r.RemoteAddr
+	}
+	return c
+}
+
+func (c *client) Meta() map[string]string    { return c.meta }
+func (c *client) Headers() map[string]string { return c.headers }
+func (c *client) GetCookie() string          { return c.cookie }
+func (c *client) GetRemoteIp() string        { return c.forwardedFor }
+
+// state merges topic messages into the current state.
+// Append messages are collected into the array.
+func state(msgs []*amp.Msg) interface{} {
+	var full map[string]interface{}
+	var list []json.RawMessage
+	for _, m := range msgs {
+		b := m.RawBody()
+		switch m.UpdateType {
+		case amp.Full, amp.Diff:
+			if full == nil || m.UpdateType == amp.Full {
+				full = make(map[string]interface{})
+			}
+			if len(b) == 0 {
+				continue
+			}
+			var diff map[string]interface{}
+			if err := json.Unmarshal(b, &diff); err != nil {
+				log.S("topic", m.URI).Error(err)
+				continue
+			}
+			jsonu.JsonMerge(full, diff)
+		case amp.Append, amp.Update:
+			if len(b) > 0 {
+				list = append(list, json.RawMessage(b))
+			}
+		}
+	}
+	if full != nil {
+		return full
+	}
+	if list == nil {
+		return []json.RawMessage{}
+	}
+	return list
+}
@@ -0,0 +1,124 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/amp/amptest"
+	"github.com/minus5/svckit/amp/broker"
+	"github.com/minus5/svckit/amp/rpc"
+	"github.com/minus5/svckit/amp/session"
+	"github.com/minus5/svckit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func serve(h http.Handler, method, url, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestGet(t *testing.T) {
+	log.Discard()
+	b := broker.New(func(string) {}, nil)
+	amptest.Publish(b,
+		amp.NewPublish("a", "", 1, amp.Full, map[string]interface{}{"x": 1, "y": map[string]int{"z": 1}}),
+		amp.NewPublish("a", "", 2, amp.Diff, map[string]interface{}{"x": nil, "y": map[string]int{"w": 2}}),
+		amp.NewPublish("c", "", 1, amp.Append, 1),
+		amp.NewPublish("c", "", 2, amp.Append, 2),
+	)
+	h := New(b, nil)
+
+	w := serve(h, http.MethodGet, "/a", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	assert.JSONEq(t, `{"y":{"z":1,"w":2}}`, w.Body.String())
+
+	w = serve(h, http.MethodGet, "/a", "", "If-None-Match", `"2"`)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	amptest.Publish(b, amp.NewPublish("a", "", 3, amp.Diff, map[string]int{"x": 3}))
+	w = serve(h, http.MethodGet, "/a", "", "If-None-Match", `"2"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+	assert.JSONEq(t, `{"x":3,"y":{"z":1,"w":2}}`, w.Body.String())
+
+	w = serve(h, http.MethodGet, "/c", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[1,2]`, w.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, serve(h, http.MethodGet, "/b", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(h, http.MethodGet, "/", "").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(h, http.MethodGet, "/*", "").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(h, http.MethodGet, "/a*", "").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(h, http.MethodPost, "/a", "").Code)
+}
+
+func TestAuthorizer(t *testing.T) {
+	log.Discard()
+	b := broker.New(func(string) {}, nil)
+	amptest.Publish(b, amp.NewPublish("a", "", 1, amp.Full, map[string]int{"x": 1}))
+	req := amptest.NewRequester(func(m *amp.Msg) (*amp.Msg, error) {
+		return m.Response(nil), nil
+	})
+
+	// default rejects requests
+	h := New(b, req)
+	assert.Equal(t, http.StatusOK, serve(h, http.MethodGet, "/a", "").Code)
+	w := serve(h, http.MethodPost, "/echo.req/x", `{}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.JSONEq(t, `{"m":"not allowed","c":403}`, w.Body.String())
+	assert.Len(t, req.Requests(), 0)
+
+	h = New(b, req, MetaKeys("lang"), Authorizer(session.AuthorizerFunc(func(c session.Client, m *amp.Msg, topic string) error {
+		if c.Headers()["authorization"] != "secret" {
+			return rpc.NewError(rpc.CodeUnauthorized, "unauthorized")
+		}
+		assert.Equal(t, map[string]string{"lang": "hr"}, c.Meta())
+		return nil
+	})))
+	assert.Equal(t, http.StatusUnauthorized, serve(h, http.MethodGet, "/a", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(h, http.MethodPost, "/echo.req/x", `{}`).Code)
+	assert.Equal(t, http.StatusOK, serve(h, http.MethodGet, "/a?lang=hr", "", "Authorization", "secret").Code)
+	assert.Equal(t, http.StatusOK, serve(h, http.MethodPost, "/echo.req/x?lang=hr&user=1", `{}`, "Authorization", "secret").Code)
+	reqs := req.Requests()
+	assert.Len(t, reqs, 1)
+	assert.Equal(t, map[string]string{"lang": "hr"}, reqs[0].Meta)
+}
+
+func TestPost(t *testing.T) {
+	req := amptest.NewRequester(func(m *amp.Msg) (*amp.Msg, error) {
+		if m.Path() == "missing" {
+			return nil, rpc.NewError(rpc.CodeNotFound, "not found")
+		}
+		var o interface{}
+		if err := m.Unmarshal(&o); err != nil {
+			return nil, err
+		}
+		return m.Response(o), nil
+	})
+	h := New(nil, req, Authorizer(session.AllowAll), MetaKeys("user"))
+
+	w := serve(h, http.MethodPost, "/echo.req/x?user=1&other=2", `{"y":1}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"y":1}`, w.Body.String())
+	reqs := req.Requests()
+	assert.Len(t, reqs, 1)
+	assert.Equal(t, "echo.req/x", reqs[0].URI)
+	assert.Equal(t, map[string]string{"user": "1"}, reqs[0].Meta)
+
+	w = serve(h, http.MethodPost, "/echo.req/missing", `{}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"m":"not found","c":404}`, w.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, serve(h, http.MethodPost, "/echo.req/x", `{`).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(h, http.MethodGet, "/a", "").Code)
+}
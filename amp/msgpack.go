@@ -0,0 +1,403 @@
+package amp
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Minimal MessagePack (https://github.com/msgpack/msgpack/blob/master/spec.md)
+// encoder and decoder. Supports only types which can appear in amp message
+// header or in JSON body: nil, bool, integers, floats, strings, binary,
+// arrays and maps with string keys.
+
+type msgpackEncoder struct {
+	buf []byte
+}
+
+func (e *msgpackEncoder) nil() {
+	e.buf = append(e.buf, 0xc0)
+}
+
+func (e *msgpackEncoder) bool(v bool) {
+	if v {
+		e.buf = append(e.buf, 0xc3)
+		return
+	}
+	e.buf = append(e.buf, 0xc2)
+}
+
+func (e *msgpackEncoder) int(v int64) {
+	if v >= 0 {
+		e.uint(uint64(v))
+		return
+	}
+	switch {
+	case v >= -32:
+		e.buf = append(e.buf, byte(v))
+	case v >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		e.buf = append(e.buf, 0xd1)
+		e.buf = appendUint16(e.buf, uint16(v))
+	case v >= math.MinInt32:
+		e.buf = append(e.buf, 0xd2)
+		e.buf = appendUint32(e.buf, uint32(v))
+	default:
+		e.buf = append(e.buf, 0xd3)
+		e.buf = appendUint64(e.buf, uint64(v))
+	}
+}
+
+func (e *msgpackEncoder) uint(v uint64) {
+	switch {
+	case v <= 0x7f:
+		e.buf = append(e.buf, byte(v))
+	case v <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		e.buf = append(e.buf, 0xcd)
+		e.buf = appendUint16(e.buf, uint16(v))
+	case v <= math.MaxUint32:
+		e.buf = append(e.buf, 0xce)
+		e.buf = appendUint32(e.buf, uint32(v))
+	default:
+		e.buf = append(e.buf, 0xcf)
+		e.buf = appendUint64(e.buf, v)
+	}
+}
+
+func (e *msgpackEncoder) float(v float64) {
+	e.buf = append(e.buf, 0xcb)
+	e.buf = appendUint64(e.buf, math.Float64bits(v))
+}
+
+func (e *msgpackEncoder) string(v string) {
+	l := len(v)
+	switch {
+	case l < 32:
+		e.buf = append(e.buf, 0xa0|byte(l))
+	case l <= math.MaxUint8:
+		e.buf = append(e.buf, 0xd9, byte(l))
+	case l <= math.MaxUint16:
+		e.buf = append(e.buf, 0xda)
+		e.buf = appendUint16(e.buf, uint16(l))
+	default:
+		e.buf = append(e.buf, 0xdb)
+		e.buf = appendUint32(e.buf, uint32(l))
+	}
+	e.buf = append(e.buf, v...)
+}
+
+func (e *msgpackEncoder) bytes(v []byte) {
+	l := len(v)
+	switch {
+	case l <= math.MaxUint8:
+		e.buf = append(e.buf, 0xc4, byte(l))
+	case l <= math.MaxUint16:
+		e.buf = append(e.buf, 0xc5)
+		e.buf = appendUint16(e.buf, uint16(l))
+	default:
+		e.buf = append(e.buf, 0xc6)
+		e.buf = appendUint32(e.buf, uint32(l))
+	}
+	e.buf = append(e.buf, v...)
+}
+
+func (e *msgpackEncoder) arrayLen(l int) {
+	switch {
+	case l < 16:
+		e.buf = append(e.buf, 0x90|byte(l))
+	case l <= math.MaxUint16:
+		e.buf = append(e.buf, 0xdc)
+		e.buf = appendUint16(e.buf, uint16(l))
+	default:
+		e.buf = append(e.buf, 0xdd)
+		e.buf = appendUint32(e.buf, uint32(l))
+	}
+}
+
+func (e *msgpackEncoder) mapLen(l int) {
+	switch {
+	case l < 16:
+		e.buf = append(e.buf, 0x80|byte(l))
+	case l <= math.MaxUint16:
+		e.buf = append(e.buf, 0xde)
+		e.buf = appendUint16(e.buf, uint16(l))
+	default:
+		e.buf = append(e.buf, 0xdf)
+		e.buf = appendUint32(e.buf, uint32(l))
+	}
+}
+
+// value encodes o, o is expected to be result of json.Unmarshal
+// (with or without UseNumber) or one of the header field types.
+func (e *msgpackEncoder) value(o interface{}) error {
+	switch v := o.(type) {
+	case nil:
+		e.nil()
+	case bool:
+		e.bool(v)
+	case int:
+		e.int(int64(v))
+	case int64:
+		e.int(v)
+	case uint8:
+		e.uint(uint64(v))
+	case uint64:
+		e.uint(v)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			e.int(int64(v))
+			return nil
+		}
+		e.float(v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			e.int(i)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		e.float(f)
+	case string:
+		e.string(v)
+	case []byte:
+		e.bytes(v)
+	case []interface{}:
+		e.arrayLen(len(v))
+		for _, i := range v {
+			if err := e.value(i); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		e.mapLen(len(v))
+		for _, k := range sortedKeys(v) {
+			e.string(k)
+			if err := e.value(v[k]); err != nil {
+				return err
+			}
+		}
+	case map[string]string:
+		e.mapLen(len(v))
+		for _, k := range sortedKeys(v) {
+			e.string(k)
+			e.string(v[k])
+		}
+	case map[string]int64:
+		e.mapLen(len(v))
+		for _, k := range sortedKeys(v) {
+			e.string(k)
+			e.int(v[k])
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %T", o)
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type msgpackDecoder struct {
+	buf []byte
+	pos int
+}
+
+var errMsgpackShort = fmt.Errorf("msgpack: unexpected end of data")
+
+func (d *msgpackDecoder) more() bool {
+	return d.pos < len(d.buf)
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.buf) {
+		return nil, errMsgpackShort
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *msgpackDecoder) uintN(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// value decodes next value.
+// Maps are decoded into map[string]interface{}, arrays into []interface{},
+// integers into int64 (or uint64 when they overflow int64).
+func (d *msgpackDecoder) value() (interface{}, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.mapOf(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.arrayOf(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return d.stringOf(int(c & 0x1f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		l, err := d.uintN(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.next(int(l))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), v...), nil
+	case 0xca:
+		v, err := d.uintN(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := d.uintN(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := d.uintN(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if v > math.MaxInt64 {
+			return v, nil
+		}
+		return int64(v), nil
+	case 0xd0:
+		v, err := d.uintN(1)
+		return int64(int8(v)), err
+	case 0xd1:
+		v, err := d.uintN(2)
+		return int64(int16(v)), err
+	case 0xd2:
+		v, err := d.uintN(4)
+		return int64(int32(v)), err
+	case 0xd3:
+		v, err := d.uintN(8)
+		return int64(v), err
+	case 0xd9, 0xda, 0xdb:
+		l, err := d.uintN(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.stringOf(int(l))
+	case 0xdc, 0xdd:
+		l, err := d.uintN(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayOf(int(l))
+	case 0xde, 0xdf:
+		l, err := d.uintN(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapOf(int(l))
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%x", c)
+}
+
+func (d *msgpackDecoder) stringOf(l int) (string, error) {
+	b, err := d.next(l)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (d *msgpackDecoder) arrayOf(l int) ([]interface{}, error) {
+	if l > len(d.buf)-d.pos {
+		return nil, errMsgpackShort
+	}
+	a := make([]interface{}, 0, l)
+	for i := 0; i < l; i++ {
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+	}
+	return a, nil
+}
+
+func (d *msgpackDecoder) mapOf(l int) (map[string]interface{}, error) {
+	if l > len(d.buf)-d.pos {
+		return nil, errMsgpackShort
+	}
+	m := make(map[string]interface{}, l)
+	for i := 0; i < l; i++ {
+		k, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		ks, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("msgpack: unsupported map key %T", k)
+		}
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		m[ks] = v
+	}
+	return m, nil
+}
+
+// isMsgpackMap reports whether buf starts with MessagePack map.
+// Used to distinguish MessagePack from JSON encoded message header,
+// JSON header always starts with '{'.
+func isMsgpackMap(buf []byte) bool {
+	if len(buf) == 0 {
+		return false
+	}
+	c := buf[0]
+	return c&0xf0 == 0x80 || c == 0xde || c == 0xdf
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
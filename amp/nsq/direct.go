@@ -0,0 +1,22 @@
+package nsq
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/env"
+)
+
+// DirectTopic is nsq topic for Direct messages.
+// Every amp edge subscribes to it with its own channel,
+// so the message reaches the sessions regardless of the instance they are connected to.
+// Publisher sends Direct messages to this topic.
+const DirectTopic = "amp.direct"
+
+// SubscribeDirect subscribes to the DirectTopic with the channel of this instance.
+// Use with session.Registry Consume.
+func SubscribeDirect(ctx context.Context) <-chan *amp.Msg {
+	channel := fmt.Sprintf("%s-%s#ephemeral", env.AppName(), env.InstanceId())
+	return subscribe(ctx, []string{DirectTopic}, channel)
+}
@@ -34,7 +34,11 @@ func (p *Publisher) loop(in <-chan *amp.Msg) {
 
 	pub := nsq.Pub("")
 	publish := func(m *amp.Msg) {
-		pub.PublishTo(m.Topic(), m.MarshalForBackend())
+		topic := m.Topic()
+		if m.Type == amp.Direct {
+			topic = DirectTopic
+		}
+		pub.PublishTo(topic, m.MarshalForBackend())
 	}
 
 	for m := range in {
@@ -3,21 +3,38 @@ package nsq
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/minus5/svckit/amp"
 	"github.com/minus5/svckit/env"
 	"github.com/minus5/svckit/log"
+	"github.com/minus5/svckit/metric"
 	"github.com/minus5/svckit/nsq"
 	"github.com/pkg/errors"
 )
 
+const (
+	// DefaultRequestTimeout is max time to wait for the response.
+	DefaultRequestTimeout = 30 * time.Second
+	// TimeoutKey is BackendHeaders or Meta key for overriding request timeout.
+	// Value is duration ("5s", "500ms") or number of milliseconds.
+	// Meta is set by the client so its timeout is limited by MaxRequestTimeout.
+	TimeoutKey = "timeout"
+)
+
+// ErrTimeout is sent as transport error response when request deadline is exceeded.
+var ErrTimeout = errors.New("request timeout")
+
 type Requester struct {
 	topic         string
 	producer      *nsq.Producer
 	consumer      *nsq.Consumer
 	queue         map[uint64]*request // requests in process
 	correlationNo uint64
+	timeout       time.Duration
+	maxTimeout    time.Duration // max timeout from Meta, 0 is same as timeout
 	closed        chan struct{}
 	sync.Mutex
 }
@@ -25,27 +42,39 @@ type Requester struct {
 type request struct {
 	msg    *amp.Msg
 	source amp.Subscriber
+	timer  *time.Timer // fires on request deadline
+}
+
+// RequestTimeout sets default request timeout.
+func RequestTimeout(d time.Duration) func(*Requester) {
+	return func(r *Requester) {
+		r.timeout = d
+	}
 }
 
-func MustRequester(ctx context.Context) *Requester {
-	r, err := NewRequester(ctx)
+// MaxRequestTimeout sets max timeout client can request in Meta,
+// by default client can only shorten the default timeout.
+func MaxRequestTimeout(d time.Duration) func(*Requester) {
+	return func(r *Requester) {
+		r.maxTimeout = d
+	}
+}
+
+func MustRequester(ctx context.Context, opts ...func(*Requester)) *Requester {
+	r, err := NewRequester(ctx, opts...)
 	if err != nil {
 		log.Fatal(err)
 	}
 	return r
 }
 
-func NewRequester(ctx context.Context) (*Requester, error) {
+func NewRequester(ctx context.Context, opts ...func(*Requester)) (*Requester, error) {
 	p, err := nsq.NewProducer("")
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	r := &Requester{
-		producer: p,
-		queue:    make(map[uint64]*request),
-		topic:    resposesTopicName(),
-		closed:   make(chan struct{}),
-	}
+	r := newRequester(opts...)
+	r.producer = p
 	c, err := nsq.NewConsumer(r.topic, r.responses)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -55,6 +84,19 @@ func NewRequester(ctx context.Context) (*Requester, error) {
 	return r, nil
 }
 
+func newRequester(opts ...func(*Requester)) *Requester {
+	r := &Requester{
+		queue:   make(map[uint64]*request),
+		topic:   resposesTopicName(),
+		timeout: DefaultRequestTimeout,
+		closed:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
 func resposesTopicName() string {
 	return fmt.Sprintf("z...rsp-%s-%s", env.AppName(), env.InstanceId())
 }
@@ -70,6 +112,8 @@ func (r *Requester) reply(correlationID uint64, m *amp.Msg) {
 	req, ok := r.queue[correlationID]
 	if ok {
 		delete(r.queue, correlationID)
+		req.timer.Stop()
+		metric.Gauge("requester.queue", len(r.queue))
 	}
 	r.Unlock()
 
@@ -81,16 +125,77 @@ func (r *Requester) reply(correlationID uint64, m *amp.Msg) {
 	return
 }
 
-func (r *Requester) Send(e amp.Subscriber, m *amp.Msg) {
+// expired sends transport error response for the request which deadline is exceeded
+func (r *Requester) expired(correlationID uint64) {
+	r.Lock()
+	req, ok := r.queue[correlationID]
+	r.Unlock()
+	if !ok {
+		return
+	}
+	metric.Counter("requester.timeout")
+	log.S("uri", req.msg.URI).I("correlationID", int(correlationID)).Info("request timeout")
+	r.reply(correlationID, req.msg.ResponseTransportError(ErrTimeout))
+}
+
+// add puts request into the queue and starts deadline timer,
+// returns correlation id and request deadline
+func (r *Requester) add(e amp.Subscriber, m *amp.Msg) (uint64, time.Time) {
 	r.Lock()
+	defer r.Unlock()
 	r.correlationNo++
 	correlationID := r.correlationNo
-	r.queue[correlationID] = &request{msg: m, source: e}
-	r.Unlock()
+	timeout := r.requestTimeout(m)
+	r.queue[correlationID] = &request{
+		msg:    m,
+		source: e,
+		timer:  time.AfterFunc(timeout, func() { r.expired(correlationID) }),
+	}
+	metric.Gauge("requester.queue", len(r.queue))
+	return correlationID, time.Now().Add(timeout)
+}
+
+// requestTimeout returns timeout until deadline set by the caller, timeout
+// from message headers or meta, or default timeout.
+// Meta timeout is limited by maxTimeout.
+func (r *Requester) requestTimeout(m *amp.Msg) time.Duration {
+	if deadline, ok := m.Deadline(); ok {
+		return time.Until(deadline)
+	}
+	if d, ok := parseTimeout(m.BackendHeaders[TimeoutKey]); ok {
+		return d
+	}
+	if d, ok := parseTimeout(m.Meta[TimeoutKey]); ok {
+		max := r.maxTimeout
+		if max == 0 {
+			max = r.timeout
+		}
+		if d > max {
+			return max
+		}
+		return d
+	}
+	return r.timeout
+}
+
+// parseTimeout parses duration or number of milliseconds
+func parseTimeout(v string) (time.Duration, bool) {
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return d, true
+	}
+	if ms, err := strconv.Atoi(v); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond, true
+	}
+	return 0, false
+}
+
+func (r *Requester) Send(e amp.Subscriber, m *amp.Msg) {
+	correlationID, deadline := r.add(e, m)
 
 	rm := m.Request()
 	rm.CorrelationID = correlationID
 	rm.ReplyTo = r.topic
+	rm.SetDeadline(deadline)
 	buf := rm.MarshalForBackend()
 
 	go func() {
@@ -113,9 +218,11 @@ func (r *Requester) Unsubscribe(e amp.Subscriber) {
 	defer r.Unlock()
 	for key, req := range r.queue {
 		if req.source == e {
+			req.timer.Stop()
 			delete(r.queue, key)
 		}
 	}
+	metric.Gauge("requester.queue", len(r.queue))
 }
 
 func (r *Requester) waitDone(ctx context.Context) {
@@ -125,6 +232,9 @@ func (r *Requester) waitDone(ctx context.Context) {
 	r.consumer.Close()
 	r.Lock()
 	defer r.Unlock()
+	for _, req := range r.queue {
+		req.timer.Stop()
+	}
 	r.queue = make(map[uint64]*request)
 	close(r.closed)
 }
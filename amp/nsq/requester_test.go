@@ -0,0 +1,132 @@
+package nsq
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/nsq/nsqtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// responses collects messages sent to the request source
+type responses struct {
+	msgs chan *amp.Msg
+}
+
+func newResponses() *responses {
+	return &responses{msgs: make(chan *amp.Msg, 16)}
+}
+
+func (r *responses) Send(m *amp.Msg) { r.msgs <- m }
+
+func (r *responses) wait(t *testing.T) *amp.Msg {
+	select {
+	case m := <-r.msgs:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	return nil
+}
+
+func queueLen(r *Requester) int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.queue)
+}
+
+func TestRequesterDeadline(t *testing.T) {
+	nsqtest.Start(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := MustRequester(ctx, RequestTimeout(time.Second))
+
+	var (
+		deadline time.Time
+		mu       sync.Mutex
+	)
+	NewResponder(ctx, func(m *amp.Msg) (*amp.Msg, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		deadline, _ = m.Deadline()
+		return m.Response(map[string]string{"ok": "1"}), nil
+	}, []string{"requester.deadline"})
+
+	rsp := newResponses()
+	start := time.Now()
+	r.Send(rsp, &amp.Msg{Type: amp.Request, URI: "requester.deadline/m", CorrelationID: 7})
+	m := rsp.wait(t)
+	assert.Equal(t, uint64(7), m.CorrelationID)
+	assert.Nil(t, m.Error)
+	assert.Equal(t, 0, queueLen(r))
+
+	// backend gets request deadline in BackendHeaders
+	mu.Lock()
+	defer mu.Unlock()
+	assert.WithinDuration(t, start.Add(time.Second), deadline, 100*time.Millisecond)
+}
+
+func TestRequesterTimeout(t *testing.T) {
+	nsqtest.Start(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := MustRequester(ctx, RequestTimeout(10*time.Millisecond))
+
+	// no responder for the topic
+	rsp := newResponses()
+	r.Send(rsp, &amp.Msg{Type: amp.Request, URI: "requester.none/m", CorrelationID: 3})
+	m := rsp.wait(t)
+	assert.Equal(t, uint64(3), m.CorrelationID)
+	require.NotNil(t, m.Error)
+	assert.Equal(t, amp.TransportError, m.Error.Source)
+	assert.Equal(t, ErrTimeout.Error(), m.Error.Message)
+	assert.Equal(t, 0, queueLen(r))
+}
+
+func TestRequesterUnsubscribe(t *testing.T) {
+	nsqtest.Start(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := MustRequester(ctx, RequestTimeout(10*time.Millisecond))
+
+	rsp := newResponses()
+	r.Send(rsp, &amp.Msg{Type: amp.Request, URI: "requester.none/m"})
+	r.Send(rsp, &amp.Msg{Type: amp.Request, URI: "requester.none/m"})
+	assert.Equal(t, 2, queueLen(r))
+	r.Unsubscribe(rsp)
+	assert.Equal(t, 0, queueLen(r))
+
+	// timers are stopped, nothing is sent after unsubscribe
+	time.Sleep(20 * time.Millisecond)
+	assert.Len(t, rsp.msgs, 0)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	r := newRequester(RequestTimeout(time.Second))
+	timeout := func(headers, meta map[string]string) time.Duration {
+		return r.requestTimeout(&amp.Msg{BackendHeaders: headers, Meta: meta})
+	}
+	assert.Equal(t, time.Second, timeout(nil, nil))
+	assert.Equal(t, 100*time.Millisecond, timeout(nil, map[string]string{TimeoutKey: "100"}))
+	assert.Equal(t, 200*time.Millisecond, timeout(nil, map[string]string{TimeoutKey: "200ms"}))
+	assert.Equal(t, time.Second, timeout(nil, map[string]string{TimeoutKey: "invalid"}))
+	// client can't extend default timeout
+	assert.Equal(t, time.Second, timeout(nil, map[string]string{TimeoutKey: "1h"}))
+	// server headers are not limited
+	assert.Equal(t, time.Hour, timeout(map[string]string{TimeoutKey: "1h"}, map[string]string{TimeoutKey: "1s"}))
+
+	r = newRequester(RequestTimeout(time.Second), MaxRequestTimeout(time.Minute))
+	assert.Equal(t, 10*time.Second, timeout(nil, map[string]string{TimeoutKey: "10s"}))
+	assert.Equal(t, time.Minute, timeout(nil, map[string]string{TimeoutKey: "1h"}))
+}
+
+func TestRequestTimeoutDeadline(t *testing.T) {
+	r := newRequester(RequestTimeout(time.Second))
+	m := &amp.Msg{Meta: map[string]string{TimeoutKey: "10ms"}}
+	m.SetDeadline(time.Now().Add(time.Minute))
+	assert.InDelta(t, float64(time.Minute), float64(r.requestTimeout(m)), float64(time.Second))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/amp/router"
 	"github.com/minus5/svckit/log"
 	"github.com/minus5/svckit/nsq"
 )
@@ -11,15 +12,41 @@ import (
 type Responder struct {
 	done    chan struct{}
 	handler func(m *amp.Msg) (*amp.Msg, error)
+	workers int
+	key     router.KeyFunc
 }
 
+// Workers sets number of concurrent handlers, default is 1.
+func Workers(n int) func(*Responder) {
+	return func(r *Responder) {
+		r.workers = n
+	}
+}
+
+// OrderBy sets ordering key for concurrent handlers (router.ByMeta, router.ByURI...).
+// Messages with the same key are handled in order of arrival.
+// Without key messages are handled by any free worker.
+func OrderBy(key router.KeyFunc) func(*Responder) {
+	return func(r *Responder) {
+		r.key = key
+	}
+}
+
+// NewResponder subscribes to topics and calls handler for each message,
+// handler response is sent to the message ReplyTo topic.
+// Use router.Router Serve as handler for routing by uri.
 func NewResponder(ctx context.Context,
 	handler func(m *amp.Msg) (*amp.Msg, error),
-	topics []string) *Responder {
+	topics []string,
+	opts ...func(*Responder)) *Responder {
 
 	r := &Responder{
 		done:    make(chan struct{}),
 		handler: handler,
+		workers: 1,
+	}
+	for _, opt := range opts {
+		opt(r)
 	}
 
 	in := Subscribe(ctx, topics)
@@ -33,17 +60,35 @@ func (r *Responder) loop(in <-chan *amp.Msg) {
 	pub := nsq.Pub("")
 	defer pub.Close()
 
-	for m := range in {
-		rm, err := r.handler(m)
-		if err != nil {
-			rm = m.ResponseError(err)
-		}
-		if rm == nil || m.ReplyTo == "" {
-			continue
+	if r.workers <= 1 {
+		for m := range in {
+			r.handle(pub, m)
 		}
-		if err := pub.PublishTo(m.ReplyTo, rm.MarshalForBackend()); err != nil {
-			log.Error(err)
+		return
+	}
+
+	pool := router.NewPool(r.workers)
+	for m := range in {
+		m := m
+		key := ""
+		if r.key != nil {
+			key = r.key(m)
 		}
+		pool.Go(key, func() { r.handle(pub, m) })
+	}
+	pool.Close()
+}
+
+func (r *Responder) handle(pub *nsq.Producer, m *amp.Msg) {
+	rm, err := r.handler(m)
+	if err != nil {
+		rm = m.ResponseError(err)
+	}
+	if rm == nil || m.ReplyTo == "" {
+		return
+	}
+	if err := pub.PublishTo(m.ReplyTo, rm.MarshalForBackend()); err != nil {
+		log.Error(err)
 	}
 }
 
@@ -32,11 +32,16 @@ func (s *subscriber) onMessage(m *nsq.Message) error {
 }
 
 func Subscribe(ctx context.Context, topics []string) <-chan *amp.Msg {
+	return subscribe(ctx, topics, "")
+}
+
+// subscribe uses default nsq channel if channel is empty
+func subscribe(ctx context.Context, topics []string, channel string) <-chan *amp.Msg {
 	out := make(chan *amp.Msg, 16)
 	s := &subscriber{
 		out: out,
 	}
-	if err := s.subscribe(topics); err != nil {
+	if err := s.subscribe(topics, channel); err != nil {
 		log.Fatal(err)
 	}
 	go s.waitClose(ctx)
@@ -50,9 +55,9 @@ func (s *subscriber) waitClose(ctx context.Context) {
 	close(s.out)
 }
 
-func (s *subscriber) subscribe(topics []string) error {
+func (s *subscriber) subscribe(topics []string, channel string) error {
 	for _, topic := range topics {
-		sub, err := nsq.NewConsumer(topic, s.onMessage, nsq.Ordered())
+		sub, err := s.consumer(topic, channel)
 		if err != nil {
 			return errors.WithStack(err)
 		}
@@ -61,6 +66,13 @@ func (s *subscriber) subscribe(topics []string) error {
 	return nil
 }
 
+func (s *subscriber) consumer(topic, channel string) (*nsq.Consumer, error) {
+	if channel == "" {
+		return nsq.NewConsumer(topic, s.onMessage, nsq.Ordered())
+	}
+	return nsq.NewConsumer(topic, s.onMessage, nsq.Ordered(), nsq.Channel(channel))
+}
+
 func (s *subscriber) close() {
 	for _, sub := range s.subs {
 		sub.Close()
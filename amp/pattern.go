@@ -0,0 +1,43 @@
+package amp
+
+import "strings"
+
+// IsPattern returns true if name is prefix pattern (ends with '*').
+func IsPattern(name string) bool {
+	return strings.HasSuffix(name, "*")
+}
+
+// PatternMatch returns how specific is the pattern match of the name,
+// -1 if name doesn't match.
+// Pattern is exact name, or prefix if it ends with '*'.
+// Exact match is more specific than any prefix, longer prefix is more specific.
+func PatternMatch(pattern, name string) int {
+	if pattern == name {
+		return len(name) + 1
+	}
+	if !IsPattern(pattern) {
+		return -1
+	}
+	prefix := pattern[:len(pattern)-1]
+	if !strings.HasPrefix(name, prefix) {
+		return -1
+	}
+	return len(prefix)
+}
+
+// MatchPattern returns value of the most specific pattern matching name:
+// exact name, then the longest prefix pattern.
+func MatchPattern[V any](patterns map[string]V, name string) (V, bool) {
+	if v, ok := patterns[name]; ok {
+		return v, true
+	}
+	var found V
+	specific := -1
+	for pattern, v := range patterns {
+		if s := PatternMatch(pattern, name); s > specific {
+			found = v
+			specific = s
+		}
+	}
+	return found, specific >= 0
+}
@@ -0,0 +1,28 @@
+package amp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatternMatch(t *testing.T) {
+	assert.True(t, IsPattern("a/*"))
+	assert.False(t, IsPattern("a/b"))
+	assert.Equal(t, -1, PatternMatch("a/b", "a/c"))
+	assert.Equal(t, -1, PatternMatch("b/*", "a/c"))
+	assert.Equal(t, 2, PatternMatch("a/*", "a/c"))
+	assert.Equal(t, 0, PatternMatch("*", "a/c"))
+	assert.Equal(t, 4, PatternMatch("a/c", "a/c"))
+}
+
+func TestMatchPattern(t *testing.T) {
+	patterns := map[string]int{"*": 1, "a/*": 2, "a/b*": 3, "a/bc": 4}
+	for name, expected := range map[string]int{"x": 1, "a/x": 2, "a/b": 3, "a/bcd": 3, "a/bc": 4} {
+		v, ok := MatchPattern(patterns, name)
+		assert.True(t, ok)
+		assert.Equal(t, expected, v, name)
+	}
+	_, ok := MatchPattern(map[string]int{"a/*": 1}, "b")
+	assert.False(t, ok)
+}
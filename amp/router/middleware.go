@@ -0,0 +1,96 @@
+package router
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/log"
+	"github.com/minus5/svckit/metric"
+	"github.com/pkg/errors"
+)
+
+// ErrInternal is returned to the client when handler panics.
+var ErrInternal = errors.New("internal error")
+
+// Recover recovers handler panic, logs it and responds with ErrInternal.
+func Recover() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(m *amp.Msg) (rm *amp.Msg, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					metric.Counter("router.panic")
+					log.S("uri", m.URI).
+						S("panic", fmt.Sprintf("%v", r)).
+						S("stack", string(debug.Stack())).
+						ErrorS("handler panic")
+					rm, err = nil, ErrInternal
+				}
+			}()
+			return next(m)
+		}
+	}
+}
+
+// Logger logs failed requests, and all requests in debug mode.
+func Logger() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(m *amp.Msg) (*amp.Msg, error) {
+			start := time.Now()
+			rm, err := next(m)
+			l := log.S("uri", m.URI).I("duration", int(time.Since(start)/time.Microsecond))
+			if err != nil {
+				l.Error(err)
+				return rm, err
+			}
+			l.Debug("request")
+			return rm, err
+		}
+	}
+}
+
+// Metrics measures handler duration and counts errors, use it with UseRoute.
+// Metric names are prefixed with route pattern where '/' is replaced by '.'
+// and '*' is removed, requests without handler use router.notFound.
+func Metrics() RouteMiddleware {
+	return func(pattern string, next HandlerFunc) HandlerFunc {
+		name := metricName(pattern)
+		return func(m *amp.Msg) (*amp.Msg, error) {
+			start := time.Now()
+			rm, err := next(m)
+			metric.Time(name+".duration", int(time.Since(start).Nanoseconds()))
+			if err != nil {
+				metric.Counter(name + ".error")
+			}
+			return rm, err
+		}
+	}
+}
+
+func metricName(pattern string) string {
+	if pattern == "" {
+		return "router.notFound"
+	}
+	name := strings.Trim(strings.Replace(strings.TrimSuffix(pattern, "*"), "/", ".", -1), ".")
+	if name == "" {
+		name = "all"
+	}
+	return "router." + name
+}
+
+// Auth rejects request when check returns error.
+// Check usually inspects m.Meta (client session metadata)
+// or m.BackendHeaders (set by other backend services).
+func Auth(check func(m *amp.Msg) error) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(m *amp.Msg) (*amp.Msg, error) {
+			if err := check(m); err != nil {
+				metric.Counter("router.auth.rejected")
+				return nil, err
+			}
+			return next(m)
+		}
+	}
+}
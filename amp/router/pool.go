@@ -0,0 +1,106 @@
+package router
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/minus5/svckit/amp"
+)
+
+// KeyFunc returns ordering key for the message.
+// Messages with the same key are handled sequentially in order of arrival,
+// messages with empty key are handled by any free worker.
+type KeyFunc func(m *amp.Msg) string
+
+// ByReplyTo keeps order of requests from the same requester.
+// ReplyTo is one topic per requester instance (all sessions of the app),
+// so its requests are handled sequentially. Prefer ByMeta or ByURI.
+func ByReplyTo(m *amp.Msg) string {
+	return m.ReplyTo
+}
+
+// ByMeta keeps order of requests with the same session Meta value
+// (for example user id). Requests without the value are not ordered.
+func ByMeta(key string) KeyFunc {
+	return func(m *amp.Msg) string {
+		return m.Meta[key]
+	}
+}
+
+// ByTopic keeps order of requests for the same topic.
+func ByTopic(m *amp.Msg) string {
+	return m.Topic()
+}
+
+// ByURI keeps order of requests for the same uri.
+func ByURI(m *amp.Msg) string {
+	return m.URI
+}
+
+// Pool runs functions on fixed number of workers.
+// Functions with the same key are always run by the same worker.
+type Pool struct {
+	workers []chan func()
+	shared  chan func()
+	wg      sync.WaitGroup
+}
+
+// NewPool starts pool with n workers.
+func NewPool(n int) *Pool {
+	if n < 1 {
+		n = 1
+	}
+	p := &Pool{
+		workers: make([]chan func(), n),
+		shared:  make(chan func()),
+	}
+	for i := range p.workers {
+		p.workers[i] = make(chan func(), 16)
+		p.wg.Add(1)
+		go p.work(p.workers[i])
+	}
+	return p
+}
+
+func (p *Pool) work(own <-chan func()) {
+	defer p.wg.Done()
+	shared := p.shared
+	for own != nil || shared != nil {
+		select {
+		case f, ok := <-own:
+			if !ok {
+				own = nil
+				continue
+			}
+			f()
+		case f, ok := <-shared:
+			if !ok {
+				shared = nil
+				continue
+			}
+			f()
+		}
+	}
+}
+
+// Go runs f on worker selected by key, or any free worker for empty key.
+// Blocks while selected worker queue is full.
+func (p *Pool) Go(key string, f func()) {
+	if key == "" {
+		p.shared <- f
+		return
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	p.workers[h.Sum32()%uint32(len(p.workers))] <- f
+}
+
+// Close waits for all queued functions to finish.
+// Go must not be called after Close.
+func (p *Pool) Close() {
+	close(p.shared)
+	for _, w := range p.workers {
+		close(w)
+	}
+	p.wg.Wait()
+}
@@ -0,0 +1,122 @@
+// Package router routes amp backend requests to handlers by message URI.
+//
+// Handlers are registered for exact URI (topic/path) or prefix pattern ending
+// with '*' (for example "math.req/*"), the longest matching pattern wins.
+// Middleware wraps every handler (logging, metrics, panic recovery, auth),
+// handler chain is built once when route or middleware is registered.
+// Pool runs handlers concurrently while keeping order of messages with the same key.
+package router
+
+import (
+	"fmt"
+
+	"github.com/minus5/svckit/amp"
+)
+
+// HandlerFunc handles request message and returns response.
+// Same signature as amp/nsq Responder handler.
+type HandlerFunc func(m *amp.Msg) (*amp.Msg, error)
+
+// Middleware wraps handler.
+type Middleware func(HandlerFunc) HandlerFunc
+
+// RouteMiddleware wraps handler of the route, pattern is the registered
+// route pattern, or empty string for requests without handler.
+type RouteMiddleware func(pattern string, next HandlerFunc) HandlerFunc
+
+// ErrNotFound is returned for requests without registered handler.
+type ErrNotFound struct {
+	URI string
+}
+
+func (e ErrNotFound) Error() string {
+	return fmt.Sprintf("unknown uri %s", e.URI)
+}
+
+// Router routes request messages to handlers by URI.
+type Router struct {
+	routes     map[string]*route
+	notFound   HandlerFunc // notFound handler with middleware
+	middleware []RouteMiddleware
+	current    func(uri string)
+}
+
+// route is registered handler and its middleware chain
+type route struct {
+	pattern string
+	handler HandlerFunc
+	chain   HandlerFunc
+}
+
+// New creates empty router.
+func New() *Router {
+	return &Router{
+		routes:   make(map[string]*route),
+		notFound: notFound,
+	}
+}
+
+// Handle registers handler for the uri, or pattern ending with '*'.
+// Middleware registered by Use is applied to the handler.
+// Handle and Use should be called before router starts serving.
+func (r *Router) Handle(pattern string, h HandlerFunc) {
+	rt := &route{pattern: pattern, handler: h}
+	rt.chain = r.chain(pattern, h)
+	r.routes[pattern] = rt
+}
+
+// Use adds middleware, first added is outermost.
+func (r *Router) Use(mw ...Middleware) {
+	for _, m := range mw {
+		m := m
+		r.UseRoute(func(_ string, next HandlerFunc) HandlerFunc {
+			return m(next)
+		})
+	}
+}
+
+// UseRoute adds middleware which gets route pattern, first added is outermost.
+func (r *Router) UseRoute(mw ...RouteMiddleware) {
+	r.middleware = append(r.middleware, mw...)
+	for _, rt := range r.routes {
+		rt.chain = r.chain(rt.pattern, rt.handler)
+	}
+	r.notFound = r.chain("", notFound)
+}
+
+// chain wraps handler with all middleware
+func (r *Router) chain(pattern string, h HandlerFunc) HandlerFunc {
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		h = r.middleware[i](pattern, h)
+	}
+	return h
+}
+
+// Current sets function called for Current messages (usually broker Replay).
+func (r *Router) Current(f func(uri string)) {
+	r.current = f
+}
+
+// Serve handles message, it is used as amp/nsq Responder handler.
+// Current messages are passed to the current function, all other
+// non request messages are ignored.
+func (r *Router) Serve(m *amp.Msg) (*amp.Msg, error) {
+	if m.IsCurrent() {
+		if r.current != nil {
+			r.current(m.URI)
+		}
+		return nil, nil
+	}
+	if !m.IsRequest() {
+		return nil, nil
+	}
+	rt, ok := amp.MatchPattern(r.routes, m.URI)
+	if !ok {
+		return r.notFound(m)
+	}
+	return rt.chain(m)
+}
+
+func notFound(m *amp.Msg) (*amp.Msg, error) {
+	return nil, ErrNotFound{URI: m.URI}
+}
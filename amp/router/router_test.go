@@ -0,0 +1,178 @@
+package router
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func request(uri string) *amp.Msg {
+	return &amp.Msg{Type: amp.Request, URI: uri}
+}
+
+func named(name string) HandlerFunc {
+	return func(m *amp.Msg) (*amp.Msg, error) {
+		return m.Response(name), nil
+	}
+}
+
+func handledBy(t *testing.T, r *Router, uri string) string {
+	rm, err := r.Serve(request(uri))
+	require.NoError(t, err)
+	var name string
+	require.NoError(t, amp.Parse(rm.Marshal()).Unmarshal(&name))
+	return name
+}
+
+func TestRouterFind(t *testing.T) {
+	r := New()
+	r.Handle("math.req/add", named("add"))
+	r.Handle("math.req/*", named("math"))
+	r.Handle("*", named("all"))
+
+	assert.Equal(t, "add", handledBy(t, r, "math.req/add"))
+	assert.Equal(t, "math", handledBy(t, r, "math.req/sub"))
+	assert.Equal(t, "all", handledBy(t, r, "chat.req/add"))
+}
+
+func TestRouterNotFound(t *testing.T) {
+	r := New()
+	r.Handle("math.req/add", named("add"))
+	_, err := r.Serve(request("math.req/sub"))
+	assert.Equal(t, ErrNotFound{URI: "math.req/sub"}, err)
+}
+
+func TestRouterCurrent(t *testing.T) {
+	r := New()
+	var replayed string
+	r.Current(func(uri string) { replayed = uri })
+	rm, err := r.Serve(amp.NewCurrent("math.v1"))
+	assert.Nil(t, rm)
+	assert.NoError(t, err)
+	assert.Equal(t, "math.v1", replayed)
+
+	// other non request messages are ignored
+	rm, err = r.Serve(&amp.Msg{Type: amp.Publish, URI: "math.v1"})
+	assert.Nil(t, rm)
+	assert.NoError(t, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	log.Discard()
+	r := New()
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(m *amp.Msg) (*amp.Msg, error) {
+				calls = append(calls, name)
+				return next(m)
+			}
+		}
+	}
+	r.Use(Recover(), Logger())
+	r.UseRoute(Metrics())
+	r.Use(trace("first"), trace("second"))
+	r.Use(Auth(func(m *amp.Msg) error {
+		if m.Meta["user"] == "" {
+			return errors.New("not authorized")
+		}
+		return nil
+	}))
+	r.Handle("a/panic", func(m *amp.Msg) (*amp.Msg, error) {
+		panic("boom")
+	})
+	r.Handle("a/ok", named("ok"))
+
+	m := request("a/ok")
+	_, err := r.Serve(m)
+	assert.EqualError(t, err, "not authorized")
+	assert.Equal(t, []string{"first", "second"}, calls)
+
+	m.Meta = map[string]string{"user": "1"}
+	rm, err := r.Serve(m)
+	assert.NoError(t, err)
+	assert.NotNil(t, rm)
+
+	m = request("a/panic")
+	m.Meta = map[string]string{"user": "1"}
+	_, err = r.Serve(m)
+	assert.Equal(t, ErrInternal, err)
+}
+
+func TestRouteMiddleware(t *testing.T) {
+	r := New()
+	r.Handle("math.req/*", named("math"))
+	built := 0
+	var patterns []string
+	r.UseRoute(func(pattern string, next HandlerFunc) HandlerFunc {
+		built++
+		return func(m *amp.Msg) (*amp.Msg, error) {
+			patterns = append(patterns, pattern)
+			return next(m)
+		}
+	})
+	r.Handle("math.req/add", named("add"))
+	assert.Equal(t, 3, built) // existing route, not found and new route
+
+	handledBy(t, r, "math.req/add")
+	handledBy(t, r, "math.req/sub")
+	handledBy(t, r, "math.req/mul")
+	_, err := r.Serve(request("chat.req/add"))
+	assert.Error(t, err)
+	assert.Equal(t, 3, built) // chain is not built per request
+	assert.Equal(t, []string{"math.req/add", "math.req/*", "math.req/*", ""}, patterns)
+}
+
+func TestMetricName(t *testing.T) {
+	assert.Equal(t, "router.math.req.add", metricName("math.req/add"))
+	assert.Equal(t, "router.math.req", metricName("math.req/*"))
+	assert.Equal(t, "router.all", metricName("*"))
+	assert.Equal(t, "router.notFound", metricName(""))
+}
+
+func TestPoolOrder(t *testing.T) {
+	p := NewPool(4)
+	var l sync.Mutex
+	got := make(map[string][]int)
+	for i := 0; i < 100; i++ {
+		i := i
+		key := []string{"a", "b", "c"}[i%3]
+		p.Go(key, func() {
+			if i%7 == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			l.Lock()
+			got[key] = append(got[key], i)
+			l.Unlock()
+		})
+	}
+	p.Close()
+	for _, is := range got {
+		for j := 1; j < len(is); j++ {
+			assert.Less(t, is[j-1], is[j])
+		}
+	}
+	assert.Equal(t, 100, len(got["a"])+len(got["b"])+len(got["c"]))
+}
+
+func TestPoolConcurrent(t *testing.T) {
+	p := NewPool(2)
+	block := make(chan struct{})
+	done := make(chan struct{})
+	// slow handler does not block messages without key
+	p.Go("", func() { <-block })
+	p.Go("", func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("second worker blocked")
+	}
+	close(block)
+	p.Close()
+}
@@ -0,0 +1,63 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/minus5/svckit/amp"
+)
+
+// Requester sends request messages to backend services,
+// implemented by amp/nsq Requester.
+type Requester interface {
+	Send(amp.Subscriber, *amp.Msg)
+	Unsubscribe(amp.Subscriber)
+}
+
+// response is one time subscriber waiting for the response
+type response chan *amp.Msg
+
+func (r response) Send(m *amp.Msg) {
+	select {
+	case r <- m:
+	default:
+	}
+}
+
+// Call sends req to the uri through requester and decodes response into Rsp.
+// Response error is returned as *amp.Error.
+// When ctx is done before response, transport error is returned.
+func Call[Req, Rsp any](ctx context.Context, r Requester, uri string, req Req) (Rsp, error) {
+	var rsp Rsp
+	m, err := CallMsg(ctx, r, amp.NewRequest(uri, req))
+	if err != nil {
+		return rsp, err
+	}
+	if len(m.Body()) == 0 {
+		return rsp, nil
+	}
+	if err := m.Unmarshal(&rsp); err != nil {
+		return rsp, &amp.Error{Source: amp.TransportError, Message: err.Error()}
+	}
+	return rsp, nil
+}
+
+// CallMsg sends request message through requester and waits for the response message.
+// Context deadline is sent with the request, so nested calls of the handler
+// (which pass its context) share the deadline of the original request.
+func CallMsg(ctx context.Context, r Requester, m *amp.Msg) (*amp.Msg, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		m.SetDeadline(deadline)
+	}
+	ch := make(response, 1)
+	r.Send(ch, m)
+	select {
+	case rm := <-ch:
+		if rm.Error != nil {
+			return nil, rm.Error
+		}
+		return rm, nil
+	case <-ctx.Done():
+		r.Unsubscribe(ch)
+		return nil, &amp.Error{Source: amp.TransportError, Code: CodeTimeout, Message: ctx.Err().Error()}
+	}
+}
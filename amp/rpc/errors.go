@@ -0,0 +1,79 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/amp/router"
+)
+
+// Error codes sent in amp.Error Code.
+// Same meaning as http status codes, js sdk exposes them as error.code.
+const (
+	CodeBadRequest   = 400 // body can't be decoded or request is not valid
+	CodeUnauthorized = 401
+	CodeForbidden    = 403
+	CodeNotFound     = 404 // no handler for the uri
+	CodeTimeout      = 408
+	CodeInternal     = 500 // handler panic
+)
+
+// Coder is implemented by errors which carry amp error code.
+type Coder interface {
+	Code() int
+}
+
+// NewError creates application error with code.
+func NewError(code int, format string, a ...interface{}) *amp.Error {
+	return &amp.Error{
+		Source:  amp.ApplicationError,
+		Code:    code,
+		Message: fmt.Sprintf(format, a...),
+	}
+}
+
+// BadRequest creates application error with CodeBadRequest.
+func BadRequest(format string, a ...interface{}) *amp.Error {
+	return NewError(CodeBadRequest, format, a...)
+}
+
+// AsError converts any error into *amp.Error.
+// *amp.Error is returned unchanged, code is taken from Coder errors,
+// known router errors get their codes, all other errors are application errors without code.
+func AsError(err error) *amp.Error {
+	if err == nil {
+		return nil
+	}
+	var ae *amp.Error
+	if errors.As(err, &ae) {
+		return ae
+	}
+	e := &amp.Error{Source: amp.ApplicationError, Message: err.Error()}
+	var c Coder
+	var nf router.ErrNotFound
+	switch {
+	case errors.As(err, &c):
+		e.Code = c.Code()
+	case errors.As(err, &nf):
+		e.Code = CodeNotFound
+	case errors.Is(err, router.ErrInternal):
+		e.Code = CodeInternal
+	}
+	return e
+}
+
+// Errors is router middleware which converts all handler errors with AsError.
+// Add it as the first (outermost) middleware so router errors
+// (unknown uri, recovered panic) get their codes too.
+func Errors() router.Middleware {
+	return func(next router.HandlerFunc) router.HandlerFunc {
+		return func(m *amp.Msg) (*amp.Msg, error) {
+			rm, err := next(m)
+			if err != nil {
+				return nil, AsError(err)
+			}
+			return rm, nil
+		}
+	}
+}
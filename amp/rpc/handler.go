@@ -0,0 +1,84 @@
+// Package rpc implements typed request/response handlers and caller for amp.
+//
+// Handler decodes request body into Req, validates it, calls handler and
+// encodes Rsp as response body. Errors are converted into amp.Error
+// with codes (see AsError), so clients can distinguish them.
+//
+// Call is the Go client side of the same contract, it sends request through
+// amp requester and decodes response into Rsp or returns *amp.Error.
+package rpc
+
+import (
+	"context"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/amp/router"
+)
+
+// Validator is implemented by requests which can check themselves.
+// Validation error is returned to the client with CodeBadRequest
+// (unless it is already *amp.Error).
+type Validator interface {
+	Validate() error
+}
+
+type msgKey struct{}
+
+// Msg returns original request message from the handler context,
+// use it to read Meta or BackendHeaders.
+func Msg(ctx context.Context) *amp.Msg {
+	m, _ := ctx.Value(msgKey{}).(*amp.Msg)
+	return m
+}
+
+// Context returns handler context for the request message.
+// Context is canceled on request deadline set by the requester,
+// handler should pass it to the nested calls.
+func Context(m *amp.Msg) (context.Context, context.CancelFunc) {
+	ctx := context.WithValue(context.Background(), msgKey{}, m)
+	if deadline, ok := m.Deadline(); ok {
+		return context.WithDeadline(ctx, deadline)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Handler creates router handler from the typed handler function.
+// Context passed to the handler expires on request deadline.
+// Req should be value (not pointer) type, Validate is called on *Req.
+func Handler[Req, Rsp any](h func(ctx context.Context, req Req) (Rsp, error)) router.HandlerFunc {
+	return func(m *amp.Msg) (*amp.Msg, error) {
+		var req Req
+		if len(m.Body()) > 0 {
+			if err := m.Unmarshal(&req); err != nil {
+				return nil, BadRequest("invalid request body: %s", err)
+			}
+		}
+		if err := validate(&req); err != nil {
+			return nil, err
+		}
+		ctx, cancel := Context(m)
+		defer cancel()
+		rsp, err := h(ctx, req)
+		if err != nil {
+			return nil, AsError(err)
+		}
+		return m.Response(rsp), nil
+	}
+}
+
+// validate calls Validate on *Req if it is implemented
+func validate(req interface{}) error {
+	v, ok := req.(Validator)
+	if !ok {
+		return nil
+	}
+	err := v.Validate()
+	if err == nil {
+		return nil
+	}
+	ae := AsError(err)
+	if ae.Code == 0 {
+		ae.Code = CodeBadRequest
+	}
+	return ae
+}
@@ -0,0 +1,167 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/amp/router"
+	"github.com/minus5/svckit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type addReq struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+func (r addReq) Validate() error {
+	if r.X < 0 || r.Y < 0 {
+		return errors.New("negative numbers not supported")
+	}
+	return nil
+}
+
+type addRsp struct {
+	Z int `json:"z"`
+}
+
+type codeErr struct{}
+
+func (codeErr) Error() string { return "forbidden" }
+func (codeErr) Code() int     { return CodeForbidden }
+
+// routerRequester sends requests directly to the router, like amp/nsq Requester and Responder would
+type routerRequester struct {
+	router *router.Router
+	delay  time.Duration
+}
+
+func (r *routerRequester) Send(s amp.Subscriber, m *amp.Msg) {
+	rm, err := r.router.Serve(amp.ParseFromBackend(m.Request().MarshalForBackend()))
+	if err != nil {
+		rm = m.ResponseError(err)
+	}
+	time.Sleep(r.delay)
+	s.Send(amp.ParseFromBackend(rm.MarshalForBackend()))
+}
+
+func (r *routerRequester) Unsubscribe(amp.Subscriber) {}
+
+func newRouterRequester() *routerRequester {
+	log.Discard()
+	rtr := router.New()
+	rtr.Use(Errors(), router.Recover())
+	rtr.Handle("math.req/add", Handler(func(ctx context.Context, req addReq) (addRsp, error) {
+		if Msg(ctx).Meta["user"] == "guest" {
+			return addRsp{}, codeErr{}
+		}
+		if req.X+req.Y == 42 {
+			return addRsp{}, errors.New("42 is THE ANSWER")
+		}
+		return addRsp{Z: req.X + req.Y}, nil
+	}))
+	rtr.Handle("math.req/deadline", Handler(func(ctx context.Context, req addReq) (time.Time, error) {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return deadline, errors.New("no deadline")
+		}
+		return deadline, nil
+	}))
+	rtr.Handle("math.req/panic", Handler(func(ctx context.Context, req addReq) (addRsp, error) {
+		panic("boom")
+	}))
+	return &routerRequester{router: rtr}
+}
+
+func errorCode(t *testing.T, err error) (uint8, int) {
+	var ae *amp.Error
+	require.True(t, errors.As(err, &ae), "%v", err)
+	return ae.Source, ae.Code
+}
+
+func TestCall(t *testing.T) {
+	r := newRouterRequester()
+	ctx := context.Background()
+
+	rsp, err := Call[addReq, addRsp](ctx, r, "math.req/add", addReq{X: 1, Y: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 3, rsp.Z)
+
+	_, err = Call[addReq, addRsp](ctx, r, "math.req/add", addReq{X: -1, Y: 2})
+	source, code := errorCode(t, err)
+	assert.Equal(t, amp.ApplicationError, source)
+	assert.Equal(t, CodeBadRequest, code)
+	assert.Equal(t, "negative numbers not supported", err.Error())
+
+	_, err = Call[string, addRsp](ctx, r, "math.req/add", "not an object")
+	_, code = errorCode(t, err)
+	assert.Equal(t, CodeBadRequest, code)
+
+	_, err = Call[addReq, addRsp](ctx, r, "math.req/add", addReq{X: 40, Y: 2})
+	_, code = errorCode(t, err)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "42 is THE ANSWER", err.Error())
+
+	_, err = Call[addReq, addRsp](ctx, r, "math.req/sub", addReq{})
+	_, code = errorCode(t, err)
+	assert.Equal(t, CodeNotFound, code)
+
+	_, err = Call[addReq, addRsp](ctx, r, "math.req/panic", addReq{})
+	_, code = errorCode(t, err)
+	assert.Equal(t, CodeInternal, code)
+
+	m := amp.NewRequest("math.req/add", addReq{X: 1})
+	m.Meta = map[string]string{"user": "guest"}
+	_, err = CallMsg(ctx, r, m)
+	_, code = errorCode(t, err)
+	assert.Equal(t, CodeForbidden, code)
+}
+
+func TestCallTimeout(t *testing.T) {
+	r := newRouterRequester()
+	r.delay = 50 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := Call[addReq, addRsp](ctx, &asyncRequester{r}, "math.req/add", addReq{X: 1, Y: 2})
+	source, code := errorCode(t, err)
+	assert.Equal(t, amp.TransportError, source)
+	assert.Equal(t, CodeTimeout, code)
+}
+
+func TestCallDeadline(t *testing.T) {
+	r := newRouterRequester()
+	_, err := Call[addReq, time.Time](context.Background(), r, "math.req/deadline", addReq{})
+	assert.EqualError(t, err, "no deadline")
+
+	// handler context gets deadline of the caller context
+	deadline := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	got, err := Call[addReq, time.Time](ctx, r, "math.req/deadline", addReq{})
+	require.NoError(t, err)
+	assert.True(t, deadline.Equal(got))
+}
+
+type asyncRequester struct {
+	*routerRequester
+}
+
+func (r *asyncRequester) Send(s amp.Subscriber, m *amp.Msg) {
+	go r.routerRequester.Send(s, m)
+}
+
+func TestAsError(t *testing.T) {
+	ae := &amp.Error{Source: amp.TransportError, Message: "x"}
+	assert.Equal(t, ae, AsError(ae))
+	assert.Nil(t, AsError(nil))
+	assert.Equal(t, CodeForbidden, AsError(codeErr{}).Code)
+	assert.Equal(t, CodeNotFound, AsError(router.ErrNotFound{URI: "a"}).Code)
+
+	// response keeps code of the amp error
+	rm := (&amp.Msg{CorrelationID: 1}).ResponseError(BadRequest("bad %d", 1))
+	assert.Equal(t, &amp.Error{Source: amp.ApplicationError, Code: CodeBadRequest, Message: "bad 1"}, rm.Error)
+}
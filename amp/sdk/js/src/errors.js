@@ -16,9 +16,20 @@ const sources = {
   transport: 1
 };
 
-function create(source, message) {
+// codes set by server side (amp/rpc), same meaning as http status codes
+const codes = {
+  badRequest: 400,
+  unauthorized: 401,
+  forbidden: 403,
+  notFound: 404,
+  timeout: 408,
+  internal: 500,
+};
+
+function create(source, message, code) {
   return {
     message: message,
+    code: code || 0,
     isTransport: source == sources.transport,
     isApplication: source == sources.application,
   };
@@ -30,10 +41,11 @@ function ws(desc) {
 
 function server(msg) {
   let e = msg ? msg.error : {};
-  return create((e.source || sources.application), e.message);
+  return create((e.source || sources.application), e.message, e.code);
 }
 
 module.exports = {
   ws,
   server,
+  codes,
 };
@@ -27,4 +27,12 @@ describe('errors', function() {
     assert(e.isTransport);
   });
 
+  it('should pass server side error code', function() {
+    let msg = {error: {message: "bad request", code: 400}};
+    let e = errors.server(msg);
+    assert.equal(errors.codes.badRequest, e.code);
+    assert(e.isApplication);
+    assert.equal(0, errors.ws("closed").code);
+  });
+
 });
@@ -0,0 +1,113 @@
+package session
+
+import (
+	"errors"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/metric"
+)
+
+// ErrNotAllowed is returned by TopicWhitelist for topics not in the list.
+var ErrNotAllowed = errors.New("not allowed")
+
+// Client is session information available to the Authorizer.
+type Client interface {
+	Meta() map[string]string    // session metadata, set by the client
+	Headers() map[string]string // http headers we got on connection open
+	GetCookie() string
+	GetRemoteIp() string
+}
+
+// Authorizer decides which client messages are accepted by the session.
+// It is consulted on Subscribe (for each topic, including the preSub topic),
+// Request and Meta messages, and on long pooling requests.
+// Subscribe topic can be prefix pattern ending with '*', it subscribes all
+// broker topics with the prefix, allow patterns only to trusted clients.
+// Returned error is sent to the client in the response message,
+// use *amp.Error to control error source and code.
+//
+// Decisions are cached per session by message type and topic,
+// cache is reset when client changes session Meta.
+// Meta messages are never cached so Authorizer can inspect new Meta values.
+type Authorizer interface {
+	Authorize(c Client, m *amp.Msg, topic string) error
+}
+
+// AuthorizerFunc is an adapter to use ordinary function as Authorizer.
+type AuthorizerFunc func(c Client, m *amp.Msg, topic string) error
+
+// Authorize calls f(c, m, topic).
+func (f AuthorizerFunc) Authorize(c Client, m *amp.Msg, topic string) error {
+	return f(c, m, topic)
+}
+
+// AllowAll accepts all client messages.
+var AllowAll Authorizer = AuthorizerFunc(func(Client, *amp.Msg, string) error { return nil })
+
+// TopicWhitelist allows requests only to the topics in the list,
+// pattern subscriptions are rejected, all other messages are allowed.
+// Empty list blocks all requests.
+func TopicWhitelist(topics []string) Authorizer {
+	return AuthorizerFunc(func(_ Client, m *amp.Msg, topic string) error {
+		if amp.IsPattern(topic) {
+			return ErrNotAllowed
+		}
+		if m.Type != amp.Request {
+			return nil
+		}
+		for _, t := range topics {
+			if t == topic {
+				return nil
+			}
+		}
+		return ErrNotAllowed
+	})
+}
+
+type authKey struct {
+	typ   uint8
+	topic string
+}
+
+// authorize checks message topic with the session authorizer
+func (s *session) authorize(m *amp.Msg, topic string) error {
+	if s.authorizer == nil {
+		return nil
+	}
+	if m.Type == amp.Meta {
+		return s.authorizer.Authorize(s, m, topic)
+	}
+	key := authKey{typ: m.Type, topic: topic}
+	if err, ok := s.authCache[key]; ok {
+		return err
+	}
+	err := s.authorizer.Authorize(s, m, topic)
+	if s.authCache == nil {
+		s.authCache = make(map[authKey]error)
+	}
+	s.authCache[key] = err
+	return err
+}
+
+// reject sends error response for the rejected message
+func (s *session) reject(m *amp.Msg, topic string, err error) {
+	s.log().S("topic", topic).I("type", int(m.Type)).Debug("rejected")
+	s.Send(rejection(m, topic, err))
+}
+
+// rejection creates error response for the rejected message
+func rejection(m *amp.Msg, topic string, err error) *amp.Msg {
+	metric.Counter("authRejected")
+	rsp := &amp.Msg{
+		Type:          amp.Response,
+		CorrelationID: m.CorrelationID,
+		URI:           topic,
+	}
+	var ae *amp.Error
+	if errors.As(err, &ae) {
+		rsp.Error = ae
+	} else {
+		rsp.Error = &amp.Error{Source: amp.ApplicationError, Message: err.Error()}
+	}
+	return rsp
+}
@@ -0,0 +1,136 @@
+package session
+
+import (
+	"context"
+	"testing"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingBroker struct {
+	mockBroker
+	subscriptions map[string]int64
+}
+
+func (b *recordingBroker) Subscribe(_ amp.Sender, s map[string]int64) {
+	b.subscriptions = s
+}
+
+func authTestSession(auth Authorizer) (*session, *recordingBroker) {
+	brk := &recordingBroker{}
+	return &session{
+		conn:        &mockConn{},
+		broker:      brk,
+		requester:   &mockRequester{},
+		outMessages: make(chan []*amp.Msg, 16),
+		authorizer:  auth,
+	}, brk
+}
+
+func sent(s *session) []*amp.Msg {
+	var msgs []*amp.Msg
+	for {
+		select {
+		case ms := <-s.outMessages:
+			msgs = append(msgs, ms...)
+		default:
+			return msgs
+		}
+	}
+}
+
+func TestAuthorizeSubscribe(t *testing.T) {
+	s, brk := authTestSession(AuthorizerFunc(func(_ Client, m *amp.Msg, topic string) error {
+		if topic == "private" {
+			return &amp.Error{Source: amp.ApplicationError, Message: "forbidden", Code: 403}
+		}
+		return nil
+	}))
+	s.receive(&amp.Msg{Type: amp.Subscribe, Subscriptions: map[string]int64{"public": 1, "private": 2}})
+
+	assert.Equal(t, map[string]int64{"public": 1}, brk.subscriptions)
+	msgs := sent(s)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, amp.Response, msgs[0].Type)
+	assert.Equal(t, "private", msgs[0].URI)
+	assert.Equal(t, &amp.Error{Source: amp.ApplicationError, Message: "forbidden", Code: 403}, msgs[0].Error)
+}
+
+func TestAuthorizeCache(t *testing.T) {
+	calls := 0
+	s, _ := authTestSession(AuthorizerFunc(func(c Client, m *amp.Msg, topic string) error {
+		calls++
+		if m.Type == amp.Meta && m.Meta["role"] == "admin" {
+			return ErrNotAllowed
+		}
+		return nil
+	}))
+	s.receive(&amp.Msg{Type: amp.Subscribe, Subscriptions: map[string]int64{"a": 0}})
+	s.receive(&amp.Msg{Type: amp.Subscribe, Subscriptions: map[string]int64{"a": 0}})
+	assert.Equal(t, 1, calls)
+
+	// rejected meta doesn't reset cache
+	s.receive(&amp.Msg{Type: amp.Meta, CorrelationID: 5, Meta: map[string]string{"role": "admin"}})
+	assert.Equal(t, 2, calls)
+	msgs := sent(s)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, uint64(5), msgs[0].CorrelationID)
+	assert.Equal(t, ErrNotAllowed.Error(), msgs[0].Error.Message)
+	s.receive(&amp.Msg{Type: amp.Subscribe, Subscriptions: map[string]int64{"a": 0}})
+	assert.Equal(t, 2, calls)
+
+	// accepted meta resets cache
+	s.receive(&amp.Msg{Type: amp.Meta, Meta: map[string]string{"role": "user"}})
+	assert.Equal(t, 3, calls)
+	s.receive(&amp.Msg{Type: amp.Subscribe, Subscriptions: map[string]int64{"a": 0}})
+	assert.Equal(t, 4, calls)
+}
+
+func TestTopicWhitelist(t *testing.T) {
+	a := TopicWhitelist([]string{"req"})
+	assert.NoError(t, a.Authorize(nil, &amp.Msg{Type: amp.Request}, "req"))
+	assert.Equal(t, ErrNotAllowed, a.Authorize(nil, &amp.Msg{Type: amp.Request}, "other"))
+	assert.NoError(t, a.Authorize(nil, &amp.Msg{Type: amp.Subscribe}, "other"))
+	assert.Equal(t, ErrNotAllowed, a.Authorize(nil, &amp.Msg{Type: amp.Subscribe}, "*"))
+	assert.Equal(t, ErrNotAllowed, a.Authorize(nil, &amp.Msg{Type: amp.SubscribeAdd}, "a.*"))
+	assert.NoError(t, AllowAll.Authorize(nil, &amp.Msg{Type: amp.Subscribe}, "*"))
+	assert.Equal(t, ErrNotAllowed, TopicWhitelist(nil).Authorize(nil, &amp.Msg{Type: amp.Request}, "req"))
+}
+
+func TestAuthorizePreSub(t *testing.T) {
+	s, brk := authTestSession(AuthorizerFunc(func(_ Client, m *amp.Msg, topic string) error {
+		if topic == "sportsbook/private" {
+			return ErrNotAllowed
+		}
+		return nil
+	}))
+	s.conn = &mockConn{ReturnMeta: map[string]string{"preSub": "private"}}
+	s.receive(&amp.Msg{Type: amp.Subscribe, Subscriptions: map[string]int64{"a": 1}})
+	assert.Equal(t, map[string]int64{"a": 1}, brk.subscriptions)
+	msgs := sent(s)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "sportsbook/private", msgs[0].URI)
+
+	s.conn = &mockConn{ReturnMeta: map[string]string{"preSub": "public"}}
+	s.receive(&amp.Msg{Type: amp.Subscribe})
+	assert.Equal(t, map[string]int64{"sportsbook/public": 0}, brk.subscriptions)
+}
+
+func TestPoolAuthorize(t *testing.T) {
+	s := Factory(context.Background(), &mockBroker{}, &mockRequester{}, nil)
+	msgs := s.Pool(&amp.Msg{Type: amp.Request, URI: "math.add", CorrelationID: 1})
+	require.Len(t, msgs, 1)
+	assert.Equal(t, uint64(1), msgs[0].CorrelationID)
+	assert.Equal(t, ErrNotAllowed.Error(), msgs[0].Error.Message)
+
+	s = Factory(context.Background(), &mockBroker{}, &mockRequester{}, AuthorizerFunc(func(c Client, m *amp.Msg, topic string) error {
+		if c.Meta()["user"] == "" {
+			return ErrNotAllowed
+		}
+		return nil
+	}))
+	msgs = s.Pool(&amp.Msg{Type: amp.Subscribe, Subscriptions: map[string]int64{"a": 0, "b": 0}})
+	assert.Len(t, msgs, 2)
+}
@@ -21,9 +21,37 @@ type broker interface {
 	Wait()                                  // wait for clean exit
 }
 
+// incrementalBroker is implemented by brokers which can add and remove
+// subscriptions without the whole subscriptions map.
+// For other brokers Subscribe is called with the whole map.
+type incrementalBroker interface {
+	SubscribeAdd(amp.Sender, map[string]int64) // add topics to the subscriptions
+	SubscribeRemove(amp.Sender, []string)      // remove topics from the subscriptions
+}
+
+// resubscribeBroker is implemented by brokers which can subscribe again
+// to the single topic, also one matched by pattern (amp/broker).
+type resubscribeBroker interface {
+	Resubscribe(amp.Sender, map[string]int64) // send topics again from ts
+}
+
+// reasonCloser is implemented by connections which report why they are closed (ws.Conn).
+type reasonCloser interface {
+	CloseWithReason(reason string) error
+}
+
+// connection close reasons, same as in amp/ws
+const (
+	closeWrite    = "write"
+	closeOverflow = "overflow"
+	closeShutdown = "shutdown"
+)
+
 type connection interface {
 	Read() ([]byte, error)                       // get client message
 	Write(payload []byte, deflated bool) error   // send message to the client
+	SetBinary(bool)                              // send messages in binary frames
+	BinarySupported() bool                       // can connection send binary frames (binary codecs)
 	DeflateSupported() bool                      // does websocket connection support per message deflate
 	Headers() map[string]string                  // http headers we got on connection open
 	SetBackendHeaders(headers map[string]string) // set BackendHeaders
@@ -77,32 +105,54 @@ type Sessions struct {
 	wg                 sync.WaitGroup
 	wsConnections      counter
 	poolingConnections counter
-	// topicWhitelist is a list of topics that clients can send requests to.
-	// Empty value means block all.
-	topicWhitelist []string
+	// authorizer decides which client messages are accepted.
+	authorizer Authorizer
+	// overflowPolicy slow consumer strategies for new sessions.
+	overflowPolicy OverflowPolicy
+	// registry indexes sessions for directed messages.
+	// Nil value disables directed messages.
+	registry *Registry
 }
 
 // Factory creates new Sessions factory.
-func Factory(ctx context.Context, broker broker, requester requester, topicWhitelist []string) *Sessions {
+// Use TopicWhitelist authorizer to allow requests only to the listed topics.
+// Nil authorizer is same as empty TopicWhitelist: all requests and pattern
+// subscriptions are blocked, other subscriptions are allowed.
+// Use AllowAll to accept all messages.
+func Factory(ctx context.Context, broker broker, requester requester, authorizer Authorizer) *Sessions {
+	if authorizer == nil {
+		authorizer = TopicWhitelist(nil)
+	}
 	cancelSig, cancelSessions := context.WithCancel(context.Background())
 	s := &Sessions{
-		broker:         broker,
-		requester:      requester,
-		cancelSig:      cancelSig,
-		closed:         make(chan struct{}),
-		topicWhitelist: topicWhitelist,
+		broker:     broker,
+		requester:  requester,
+		cancelSig:  cancelSig,
+		closed:     make(chan struct{}),
+		authorizer: authorizer,
 	}
 
 	go s.waitDone(ctx, cancelSessions)
 	return s
 }
 
+// Overflow sets slow consumer strategies for new sessions.
+// By default connection is closed when session out queue is full.
+func (s *Sessions) Overflow(p OverflowPolicy) {
+	s.overflowPolicy = p
+}
+
+// Registry sets index of the new sessions for directed messages.
+func (s *Sessions) Registry(r *Registry) {
+	s.registry = r
+}
+
 // Serve creates new session for connection.
 // Blocks until connection is closed
 func (s *Sessions) Serve(conn connection) {
 	s.wg.Add(1)
 	s.wsConnections.Up()
-	serve(s.cancelSig, conn, s.requester, s.broker, s.topicWhitelist, amp.CompatibilityVersionDefault)
+	serve(s.cancelSig, conn, s.requester, s.broker, s.authorizer, s.overflowPolicy, s.registry, amp.CompatibilityVersionDefault)
 	s.wg.Done()
 	s.wsConnections.Down()
 }
@@ -112,7 +162,7 @@ func (s *Sessions) Serve(conn connection) {
 func (s *Sessions) ServeV1(conn connection) {
 	s.wg.Add(1)
 	s.wsConnections.Up()
-	serve(s.cancelSig, conn, s.requester, s.broker, s.topicWhitelist, amp.CompatibilityVersion1)
+	serve(s.cancelSig, conn, s.requester, s.broker, s.authorizer, s.overflowPolicy, s.registry, amp.CompatibilityVersion1)
 	s.wg.Done()
 	s.wsConnections.Down()
 }
@@ -148,12 +198,24 @@ func (s *Sessions) Pool(m *amp.Msg) []*amp.Msg {
 		return []*amp.Msg{m.Pong()}
 	case amp.Request:
 		p := newPooler(m.Meta)
+		if err := s.authorizer.Authorize(p, m, m.Topic()); err != nil {
+			return []*amp.Msg{rejection(m, m.URI, err)}
+		}
 		s.requester.Send(p, m)
 		p.waitOne(s.cancelSig, poolInterval)
 		s.requester.Unsubscribe(p)
 		return p.msgs
 	case amp.Subscribe:
 		p := newPooler(m.Meta)
+		for topic := range m.Subscriptions {
+			if err := s.authorizer.Authorize(p, m, topic); err != nil {
+				delete(m.Subscriptions, topic)
+				p.msgs = append(p.msgs, rejection(m, topic, err))
+			}
+		}
+		if len(m.Subscriptions) == 0 && len(p.msgs) > 0 {
+			return p.msgs
+		}
 		s.broker.Subscribe(p, m.Subscriptions)
 		p.wait(s.cancelSig, poolInterval)
 		s.broker.Unsubscribe(p)
@@ -169,6 +231,7 @@ func (s *Sessions) ConnectionsCount() (int, int) {
 func newPooler(meta map[string]string) *pooler {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &pooler{
+		meta:    meta,
 		msgWait: ctx,
 		onMsg:   cancel,
 	}
@@ -204,6 +267,14 @@ func (p *pooler) Headers() map[string]string {
 	return nil
 }
 
+func (p *pooler) GetCookie() string {
+	return ""
+}
+
+func (p *pooler) GetRemoteIp() string {
+	return ""
+}
+
 func (p *pooler) waitOne(app context.Context, interval time.Duration) {
 	select {
 	case <-app.Done():
@@ -0,0 +1,70 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHello(t *testing.T) {
+	out := make(chan []byte, 8)
+	s := &session{
+		conn: &mockConn{out: out},
+		caps: amp.Capabilities{Versions: []int{0}, Codecs: []string{"json"}, Features: amp.AllFeatures},
+	}
+	burst := &amp.Msg{Type: amp.Publish, URI: "a", UpdateType: amp.BurstStart}
+	appendMsg := &amp.Msg{Type: amp.Publish, URI: "a", UpdateType: amp.Append, CacheDepth: 10}
+
+	s.connWrite(burst)
+	assert.Equal(t, amp.BurstStart, amp.Parse(<-out).UpdateType)
+
+	s.receive(amp.Parse(amp.NewHello(amp.Capabilities{
+		Codecs:   []string{"msgpack"},
+		Features: []string{amp.FeatureMeta},
+	}).Marshal()))
+	// response is in the old codec
+	rsp := amp.Parse(<-out)
+	require.NotNil(t, rsp)
+	assert.Equal(t, amp.Hello, rsp.Type)
+	caps, err := rsp.Capabilities()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"msgpack"}, caps.Codecs)
+	assert.Equal(t, []string{amp.FeatureMeta}, caps.Features)
+	assert.Equal(t, caps, s.caps)
+
+	// bursts are not supported
+	s.connWrite(burst)
+	assert.Len(t, out, 0)
+
+	// append depth is not supported, msgpack codec is used
+	s.connWrite(appendMsg)
+	buf := <-out
+	assert.NotEqual(t, byte('{'), buf[0])
+	m := amp.Parse(buf)
+	require.NotNil(t, m)
+	assert.Equal(t, amp.Append, m.UpdateType)
+	assert.Equal(t, 0, m.CacheDepth)
+	assert.Equal(t, 10, appendMsg.CacheDepth)
+}
+
+func TestHelloTextOnly(t *testing.T) {
+	out := make(chan []byte, 8)
+	s := &session{
+		conn: &mockConn{out: out, textOnly: true},
+		caps: amp.Capabilities{Versions: []int{0}, Codecs: []string{"json"}, Features: amp.AllFeatures},
+	}
+	assert.Equal(t, []string{"json"}, s.serverCapabilities().Codecs)
+
+	s.setCodec(amp.CodecMsgpack)
+	assert.Equal(t, []string{"json"}, s.caps.Codecs)
+
+	s.receive(amp.Parse(amp.NewHello(amp.Capabilities{Codecs: []string{"msgpack", "json"}}).Marshal()))
+	caps, err := amp.Parse(<-out).Capabilities()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"json"}, caps.Codecs)
+
+	s.connWrite(&amp.Msg{Type: amp.Publish, URI: "a", UpdateType: amp.Diff})
+	assert.Equal(t, byte('{'), (<-out)[0])
+}
@@ -0,0 +1,62 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type incrementalCallsBroker struct {
+	subscribeCallsBroker
+	added   []map[string]int64
+	removed [][]string
+}
+
+func (b *incrementalCallsBroker) SubscribeAdd(_ amp.Sender, s map[string]int64) {
+	b.added = append(b.added, s)
+}
+
+func (b *incrementalCallsBroker) SubscribeRemove(_ amp.Sender, s []string) {
+	b.removed = append(b.removed, s)
+}
+
+func TestSubscribeAddRemoveFallback(t *testing.T) {
+	s, brk := overflowTestSession(OverflowPolicy{}, nil)
+	s.receive(&amp.Msg{Type: amp.Subscribe, Subscriptions: map[string]int64{"a": 1}})
+	s.receive(&amp.Msg{Type: amp.SubscribeAdd, Subscriptions: map[string]int64{"b": 2, "a": 5}})
+	s.receive(&amp.Msg{Type: amp.SubscribeRemove, Subscriptions: map[string]int64{"a": 0}})
+
+	// broker without incremental methods gets whole subscriptions map
+	require.Len(t, brk.calls, 3)
+	assert.Equal(t, map[string]int64{"a": 1, "b": 2}, brk.calls[1])
+	assert.Equal(t, map[string]int64{"b": 2}, brk.calls[2])
+	assert.Equal(t, map[string]int64{"b": 2}, s.subscriptions)
+}
+
+func TestSubscribeAddRemove(t *testing.T) {
+	s, _ := overflowTestSession(OverflowPolicy{}, nil)
+	brk := &incrementalCallsBroker{}
+	s.broker = brk
+	s.authorizer = AuthorizerFunc(func(_ Client, m *amp.Msg, topic string) error {
+		if topic == "c" {
+			return ErrNotAllowed
+		}
+		return nil
+	})
+
+	s.receive(&amp.Msg{Type: amp.SubscribeAdd, Subscriptions: map[string]int64{"a": 1, "c": 1}})
+	s.receive(&amp.Msg{Type: amp.SubscribeAdd, Subscriptions: map[string]int64{"b": 2}})
+	s.receive(&amp.Msg{Type: amp.SubscribeRemove, Subscriptions: map[string]int64{"a": 0}})
+
+	assert.Len(t, brk.calls, 0)
+	assert.Equal(t, []map[string]int64{{"a": 1}, {"b": 2}}, brk.added)
+	assert.Equal(t, [][]string{{"a"}}, brk.removed)
+	assert.Equal(t, map[string]int64{"b": 2}, s.subscriptions)
+
+	// rejected topic
+	rm := <-s.outMessages
+	require.Len(t, rm, 1)
+	assert.NotNil(t, rm[0].Error)
+}
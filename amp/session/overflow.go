@@ -0,0 +1,169 @@
+package session
+
+import (
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/metric"
+)
+
+// slow consumer strategies, applied when session out queue is full
+const (
+	OverflowClose      uint8 = iota // close connection
+	OverflowDropEvents              // drop Event messages, close if other messages don't fit
+	OverflowResync                  // drop topic messages until queue drains, then resend topic current state
+)
+
+// OverflowMetaKey is the name of the session Meta key used to choose strategy for the session.
+const OverflowMetaKey = "overflow"
+
+var overflowNames = map[string]uint8{
+	"close":  OverflowClose,
+	"drop":   OverflowDropEvents,
+	"resync": OverflowResync,
+}
+
+// OverflowPolicy chooses slow consumer strategy for the messages which don't fit
+// into session out queue.
+// Strategy is found by message topic in Topics, then by session Meta
+// value under OverflowMetaKey ("close", "drop" or "resync"), then Default is used.
+// Messages which are not topic updates (responses, pongs...) always close the connection.
+type OverflowPolicy struct {
+	Default uint8
+	// Topics strategy by topic name, or prefix if it ends with '*'.
+	Topics map[string]uint8
+}
+
+// strategy for the topic, session default is used if there is no topic strategy
+func (p OverflowPolicy) strategy(topic string, def uint8) uint8 {
+	if st, ok := amp.MatchPattern(p.Topics, topic); ok {
+		return st
+	}
+	return def
+}
+
+// sessionDefault returns session strategy chosen by meta, or policy default
+func (p OverflowPolicy) sessionDefault(meta map[string]string) uint8 {
+	if st, ok := overflowNames[meta[OverflowMetaKey]]; ok {
+		return st
+	}
+	return p.Default
+}
+
+// onOverflow applies overflow strategy to the messages which didn't fit into out queue.
+func (s *session) onOverflow(msgs []*amp.Msg) {
+	closeConn := false
+	s.staleLock.Lock()
+	for _, m := range msgs {
+		if m.Type != amp.Publish {
+			closeConn = true
+			continue
+		}
+		switch s.overflowPolicy.strategy(m.URI, s.overflowDefault) {
+		case OverflowDropEvents:
+			if m.UpdateType == amp.Event {
+				metric.Counter("overflow.drop")
+				continue
+			}
+			closeConn = true
+		case OverflowResync:
+			if m.UpdateType == amp.Event {
+				metric.Counter("overflow.drop")
+				continue
+			}
+			if s.stale == nil {
+				s.stale = make(map[string]struct{})
+			}
+			if _, ok := s.stale[m.URI]; !ok {
+				metric.Counter("overflow.resync")
+				s.stale[m.URI] = struct{}{}
+			}
+		default:
+			closeConn = true
+		}
+	}
+	s.staleLock.Unlock()
+	if !closeConn {
+		return
+	}
+	metric.Counter("overflow.close")
+	select {
+	case s.overflow <- struct{}{}:
+	default:
+	}
+}
+
+// dropStale removes messages of the topics waiting for resync.
+func (s *session) dropStale(msgs []*amp.Msg) []*amp.Msg {
+	s.staleLock.Lock()
+	defer s.staleLock.Unlock()
+	if len(s.stale) == 0 {
+		return msgs
+	}
+	var n []*amp.Msg
+	for _, m := range msgs {
+		if _, ok := s.stale[m.URI]; ok && m.Type == amp.Publish {
+			continue
+		}
+		n = append(n, m)
+	}
+	return n
+}
+
+// resync subscribes again to the stale topics when out queue is drained.
+// Client gets the same messages as on the new subscription: broker sends burst
+// with the last Full and Diffs after it (Full replaces everything client had),
+// or the cached messages for append topics.
+// Broker with Resubscribe sends again only the stale topics, other brokers
+// get the subscriptions without stale topics and then with them from the start,
+// so stale topics subscribed by pattern are resynced with the whole pattern.
+func (s *session) resync() {
+	if len(s.outMessages) > cap(s.outMessages)/2 {
+		return
+	}
+	s.staleLock.Lock()
+	stale := s.stale
+	s.stale = nil
+	s.staleLock.Unlock()
+	if len(stale) == 0 {
+		return
+	}
+	metric.Counter("overflow.resynced", len(stale))
+	if b, ok := s.broker.(resubscribeBroker); ok {
+		names := make(map[string]int64)
+		for name := range stale {
+			names[name] = 0
+		}
+		b.Resubscribe(s, names)
+		return
+	}
+
+	without := make(map[string]int64)
+	with := make(map[string]int64)
+	for name, ts := range s.subscriptions {
+		if resyncName(name, stale) {
+			with[name] = 0
+			continue
+		}
+		without[name] = ts
+		with[name] = ts
+	}
+	// first unsubscribe stale topics, then subscribe from the start
+	s.broker.Subscribe(s, without)
+	s.broker.Subscribe(s, with)
+	s.subscriptions = with
+}
+
+// resyncName checks if subscription name is stale topic or pattern matching stale topic
+func resyncName(name string, stale map[string]struct{}) bool {
+	if _, ok := stale[name]; ok {
+		return true
+	}
+	if !amp.IsPattern(name) {
+		return false
+	}
+	for topic := range stale {
+		if amp.PatternMatch(name, topic) >= 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/minus5/svckit/amp"
+	ampbroker "github.com/minus5/svckit/amp/broker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type subscribeCallsBroker struct {
+	mockBroker
+	calls []map[string]int64
+}
+
+func (b *subscribeCallsBroker) Subscribe(_ amp.Sender, s map[string]int64) {
+	b.calls = append(b.calls, s)
+}
+
+func overflowTestSession(p OverflowPolicy, meta map[string]string) (*session, *subscribeCallsBroker) {
+	brk := &subscribeCallsBroker{}
+	overflow := make(chan struct{}, 1)
+	return &session{
+		conn:            &mockConn{ReturnMeta: meta},
+		broker:          brk,
+		requester:       &mockRequester{},
+		outMessages:     make(chan []*amp.Msg, 2),
+		overflow:        overflow,
+		overflowRead:    overflow,
+		overflowPolicy:  p,
+		overflowDefault: p.sessionDefault(meta),
+	}, brk
+}
+
+func diff(uri string, ts int64) *amp.Msg {
+	return &amp.Msg{Type: amp.Publish, URI: uri, Ts: ts, UpdateType: amp.Diff}
+}
+
+func event(uri string) *amp.Msg {
+	return &amp.Msg{Type: amp.Publish, URI: uri, UpdateType: amp.Event}
+}
+
+func closed(s *session) bool {
+	return len(s.overflow) > 0
+}
+
+func TestOverflowClose(t *testing.T) {
+	s, _ := overflowTestSession(OverflowPolicy{}, nil)
+	s.Send(diff("a", 1))
+	s.Send(diff("a", 2))
+	assert.False(t, closed(s))
+	s.Send(diff("a", 3))
+	assert.True(t, closed(s))
+}
+
+func TestOverflowDropEvents(t *testing.T) {
+	s, _ := overflowTestSession(OverflowPolicy{Default: OverflowDropEvents}, nil)
+	s.Send(event("a"))
+	s.Send(event("a"))
+	s.Send(event("a"))
+	assert.False(t, closed(s))
+	assert.Len(t, s.outMessages, 2)
+	s.Send(diff("a", 1))
+	assert.True(t, closed(s))
+}
+
+func TestOverflowResync(t *testing.T) {
+	s, brk := overflowTestSession(OverflowPolicy{Topics: map[string]uint8{"e_*": OverflowResync}}, nil)
+	s.receive(&amp.Msg{Type: amp.Subscribe, Subscriptions: map[string]int64{"a": 1, "e_*": 5}})
+	require.Len(t, brk.calls, 1)
+
+	s.Send(diff("e_1", 6))
+	s.Send(diff("e_1", 7))
+	s.Send(diff("e_1", 8)) // overflow, topic is stale
+	assert.False(t, closed(s))
+	<-s.outMessages
+	s.Send(diff("e_1", 9)) // stale topic is dropped even if there is room in queue
+	assert.Len(t, s.outMessages, 1)
+
+	// when queue drains, stale topic is subscribed again from the start
+	<-s.outMessages
+	s.resync()
+	require.Len(t, brk.calls, 3)
+	assert.Equal(t, map[string]int64{"a": 1}, brk.calls[1])
+	assert.Equal(t, map[string]int64{"a": 1, "e_*": 0}, brk.calls[2])
+	s.Send(diff("e_1", 10))
+	assert.Len(t, s.outMessages, 1)
+
+	// other topics use session default
+	s.Send(diff("a", 2))
+	s.Send(diff("a", 3))
+	assert.True(t, closed(s))
+}
+
+func TestOverflowMeta(t *testing.T) {
+	s, _ := overflowTestSession(OverflowPolicy{}, map[string]string{OverflowMetaKey: "drop"})
+	s.Send(event("a"))
+	s.Send(event("a"))
+	s.Send(event("a"))
+	assert.False(t, closed(s))
+
+	// responses always close
+	s.Send(&amp.Msg{Type: amp.Response})
+	assert.True(t, closed(s))
+}
+
+func receiveMsgs(t *testing.T, s *session) []*amp.Msg {
+	select {
+	case msgs := <-s.outMessages:
+		return msgs
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	return nil
+}
+
+// resyncTestSession creates session with real broker, publish to in
+func resyncTestSession() (*session, chan *amp.Msg) {
+	brk := ampbroker.New(nil, nil)
+	in := make(chan *amp.Msg)
+	brk.Consume(in)
+	overflow := make(chan struct{}, 1)
+	s := &session{
+		conn:           &mockConn{},
+		broker:         brk,
+		requester:      &mockRequester{},
+		outMessages:    make(chan []*amp.Msg, 16),
+		overflow:       overflow,
+		overflowRead:   overflow,
+		overflowPolicy: OverflowPolicy{Default: OverflowResync},
+	}
+	brk.Created(s)
+	return s, in
+}
+
+// Resync is same as new subscription: broker sends the last Full and Diffs
+// after it, never Diffs older than the Full.
+func TestOverflowResyncBroker(t *testing.T) {
+	s, in := resyncTestSession()
+	defer close(in)
+	s.subscribe(map[string]int64{"e": 0})
+	in <- amp.NewPublish("e", "", 1, amp.Full, 1)
+	in <- amp.NewPublish("e", "", 2, amp.Diff, 2)
+	in <- amp.NewPublish("e", "", 3, amp.Full, 3)
+	in <- amp.NewPublish("e", "", 4, amp.Diff, 4)
+	in <- amp.NewPublish("e", "", 5, amp.Diff, 5)
+	for {
+		msgs := receiveMsgs(t, s)
+		if msgs[len(msgs)-1].Ts == 5 {
+			break
+		}
+	}
+
+	s.stale = map[string]struct{}{"e": {}}
+	s.resync()
+	msgs := receiveMsgs(t, s)
+	var got []uint8
+	var ts []int64
+	for _, m := range msgs {
+		got = append(got, m.UpdateType)
+		ts = append(ts, m.Ts)
+	}
+	assert.Equal(t, []uint8{amp.BurstStart, amp.Full, amp.Diff, amp.Diff, amp.BurstEnd}, got)
+	assert.Equal(t, []int64{3, 3, 4, 5, 5}, ts)
+}
+
+// Only stale topic is resynced, other topics matched by the same pattern are unchanged.
+func TestOverflowResyncPattern(t *testing.T) {
+	s, in := resyncTestSession()
+	defer close(in)
+	in <- amp.NewPublish("e_1", "", 1, amp.Full, 1)
+	in <- amp.NewPublish("e_2", "", 1, amp.Full, 1)
+	s.subscribe(map[string]int64{"e_*": 0})
+	receiveMsgs(t, s)
+	receiveMsgs(t, s)
+
+	s.stale = map[string]struct{}{"e_1": {}}
+	s.resync()
+	msgs := receiveMsgs(t, s)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "e_1", msgs[0].URI)
+	assert.Equal(t, amp.Full, msgs[0].UpdateType)
+	assert.Equal(t, map[string]int64{"e_*": 0}, s.subscriptions)
+
+	in <- amp.NewPublish("e_2", "", 2, amp.Diff, 2)
+	msgs = receiveMsgs(t, s)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "e_2", msgs[0].URI)
+	assert.Len(t, s.outMessages, 0)
+}
@@ -0,0 +1,168 @@
+package session
+
+import (
+	"sync"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/minus5/svckit/log"
+	"github.com/minus5/svckit/metric"
+)
+
+// Registry indexes sessions by the values of the configured BackendHeaders keys.
+// It delivers Direct messages to the sessions selected by message Meta.
+//
+// Only server set BackendHeaders of the connection are indexed (set them with
+// SetBackendHeaders after authenticating the connection, before Serve).
+// Client set meta is never indexed, any client could set other user's id
+// and receive direct messages of that user.
+// Index is refreshed when session starts and after each amp.Meta message.
+type Registry struct {
+	keys     []string
+	index    map[string]map[string]map[amp.Sender]struct{} // key -> value -> sessions
+	sessions map[amp.Sender]map[string]string              // indexed headers of the session
+	sync.Mutex
+}
+
+// NewRegistry creates registry indexing sessions by BackendHeaders keys.
+func NewRegistry(keys ...string) *Registry {
+	r := &Registry{
+		keys:     keys,
+		index:    make(map[string]map[string]map[amp.Sender]struct{}),
+		sessions: make(map[amp.Sender]map[string]string),
+	}
+	for _, k := range keys {
+		r.index[k] = make(map[string]map[amp.Sender]struct{})
+	}
+	return r
+}
+
+// Consume delivers Direct messages from the in channel,
+// usually subscribed to the amp/nsq DirectTopic.
+func (r *Registry) Consume(in <-chan *amp.Msg) {
+	go func() {
+		for m := range in {
+			if m.Type == amp.Direct {
+				r.Send(m)
+			}
+		}
+	}()
+}
+
+// Send delivers Direct message to all sessions matching every key value
+// pair from the message Meta. Returns number of sessions.
+func (r *Registry) Send(m *amp.Msg) int {
+	sessions := r.find(m.Meta)
+	if len(sessions) == 0 {
+		metric.Counter("direct.missed")
+		return 0
+	}
+	dm := m.Directed()
+	for _, s := range sessions {
+		s.Send(dm)
+	}
+	metric.Counter("direct.sent", len(sessions))
+	return len(sessions)
+}
+
+// Find returns sessions with meta key set to value.
+func (r *Registry) Find(key, value string) []amp.Sender {
+	return r.find(map[string]string{key: value})
+}
+
+func (r *Registry) find(meta map[string]string) []amp.Sender {
+	if len(meta) == 0 {
+		return nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	var found map[amp.Sender]struct{}
+	for k, v := range meta {
+		values, ok := r.index[k]
+		if !ok {
+			log.S("key", k).Info("direct message key not indexed")
+			return nil
+		}
+		sessions := values[v]
+		if found == nil {
+			found = sessions
+			continue
+		}
+		match := make(map[amp.Sender]struct{})
+		for s := range found {
+			if _, ok := sessions[s]; ok {
+				match[s] = struct{}{}
+			}
+		}
+		found = match
+	}
+	ret := make([]amp.Sender, 0, len(found))
+	for s := range found {
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+// backendHeaderer is session with server set headers
+type backendHeaderer interface {
+	GetBackendHeaders() map[string]string
+}
+
+// update indexes session by its current BackendHeaders
+func (r *Registry) update(s amp.Sender) {
+	if r == nil {
+		return
+	}
+	var headers map[string]string
+	if h, ok := s.(backendHeaderer); ok {
+		headers = h.GetBackendHeaders()
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.remove(s)
+	indexed := make(map[string]string)
+	for _, k := range r.keys {
+		v, ok := headers[k]
+		if !ok || v == "" {
+			continue
+		}
+		sessions, ok := r.index[k][v]
+		if !ok {
+			sessions = make(map[amp.Sender]struct{})
+			r.index[k][v] = sessions
+		}
+		sessions[s] = struct{}{}
+		indexed[k] = v
+	}
+	if len(indexed) > 0 {
+		r.sessions[s] = indexed
+	}
+}
+
+// unregister removes session from the index
+func (r *Registry) unregister(s amp.Sender) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.remove(s)
+}
+
+// remove must be called under lock
+func (r *Registry) remove(s amp.Sender) {
+	for k, v := range r.sessions[s] {
+		sessions := r.index[k][v]
+		delete(sessions, s)
+		if len(sessions) == 0 {
+			delete(r.index[k], v)
+		}
+	}
+	delete(r.sessions, s)
+}
+
+// Len returns number of indexed sessions.
+func (r *Registry) Len() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.sessions)
+}
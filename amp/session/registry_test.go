@@ -0,0 +1,72 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type registrySender struct {
+	meta    map[string]string
+	headers map[string]string // backend headers
+	msgs    []*amp.Msg
+}
+
+func (s *registrySender) Meta() map[string]string              { return s.meta }
+func (s *registrySender) Send(m *amp.Msg)                      { s.msgs = append(s.msgs, m) }
+func (s *registrySender) SendMsgs(ms []*amp.Msg)               { s.msgs = append(s.msgs, ms...) }
+func (s *registrySender) Headers() map[string]string           { return nil }
+func (s *registrySender) GetBackendHeaders() map[string]string { return s.headers }
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry("user", "group")
+	s1 := &registrySender{headers: map[string]string{"user": "1", "group": "a"}}
+	s2 := &registrySender{headers: map[string]string{"user": "2", "group": "a"}}
+	s3 := &registrySender{headers: map[string]string{"other": "1"}}
+	s4 := &registrySender{meta: map[string]string{"user": "1", "group": "a"}}
+	r.update(s1)
+	r.update(s2)
+	r.update(s3)
+	r.update(s4)
+	assert.Equal(t, 2, r.Len())
+
+	assert.Equal(t, []amp.Sender{s1}, r.Find("user", "1"))
+	assert.ElementsMatch(t, []amp.Sender{s1, s2}, r.Find("group", "a"))
+	assert.Empty(t, r.Find("other", "1")) // not indexed
+	// client set meta is not indexed
+	assert.Len(t, r.Find("user", "1"), 1)
+
+	m := amp.NewDirect("group", "a", "notify", map[string]int{"x": 1})
+	assert.Equal(t, 2, r.Send(m))
+	require.Len(t, s1.msgs, 1)
+	assert.Equal(t, amp.Publish, s1.msgs[0].Type)
+	assert.Equal(t, "notify", s1.msgs[0].URI)
+	assert.Equal(t, amp.Event, s1.msgs[0].UpdateType)
+	assert.Nil(t, s1.msgs[0].Meta)
+	require.Len(t, s2.msgs, 1)
+
+	// all meta pairs must match
+	m.Meta["user"] = "2"
+	assert.Equal(t, 1, r.Send(m))
+	assert.Len(t, s2.msgs, 2)
+
+	// headers change
+	s1.headers = map[string]string{"user": "3"}
+	r.update(s1)
+	assert.Empty(t, r.Find("user", "1"))
+	assert.Equal(t, []amp.Sender{s1}, r.Find("user", "3"))
+	assert.Equal(t, []amp.Sender{s2}, r.Find("group", "a"))
+
+	r.unregister(s1)
+	r.unregister(s2)
+	assert.Equal(t, 0, r.Len())
+	assert.Empty(t, r.index["user"])
+	assert.Empty(t, r.index["group"])
+
+	// nil registry is disabled
+	var nr *Registry
+	nr.update(s1)
+	nr.unregister(s1)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/minus5/svckit/amp"
@@ -28,10 +29,18 @@ type session struct {
 		aliveMessages int
 		maxQueueLen   int
 	}
-	topicWhitelist       []string
+	authorizer           Authorizer        // nil allows all messages, Factory never sets nil
+	authCache            map[authKey]error // authorizer decisions
 	compatibilityVersion uint8
+	caps                 amp.Capabilities // capabilities used for writing messages to the client
 	overflow             chan struct{}
 	overflowRead         chan struct{}
+	overflowPolicy       OverflowPolicy      // slow consumer strategies
+	overflowDefault      uint8               // session default overflow strategy
+	subscriptions        map[string]int64    // last subscriptions sent to the broker
+	stale                map[string]struct{} // topics dropped on overflow, waiting for resync
+	staleLock            sync.Mutex
+	registry             *Registry // index for directed messages, nil if not used
 }
 
 // serve starts new session
@@ -41,12 +50,14 @@ func serve(
 	conn connection,
 	req requester,
 	brk broker,
-	topicWhitelist []string,
+	authorizer Authorizer,
+	overflowPolicy OverflowPolicy,
+	registry *Registry,
 	compatibilityVersion uint8,
 ) {
 	overflow := make(chan struct{}, 1)
 	s := &session{
-		topicWhitelist:       topicWhitelist,
+		authorizer:           authorizer,
 		conn:                 conn,
 		requester:            req,
 		broker:               brk,
@@ -54,6 +65,20 @@ func serve(
 		compatibilityVersion: compatibilityVersion,
 		overflow:             overflow,
 		overflowRead:         overflow, // read once and set to nil
+		overflowPolicy:       overflowPolicy,
+		overflowDefault:      overflowPolicy.sessionDefault(conn.Meta()),
+		registry:             registry,
+		caps: amp.Capabilities{
+			Versions: []int{int(compatibilityVersion)},
+			Codecs:   []string{amp.CodecName(amp.CodecJSON)},
+			Features: amp.AllFeatures,
+		},
+	}
+	if conn.DeflateSupported() {
+		s.caps.Compression = []string{amp.CompressionDeflateName}
+	}
+	if compatibilityVersion == amp.CompatibilityVersionDefault {
+		s.setCodec(amp.ParseCodecName(conn.Meta()[amp.CodecQueryKey]))
 	}
 	s.stats.start = time.Now()
 	s.loop(cancelSig)
@@ -61,6 +86,8 @@ func serve(
 
 func (s *session) loop(cancelSig context.Context) {
 	s.broker.Created(s)
+	s.registry.update(s)
+	defer s.registry.unregister(s)
 	inMessages := s.readLoop()  // messages from the client
 	exitSig := cancelSig.Done() // aplication exit signal
 
@@ -74,11 +101,8 @@ func (s *session) loop(cancelSig context.Context) {
 
 	defer s.logStats()
 
-	if preSub := s.Meta()["preSub"]; preSub != "" {
-		chanName := fmt.Sprintf("sportsbook/%s", preSub)
-		s.broker.Subscribe(s, map[string]int64{
-			chanName: 0,
-		})
+	if subscriptions := s.preSubscribe(map[string]int64{}); len(subscriptions) > 0 {
+		s.subscribe(subscriptions)
 	}
 
 	for {
@@ -91,6 +115,7 @@ func (s *session) loop(cancelSig context.Context) {
 			}
 			s.stats.outMessages += len(msgs)
 			alive.Reset(aliveInterval)
+			s.resync()
 		case msg, ok := <-inMessages:
 			if !ok {
 				s.unsubscribe()
@@ -99,10 +124,10 @@ func (s *session) loop(cancelSig context.Context) {
 			s.receive(msg)
 			s.stats.inMessages++
 		case <-exitSig:
-			s.connClose()
+			s.connClose(closeShutdown)
 			exitSig = nil // fire once
 		case <-s.overflowRead:
-			s.connClose()
+			s.connClose(closeOverflow)
 			s.overflowRead = nil // fire once
 		}
 	}
@@ -153,24 +178,65 @@ func (s *session) receive(m *amp.Msg) {
 	case amp.Ping:
 		s.Send(m.Pong())
 	case amp.Request:
-		if !s.isMessageTopicWhitelisted(m) {
+		if err := s.authorize(m, m.Topic()); err != nil {
+			s.reject(m, m.URI, err)
 			return
 		}
 		m.Meta = s.conn.Meta()
 		m.BackendHeaders = s.conn.GetBackendHeaders()
 		s.requester.Send(s, m)
 	case amp.Subscribe:
-		if preSub := s.Meta()["preSub"]; preSub != "" {
-			chanName := fmt.Sprintf("sportsbook/%s", preSub)
-			m.Subscriptions[chanName] = 0
+		for topic := range m.Subscriptions {
+			if err := s.authorize(m, topic); err != nil {
+				delete(m.Subscriptions, topic)
+				s.reject(m, topic, err)
+			}
+		}
+		s.subscribe(s.preSubscribe(m.Subscriptions))
+	case amp.SubscribeAdd:
+		for topic := range m.Subscriptions {
+			if err := s.authorize(m, topic); err != nil {
+				delete(m.Subscriptions, topic)
+				s.reject(m, topic, err)
+			}
 		}
-		s.broker.Subscribe(s, m.Subscriptions)
+		s.subscribeAdd(m.Subscriptions)
+	case amp.SubscribeRemove:
+		s.subscribeRemove(m.Subscriptions)
+	case amp.Hello:
+		s.hello(m)
 	case amp.Meta:
+		if err := s.authorize(m, ""); err != nil {
+			s.reject(m, "", err)
+			return
+		}
+		s.authCache = nil // decisions may depend on meta
 		s.conn.SetMeta(m.Meta)
+		s.registry.update(s)
 		s.Send(m.MetaResponse(s.conn.Meta()))
 	}
 }
 
+// preSubscribe adds topic from the preSub meta value to the subscriptions,
+// if authorizer allows it
+func (s *session) preSubscribe(subscriptions map[string]int64) map[string]int64 {
+	preSub := s.Meta()["preSub"]
+	if preSub == "" {
+		return subscriptions
+	}
+	chanName := fmt.Sprintf("sportsbook/%s", preSub)
+	m := &amp.Msg{Type: amp.Subscribe, Subscriptions: map[string]int64{chanName: 0}}
+	if err := s.authorize(m, chanName); err != nil {
+		s.reject(m, chanName, err)
+		return subscriptions
+	}
+	if subscriptions == nil {
+		subscriptions = make(map[string]int64)
+	}
+	subscriptions[chanName] = 0
+	return subscriptions
+}
+
 // Send message to the clinet
 // Implements amp.Subscriber interface.
 func (s *session) Send(m *amp.Msg) {
@@ -178,14 +244,65 @@ func (s *session) Send(m *amp.Msg) {
 }
 
 func (s *session) SendMsgs(msgs []*amp.Msg) {
+	if msgs = s.dropStale(msgs); len(msgs) == 0 {
+		return
+	}
 	select {
 	case s.outMessages <- msgs:
 	default:
-		select {
-		case s.overflow <- struct{}{}:
-		default:
+		s.onOverflow(msgs)
+	}
+}
+
+// subscribe sends subscriptions to the broker and remembers them for resync
+func (s *session) subscribe(subscriptions map[string]int64) {
+	s.subscriptions = subscriptions
+	s.broker.Subscribe(s, subscriptions)
+}
+
+// subscribeAdd adds topics to the subscriptions
+func (s *session) subscribeAdd(add map[string]int64) {
+	if len(add) == 0 {
+		return
+	}
+	subscriptions := make(map[string]int64, len(s.subscriptions)+len(add))
+	for name, ts := range s.subscriptions {
+		subscriptions[name] = ts
+	}
+	for name, ts := range add {
+		if _, ok := subscriptions[name]; !ok {
+			subscriptions[name] = ts
+		}
+	}
+	if b, ok := s.broker.(incrementalBroker); ok {
+		s.subscriptions = subscriptions
+		b.SubscribeAdd(s, add)
+		return
+	}
+	s.subscribe(subscriptions)
+}
+
+// subscribeRemove removes topics from the subscriptions, ts values are ignored
+func (s *session) subscribeRemove(remove map[string]int64) {
+	if len(remove) == 0 {
+		return
+	}
+	subscriptions := make(map[string]int64, len(s.subscriptions))
+	for name, ts := range s.subscriptions {
+		if _, ok := remove[name]; !ok {
+			subscriptions[name] = ts
+		}
+	}
+	if b, ok := s.broker.(incrementalBroker); ok {
+		s.subscriptions = subscriptions
+		names := make([]string, 0, len(remove))
+		for name := range remove {
+			names = append(names, name)
 		}
+		b.SubscribeRemove(s, names)
+		return
 	}
+	s.subscribe(subscriptions)
 }
 
 // should be called during s.Lock
@@ -199,20 +316,80 @@ func (s *session) logOutQueueOverflow() {
 		Info("out queue overflow")
 }
 
+// setCodec sets codec used for encoding messages sent to the client.
+// Client messages are recognized regardless of the codec.
+// Binary codec is ignored if connection can't send binary frames.
+func (s *session) setCodec(codec uint8) {
+	if amp.IsBinaryCodec(codec) && !s.conn.BinarySupported() {
+		codec = amp.CodecJSON
+	}
+	s.caps.Codecs = []string{amp.CodecName(codec)}
+	s.conn.SetBinary(amp.IsBinaryCodec(codec))
+}
+
+// serverCapabilities returns capabilities supported by the session, current first.
+// Version is chosen by the endpoint (Serve or ServeV1), client learns it
+// from the Hello response. Binary codecs are offered only if connection
+// supports binary frames.
+func (s *session) serverCapabilities() amp.Capabilities {
+	c := amp.Capabilities{
+		Versions: s.caps.Versions,
+		Codecs:   []string{amp.CodecName(s.caps.Codec())},
+		Features: amp.AllFeatures,
+	}
+	if s.compatibilityVersion == amp.CompatibilityVersionDefault {
+		for _, codec := range []uint8{amp.CodecJSON, amp.CodecMsgpack} {
+			if codec != s.caps.Codec() && (!amp.IsBinaryCodec(codec) || s.conn.BinarySupported()) {
+				c.Codecs = append(c.Codecs, amp.CodecName(codec))
+			}
+		}
+	}
+	if s.conn.DeflateSupported() {
+		c.Compression = []string{amp.CompressionDeflateName}
+	}
+	return c
+}
+
+// hello negotiates capabilities with the client.
+// Response is written with the current capabilities, all next messages
+// with the chosen ones.
+func (s *session) hello(m *amp.Msg) {
+	client, err := m.Capabilities()
+	if err != nil {
+		s.log().Error(err)
+		client = amp.Capabilities{}
+	}
+	caps := s.serverCapabilities().Negotiate(client)
+	s.connWrite(amp.NewHello(caps))
+	s.caps = caps
+	s.conn.SetBinary(amp.IsBinaryCodec(caps.Codec()))
+}
+
 func (s *session) connWrite(m *amp.Msg) {
+	if m.Type == amp.Publish && (m.UpdateType == amp.BurstStart || m.UpdateType == amp.BurstEnd) &&
+		!s.caps.Has(amp.FeatureBursts) {
+		return
+	}
+	if m.Type == amp.Meta && !s.caps.Has(amp.FeatureMeta) {
+		return
+	}
+	if !s.caps.Has(amp.FeatureAppend) {
+		m = m.WithoutCacheDepth()
+	}
+	version, codec := s.caps.Version(), s.caps.Codec()
 	var payload []byte
 	deflated := false
-	if s.conn.DeflateSupported() {
-		payload, deflated = m.MarshalDeflateCompatiblity(s.compatibilityVersion)
+	if s.caps.Deflate() && s.conn.DeflateSupported() {
+		payload, deflated = m.MarshalDeflateCodec(version, codec)
 	} else {
-		payload = m.MarshalCompatiblity(s.compatibilityVersion)
+		payload = m.MarshalCodec(version, codec)
 	}
 	if payload == nil {
 		return
 	}
 	err := s.conn.Write(payload, deflated)
 	if err != nil {
-		s.connClose()
+		s.connClose(closeWrite)
 	}
 }
 
@@ -220,8 +397,13 @@ func (s *session) log() *log.Agregator {
 	return log.I("no", int(s.conn.No()))
 }
 
-func (s *session) connClose() {
+// connClose closes connection, reason is passed to connections which report it
+func (s *session) connClose(reason string) {
 	metric.Timing("connClose", func() {
+		if rc, ok := s.conn.(reasonCloser); ok {
+			_ = rc.CloseWithReason(reason)
+			return
+		}
 		s.conn.Close()
 	})
 }
@@ -230,6 +412,11 @@ func (s *session) Meta() map[string]string {
 	return s.conn.Meta()
 }
 
+// GetBackendHeaders returns server set headers of the connection.
+func (s *session) GetBackendHeaders() map[string]string {
+	return s.conn.GetBackendHeaders()
+}
+
 func (s *session) GetRemoteIp() string {
 	return s.conn.GetRemoteIp()
 }
@@ -241,13 +428,3 @@ func (s *session) GetCookie() string {
 func (s *session) Headers() map[string]string {
 	return s.conn.Headers()
 }
-
-func (s *session) isMessageTopicWhitelisted(msg *amp.Msg) bool {
-	for _, topic := range s.topicWhitelist {
-		if topic == msg.Topic() {
-			return true
-		}
-	}
-
-	return false
-}
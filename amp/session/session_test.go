@@ -13,7 +13,8 @@ import (
 )
 
 type mockConn struct {
-	t *testing.T
+	t        *testing.T
+	textOnly bool // binary frames are not supported
 
 	in  chan []byte
 	out chan []byte
@@ -42,10 +43,15 @@ func (c *mockConn) Write(payload []byte, deflated bool) error {
 	return nil
 }
 func (c *mockConn) DeflateSupported() bool { return false }
+func (c *mockConn) SetBinary(bool)         {}
+func (c *mockConn) BinarySupported() bool  { return !c.textOnly }
 
 func (c *mockConn) SetBackendHeaders(_ map[string]string) {}
 
 func (c *mockConn) GetBackendHeaders() map[string]string {
+	if c.t == nil {
+		return c.ReturnBackendHeaders
+	}
 	c.gotBackendHeadersCalls++
 
 	require.True(c.t, c.WantBackendHeaders >= c.gotBackendHeadersCalls)
@@ -57,6 +63,9 @@ func (c *mockConn) Headers() map[string]string { return nil }
 func (c *mockConn) No() uint64                 { return 0 }
 
 func (c *mockConn) Meta() map[string]string {
+	if c.t == nil {
+		return c.ReturnMeta
+	}
 	c.gotMetaCalls++
 
 	require.True(c.t, c.WantMetaCalls >= c.gotMetaCalls)
@@ -294,9 +303,9 @@ func Test_session_receive(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &session{
-				conn:           &tt.fields.conn,
-				requester:      &tt.fields.requester,
-				topicWhitelist: tt.fields.topicWhitelist,
+				conn:       &tt.fields.conn,
+				requester:  &tt.fields.requester,
+				authorizer: TopicWhitelist(tt.fields.topicWhitelist),
 			}
 
 			s.receive(tt.in)
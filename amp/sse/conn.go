@@ -0,0 +1,189 @@
+package sse
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/pkg/errors"
+)
+
+var connectionsCounter uint64
+
+func no() uint64 {
+	return atomic.AddUint64(&connectionsCounter, 1)
+}
+
+// Conn is amp session connection over Server-Sent Events.
+// Messages to the client are written as events to the http response stream,
+// messages from the client are received by http POST requests (see Handler).
+type Conn struct {
+	id      string
+	no      uint64
+	w       io.Writer
+	flusher http.Flusher
+	in      chan []byte
+	closed  chan struct{}
+	once    sync.Once
+
+	meta         map[string]string
+	headers      map[string]string
+	cookie       string
+	forwardedFor string
+
+	// backendHeaders can only be set and read on the backend.
+	backendHeaders map[string]string
+}
+
+func newConn(id string, w io.Writer, flusher http.Flusher, r *http.Request) *Conn {
+	c := &Conn{
+		id:      id,
+		no:      no(),
+		w:       w,
+		flusher: flusher,
+		in:      make(chan []byte),
+		closed:  make(chan struct{}),
+		meta:    make(map[string]string),
+		headers: make(map[string]string),
+	}
+	for k, v := range r.URL.Query() {
+		c.meta[k] = strings.Join(v, ",")
+	}
+	// event stream is text only, binary codecs are not supported
+	delete(c.meta, amp.CodecQueryKey)
+	for k, v := range r.Header {
+		c.headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	c.cookie = r.Header.Get("Cookie")
+	c.forwardedFor = strings.Join(r.Header.Values("X-Forwarded-For"), " ")
+	if c.forwardedFor == "" {
+		c.forwardedFor = r.RemoteAddr
+	}
+	return c
+}
+
+// Write writes payload as event to the stream.
+// Each payload line is sent in separate data field,
+// client joins them back with new line (EventSource does that).
+func (c *Conn) Write(payload []byte, deflated bool) error {
+	select {
+	case <-c.closed:
+		return errors.WithStack(io.ErrClosedPipe)
+	default:
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, len(payload)+16))
+	for _, line := range bytes.Split(bytes.TrimSuffix(payload, []byte{'\n'}), []byte{'\n'}) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	if _, err := c.w.Write(buf.Bytes()); err != nil {
+		_ = c.Close()
+		return errors.WithStack(err)
+	}
+	c.flusher.Flush()
+	return nil
+}
+
+// writeEvent writes named event, used for control events.
+func (c *Conn) writeEvent(event, data string) error {
+	if _, err := io.WriteString(c.w, "event: "+event+"\ndata: "+data+"\n\n"); err != nil {
+		return errors.WithStack(err)
+	}
+	c.flusher.Flush()
+	return nil
+}
+
+// Read returns next message received by POST request.
+func (c *Conn) Read() ([]byte, error) {
+	select {
+	case buf := <-c.in:
+		return buf, nil
+	case <-c.closed:
+		return nil, errors.WithStack(io.EOF)
+	}
+}
+
+// receive passes client message to the Read.
+// Blocks until message is read, so client messages are processed in order.
+func (c *Conn) receive(buf []byte, done <-chan struct{}) error {
+	select {
+	case c.in <- buf:
+		return nil
+	case <-c.closed:
+		return errors.WithStack(io.EOF)
+	case <-done:
+		return errors.WithStack(io.ErrUnexpectedEOF)
+	}
+}
+
+// SetBinary is noop, event stream is text only.
+func (c *Conn) SetBinary(bool) {}
+
+// BinarySupported returns false, event stream is text only.
+func (c *Conn) BinarySupported() bool { return false }
+
+// DeflateSupported is always false, compression is left to the http layer.
+func (c *Conn) DeflateSupported() bool {
+	return false
+}
+
+// Close closes the stream.
+func (c *Conn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+// No returns connection identificator.
+func (c *Conn) No() uint64 {
+	return c.no
+}
+
+// ID returns session identificator sent to the client in the first event.
+func (c *Conn) ID() string {
+	return c.id
+}
+
+// Headers usefull http headers
+func (c *Conn) Headers() map[string]string {
+	return c.headers
+}
+
+func (c *Conn) SetBackendHeaders(headers map[string]string) {
+	if c.backendHeaders == nil {
+		c.backendHeaders = make(map[string]string)
+	}
+	for k, v := range headers {
+		c.backendHeaders[k] = v
+	}
+}
+
+func (c *Conn) GetBackendHeaders() map[string]string {
+	return c.backendHeaders
+}
+
+// Meta from the query string of the request which started the stream
+func (c *Conn) Meta() map[string]string {
+	return c.meta
+}
+
+func (c *Conn) SetMeta(m map[string]string) {
+	for k, v := range m {
+		c.meta[k] = v
+	}
+}
+
+func (c *Conn) GetRemoteIp() string {
+	return c.forwardedFor
+}
+
+func (c *Conn) GetCookie() string {
+	return c.cookie
+}
@@ -0,0 +1,153 @@
+// Package sse implements amp session connection over Server-Sent Events.
+//
+// Client opens event stream with GET request (query string is session Meta),
+// first event is named "session" with session id in data.
+// All other events are amp messages.
+// Client sends messages with POST requests with session id in the
+// query string parameter "session" and message in the body.
+// Client should wait for POST response before sending next message
+// to preserve ordering.
+package sse
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/minus5/svckit/log"
+)
+
+const (
+	// SessionQueryKey is the name of the POST query string parameter with session id.
+	SessionQueryKey = "session"
+	// SessionEvent is the name of the first event, data is session id.
+	SessionEvent = "session"
+
+	maxMessageSize = 1000000
+)
+
+// Handler serves amp sessions over http.
+type Handler struct {
+	onNewConn func(*Conn)
+	conns     map[string]*Conn
+	sync.Mutex
+}
+
+// NewHandler creates http handler which calls h for each new event stream.
+// h should block until connection is closed, usually it is sessions.Serve.
+func NewHandler(h func(*Conn)) *Handler {
+	return &Handler{
+		onNewConn: h,
+		conns:     make(map[string]*Conn),
+	}
+}
+
+// ServeHTTP opens event stream on GET and receives client messages on POST.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.stream(w, r)
+	case http.MethodPost:
+		h.post(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	id, err := newID()
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	hdr := w.Header()
+	hdr.Set("Content-Type", "text/event-stream")
+	hdr.Set("Cache-Control", "no-cache")
+	hdr.Set("X-Accel-Buffering", "no") // disable nginx buffering
+	w.WriteHeader(http.StatusOK)
+
+	c := newConn(id, w, flusher, r)
+	if err := c.writeEvent(SessionEvent, id); err != nil {
+		return
+	}
+	h.add(c)
+	defer h.remove(c)
+
+	// close connection when client goes away
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			_ = c.Close()
+		case <-done:
+		}
+	}()
+
+	h.onNewConn(c) // blocks until session is finished
+	_ = c.Close()
+}
+
+func (h *Handler) post(w http.ResponseWriter, r *http.Request) {
+	c := h.find(r.URL.Query().Get(SessionQueryKey))
+	if c == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer r.Body.Close()
+	buf, err := io.ReadAll(io.LimitReader(r.Body, maxMessageSize+1))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(buf) > maxMessageSize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err := c.receive(buf, r.Context().Done()); err != nil {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) add(c *Conn) {
+	h.Lock()
+	defer h.Unlock()
+	h.conns[c.id] = c
+}
+
+func (h *Handler) remove(c *Conn) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.conns, c.id)
+}
+
+func (h *Handler) find(id string) *Conn {
+	h.Lock()
+	defer h.Unlock()
+	return h.conns[id]
+}
+
+// Len returns number of open streams.
+func (h *Handler) Len() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.conns)
+}
+
+func newID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,96 @@
+package sse
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/minus5/svckit/amp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readEvent reads one event from the stream, returns event name and data
+func readEvent(t *testing.T, r *bufio.Reader) (string, string) {
+	var event string
+	var data []string
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return event, strings.Join(data, "\n")
+		case strings.HasPrefix(line, "event: "):
+			event = line[7:]
+		case strings.HasPrefix(line, "data: "):
+			data = append(data, line[6:])
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	conns := make(chan *Conn, 1)
+	// echo server
+	h := NewHandler(func(c *Conn) {
+		conns <- c
+		for {
+			buf, err := c.Read()
+			if err != nil {
+				return
+			}
+			m := amp.Parse(buf)
+			if err := c.Write(m.Pong().Marshal(), false); err != nil {
+				return
+			}
+		}
+	})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + "?a=b&codec=msgpack")
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, "text/event-stream", rsp.Header.Get("Content-Type"))
+	r := bufio.NewReader(rsp.Body)
+
+	event, id := readEvent(t, r)
+	assert.Equal(t, SessionEvent, event)
+	assert.Len(t, id, 32)
+	assert.Equal(t, 1, h.Len())
+	assert.Equal(t, map[string]string{"a": "b"}, (<-conns).Meta())
+
+	for i := 1; i <= 3; i++ {
+		ping := &amp.Msg{Type: amp.Ping, CorrelationID: uint64(i)}
+		prsp, err := http.Post(srv.URL+"?session="+id, "application/json", strings.NewReader(string(ping.Marshal())))
+		require.NoError(t, err)
+		prsp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, prsp.StatusCode)
+
+		_, data := readEvent(t, r)
+		m := amp.Parse([]byte(data))
+		require.NotNil(t, m)
+		assert.Equal(t, amp.Pong, m.Type)
+		assert.Equal(t, uint64(i), m.CorrelationID)
+	}
+
+	prsp, err := http.Post(srv.URL+"?session=unknown", "application/json", strings.NewReader("{}"))
+	require.NoError(t, err)
+	prsp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, prsp.StatusCode)
+}
+
+func TestWriteMultiline(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c := newConn("id", rec, rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	m := amp.NewPublish("a", "b", 1, amp.Full, map[string]int{"x": 1})
+	require.NoError(t, c.Write(m.Marshal(), false))
+	assert.Equal(t, "data: {\"u\":\"a/b\",\"s\":1,\"p\":1}\ndata: {\"x\":1}\n\n", rec.Body.String())
+
+	c.Close()
+	assert.Error(t, c.Write(m.Marshal(), false))
+	_, err := c.Read()
+	assert.Error(t, err)
+}
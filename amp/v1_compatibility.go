@@ -32,12 +32,12 @@ func ParseV1(buf []byte) *Msg {
 	if v1.Type == Ping {
 		return &Msg{Type: Ping}
 	}
-	if v1.Type != Subscribe {
+	if v1.Type != Subscribe && v1.Type != SubscribeAdd && v1.Type != SubscribeRemove {
 		log.S("header", string(buf)).ErrorS("unknown message type")
 		return nil
 	}
 	v2 := &Msg{
-		Type:          Subscribe,
+		Type:          v1.Type,
 		Subscriptions: make(map[string]int64),
 	}
 	for _, s := range v1.Subscriptions {
@@ -73,13 +73,13 @@ func ParseV1Subscriptions(buf []byte) *Msg {
 
 // Marshal packs message for sending on the wire
 func (m *Msg) MarshalV1() []byte {
-	buf, _ := m.marshal(CompressionNone, CompatibilityVersion1, jsonSerializer)
+	buf, _ := m.marshal(CompressionNone, CompatibilityVersion1, CodecJSON, false)
 	return buf
 }
 
 // MarshalDeflate packs and compress message
 func (m *Msg) MarshalV1Deflate() ([]byte, bool) {
-	return m.marshal(CompressionDeflate, CompatibilityVersion1, jsonSerializer)
+	return m.marshal(CompressionDeflate, CompatibilityVersion1, CodecJSON, false)
 }
 
 func (m *Msg) marshalV1header() []byte {
@@ -1,11 +1,10 @@
 package ws
 
 import (
-	"bytes"
-	"compress/flate"
 	"fmt"
 	"io"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -13,11 +12,22 @@ import (
 	"github.com/pkg/errors"
 )
 
+// maxFrame is max client frame payload size, after uncompressing too
+const maxFrame = 1000000
+
 // Conn handles sending and reciving on websocket connection
 type Conn struct {
 	tcpConn net.Conn
 	cap     connCap
 	no      uint64
+	binary  bool // send payloads in binary frames
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	writeLock    sync.Mutex // session writes, pongs and pings are from different goroutines
+
+	closeReason string // first reason wins
+	reasonLock  sync.Mutex
 
 	// backendHeaders can only be set and read on the backend.
 	backendHeaders map[string]string
@@ -50,13 +60,37 @@ func no() uint64 {
 
 func newConn(tc net.Conn, cap connCap) *Conn {
 	c := &Conn{
-		tcpConn: tc,
-		cap:     cap,
-		no:      no(),
+		tcpConn:      tc,
+		cap:          cap,
+		no:           no(),
+		readTimeout:  DefaultKeepalivePolicy.ReadTimeout,
+		writeTimeout: DefaultKeepalivePolicy.WriteTimeout,
 	}
 	return c
 }
 
+// SetTimeouts sets read and write deadlines durations, zero disables deadline.
+func (c *Conn) SetTimeouts(read, write time.Duration) {
+	c.readTimeout = read
+	c.writeTimeout = write
+}
+
+func (c *Conn) setReadDeadline() {
+	var t time.Time
+	if c.readTimeout > 0 {
+		t = time.Now().Add(c.readTimeout)
+	}
+	_ = c.tcpConn.SetReadDeadline(t)
+}
+
+func (c *Conn) setWriteDeadline() {
+	var t time.Time
+	if c.writeTimeout > 0 {
+		t = time.Now().Add(c.writeTimeout)
+	}
+	_ = c.tcpConn.SetWriteDeadline(t)
+}
+
 // Headers usefull http headers
 func (c *Conn) Headers() map[string]string {
 	return c.cap.headers
@@ -76,71 +110,115 @@ func (c *Conn) GetBackendHeaders() map[string]string {
 	return c.backendHeaders
 }
 
+// BinarySupported implements session connection interface, websocket supports binary frames.
+func (c *Conn) BinarySupported() bool { return true }
+
+// SetBinary switches between text and binary websocket frames for Write.
+func (c *Conn) SetBinary(binary bool) {
+	c.binary = binary
+}
+
 // Write writes payload to the websocket connection.
 func (c *Conn) Write(payload []byte, deflated bool) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.setWriteDeadline()
 	var header ws.Header
 	header.OpCode = ws.OpText
+	if c.binary {
+		header.OpCode = ws.OpBinary
+	}
 	header.Length = int64(len(payload))
 	header.Fin = true
 	if deflated {
 		header.Rsv = ws.Rsv(true, false, false)
 	}
 	if err := ws.WriteHeader(c.tcpConn, header); err != nil {
-		_ = c.Close()
+		_ = c.CloseWithReason(CloseWrite)
 		return errors.WithStack(err)
 	}
 	_, err := c.tcpConn.Write(payload)
-	if err == nil {
-		setDeadline(c.tcpConn)
-	} else {
-		_ = c.Close()
+	if err != nil {
+		_ = c.CloseWithReason(CloseWrite)
 	}
 	return errors.WithStack(err)
 }
 
 // Read reads message from the connection.
+// Each frame from the client (including ping and pong) extends read deadline.
 func (c *Conn) Read() ([]byte, error) {
+	c.setReadDeadline()
 	header, err := ws.ReadHeader(c.tcpConn)
 	if err != nil {
+		c.readError(err)
 		return nil, errors.WithStack(err)
 	}
-	if header.Length < 0 || header.Length > 1000000 {
+	if header.Length < 0 || header.Length > maxFrame {
+		c.setReason(CloseError)
 		return nil, fmt.Errorf("malformed: %d -- %t -- %s -- %s", header.Length, c.cap.deflateSupported, c.cap.forwardedFor, c.cap.userAgent)
 	}
 	payload := make([]byte, header.Length)
 	_, err = io.ReadFull(c.tcpConn, payload)
 	if err != nil {
+		c.readError(err)
 		c.Close()
 		return nil, errors.WithStack(err)
 	}
 
 	if header.OpCode == ws.OpClose {
-		c.Close()
+		c.CloseWithReason(CloseClient)
 		return nil, errors.WithStack(io.EOF)
 	}
 	if header.OpCode == ws.OpContinuation {
+		c.setReason(CloseError)
 		return nil, errors.WithStack(io.ErrUnexpectedEOF)
 	}
+	if header.Masked {
+		ws.Cipher(payload, header.Mask, 0)
+	}
 	if header.OpCode == ws.OpPing {
-		header.OpCode = ws.OpPong
-		header.Masked = false
-		_ = ws.WriteHeader(c.tcpConn, header)
+		if err := c.writePong(payload); err != nil {
+			_ = c.CloseWithReason(CloseWrite)
+		}
 		return nil, nil
 	}
 	if header.OpCode == ws.OpPong {
 		return nil, nil
 	}
-	if header.Masked {
-		ws.Cipher(payload, header.Mask, 0)
-	}
 	if header.Rsv1() {
-		payload = undeflate(payload)
+		if !c.cap.deflateSupported {
+			c.CloseWithReason(CloseError)
+			return nil, errors.New("malformed: compressed frame without negotiated compression")
+		}
+		if payload, err = undeflate(payload); err != nil {
+			c.CloseWithReason(CloseError)
+			return nil, errors.WithStack(err)
+		}
 	}
-
-	setDeadline(c.tcpConn)
 	return payload, nil
 }
 
+// writePong answers client ping with the same payload
+func (c *Conn) writePong(payload []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.setWriteDeadline()
+	return ws.WriteFrame(c.tcpConn, ws.NewPongFrame(payload))
+}
+
+// readError sets close reason from the read error
+func (c *Conn) readError(err error) {
+	var ne net.Error
+	switch {
+	case errors.As(err, &ne) && ne.Timeout():
+		c.setReason(CloseIdle)
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		c.setReason(CloseClient)
+	default:
+		c.setReason(CloseError)
+	}
+}
+
 // No returns connection identificator.
 func (c *Conn) No() uint64 {
 	return c.no
@@ -157,6 +235,28 @@ func (c *Conn) Close() error {
 	return c.tcpConn.Close()
 }
 
+// CloseWithReason closes connection and remembers why,
+// reason is reported in the ws.close metric.
+func (c *Conn) CloseWithReason(reason string) error {
+	c.setReason(reason)
+	return c.Close()
+}
+
+// CloseReason returns why connection is closed, or empty string.
+func (c *Conn) CloseReason() string {
+	c.reasonLock.Lock()
+	defer c.reasonLock.Unlock()
+	return c.closeReason
+}
+
+func (c *Conn) setReason(reason string) {
+	c.reasonLock.Lock()
+	defer c.reasonLock.Unlock()
+	if c.closeReason == "" {
+		c.closeReason = reason
+	}
+}
+
 // Cookies from the requests which started connection
 func (c *Conn) Meta() map[string]string {
 	return c.cap.meta
@@ -175,14 +275,3 @@ func (c *Conn) GetRemoteIp() string {
 func (c *Conn) GetCookie() string {
 	return c.cap.cookie
 }
-
-// undeflate uncomresses websocket payload
-func undeflate(data []byte) []byte {
-	buf := bytes.NewBuffer(data)
-	buf.Write([]byte{0x00, 0x00, 0xff, 0xff})
-	r := flate.NewReader(buf)
-	defer r.Close()
-	out := bytes.NewBuffer(nil)
-	_, _ = io.Copy(out, r)
-	return out.Bytes()
-}
@@ -0,0 +1,91 @@
+package ws
+
+import (
+	"bytes"
+	"compress/flate"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/gobwas/ws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func deflate(t *testing.T, src []byte) []byte {
+	dest := bytes.NewBuffer(nil)
+	c, err := flate.NewWriter(dest, flate.DefaultCompression)
+	require.NoError(t, err)
+	_, _ = c.Write(src)
+	require.NoError(t, c.Flush())
+	buf := dest.Bytes()
+	// remove 0x00 0x00 0xff 0xff tail (RFC 7692 7.2.1)
+	return buf[:len(buf)-4]
+}
+
+func testConns(deflateSupported bool) (*Conn, *Conn) {
+	a, b := net.Pipe()
+	cc := connCap{deflateSupported: deflateSupported}
+	return newConn(a, cc), newConn(b, cc)
+}
+
+func TestDeflateRoundTrip(t *testing.T) {
+	server, client := testConns(true)
+	defer server.Close()
+	defer client.Close()
+
+	payload := []byte(strings.Repeat(`{"foo":"bar"}`, 1024))
+	for i := 0; i < 3; i++ {
+		go func() {
+			assert.NoError(t, server.Write(deflate(t, payload), true))
+		}()
+		got, err := client.Read()
+		require.NoError(t, err)
+		assert.Equal(t, payload, got)
+	}
+}
+
+func TestReadMaskedDeflated(t *testing.T) {
+	server, client := testConns(true)
+	defer server.Close()
+	defer client.Close()
+
+	payload := []byte(`{"t":4}`)
+	compressed := deflate(t, payload)
+	go func() {
+		f := ws.NewTextFrame(compressed)
+		f.Header.Rsv = ws.Rsv(true, false, false)
+		f = ws.MaskFrameInPlace(f)
+		assert.NoError(t, ws.WriteFrame(client.tcpConn, f))
+	}()
+	got, err := server.Read()
+	require.NoError(t, err)
+	assert.Equal(t, payload, got)
+}
+
+func TestReadDeflatedWithoutNegotiation(t *testing.T) {
+	server, client := testConns(false)
+	defer server.Close()
+	defer client.Close()
+
+	go func() {
+		_ = client.Write(deflate(t, []byte("foo")), true)
+	}()
+	_, err := server.Read()
+	assert.Error(t, err)
+}
+
+func TestWriteBinary(t *testing.T) {
+	server, client := testConns(false)
+	defer server.Close()
+	defer client.Close()
+
+	server.SetBinary(true)
+	go func() {
+		assert.NoError(t, server.Write([]byte{0x80}, false))
+	}()
+	f, err := ws.ReadFrame(client.tcpConn)
+	require.NoError(t, err)
+	assert.Equal(t, ws.OpBinary, f.Header.OpCode)
+	assert.Equal(t, []byte{0x80}, f.Payload)
+}
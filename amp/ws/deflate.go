@@ -0,0 +1,218 @@
+package ws
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+	"github.com/pkg/errors"
+)
+
+// websocket compression extensions
+const (
+	extPermessageDeflate = "permessage-deflate"     // RFC 7692
+	extWebkitDeflate     = "x-webkit-deflate-frame" // old WebKit, before RFC 7692
+)
+
+// RFC 7692 extension parameters
+const (
+	paramServerNoContextTakeover = "server_no_context_takeover"
+	paramClientNoContextTakeover = "client_no_context_takeover"
+	paramServerMaxWindowBits     = "server_max_window_bits"
+	paramClientMaxWindowBits     = "client_max_window_bits"
+	// x-webkit-deflate-frame parameters
+	paramNoContextTakeover = "no_context_takeover"
+	paramMaxWindowBits     = "max_window_bits"
+)
+
+// compress/flate always uses 32K window, we can't compress with smaller one.
+const maxWindowBits = 15
+
+// CompressionPolicy decides whether websocket compression is negotiated with the client.
+type CompressionPolicy struct {
+	// Disabled turns off compression for all clients.
+	Disabled bool
+	// DenyUserAgents clients with user agent containing any of the strings
+	// are not offered compression.
+	DenyUserAgents []string
+	// DenyMeta clients with any of the keys in query string are not offered compression.
+	DenyMeta []string
+	// WebkitFallback accepts x-webkit-deflate-frame extension
+	// when client doesn't offer permessage-deflate.
+	WebkitFallback bool
+}
+
+// DefaultCompressionPolicy is used by listener when no other is set.
+var DefaultCompressionPolicy = CompressionPolicy{
+	// na iOS 15, 16, ... ne radi vise web kompresija
+	DenyUserAgents: []string{"OS 1", "Mac OS X 10_1"},
+	// skip deflating for kladomat, implementation in Chromium is buggy, constantly reconnects
+	DenyMeta:       []string{"klad"},
+	WebkitFallback: true,
+}
+
+// allowed returns false if compression is denied for the client.
+func (p CompressionPolicy) allowed(cc connCap) bool {
+	if p.Disabled {
+		return false
+	}
+	for _, s := range p.DenyUserAgents {
+		if s != "" && strings.Contains(cc.userAgent, s) {
+			return false
+		}
+	}
+	for _, k := range p.DenyMeta {
+		if _, ok := cc.meta[k]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// deflateNegotiation collects client compression offers during upgrade.
+// Offers are accepted in the order of client preference,
+// but the decision is made only after all headers are read (in OnBeforeUpgrade)
+// because policy depends on user agent and query string.
+type deflateNegotiation struct {
+	policy     CompressionPolicy
+	permessage *httphead.Option // response for the first acceptable permessage-deflate offer
+	webkit     *httphead.Option // response for the first acceptable x-webkit-deflate-frame offer
+}
+
+// offer is called for each extension offered by the client.
+// Returned zero option means that nothing is added to the upgrade response here.
+func (n *deflateNegotiation) offer(opt httphead.Option) (httphead.Option, error) {
+	switch string(opt.Name) {
+	case extPermessageDeflate:
+		if n.permessage == nil {
+			n.permessage = acceptPermessageDeflate(opt)
+		}
+	case extWebkitDeflate:
+		if n.webkit == nil && n.policy.WebkitFallback {
+			n.webkit = acceptWebkitDeflate(opt)
+		}
+	}
+	return httphead.Option{}, nil
+}
+
+// accepted returns negotiated extension response, or nil if compression is not used.
+func (n *deflateNegotiation) accepted(cc connCap) *httphead.Option {
+	if !n.policy.allowed(cc) {
+		return nil
+	}
+	if n.permessage != nil {
+		return n.permessage
+	}
+	return n.webkit
+}
+
+// header returns handshake header with accepted extension.
+func (n *deflateNegotiation) header(cc connCap) (ws.HandshakeHeader, bool) {
+	opt := n.accepted(cc)
+	if opt == nil {
+		return nil, false
+	}
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString("Sec-WebSocket-Extensions: ")
+	httphead.WriteOptions(buf, []httphead.Option{*opt})
+	buf.WriteString("\r\n")
+	return ws.HandshakeHeaderBytes(buf.Bytes()), true
+}
+
+// acceptPermessageDeflate validates offer by RFC 7692 and returns response for it.
+// Returns nil if offer is declined.
+//
+// We compress and decompress each message independently so we always
+// respond with both no_context_takeover parameters.
+func acceptPermessageDeflate(opt httphead.Option) *httphead.Option {
+	seen := make(map[string]bool)
+	serverMaxWindowBits := 0
+	valid := true
+	opt.Parameters.ForEach(func(k, v []byte) bool {
+		key := string(k)
+		if seen[key] {
+			valid = false
+			return false
+		}
+		seen[key] = true
+		switch key {
+		case paramServerNoContextTakeover, paramClientNoContextTakeover:
+			valid = len(v) == 0
+		case paramServerMaxWindowBits:
+			serverMaxWindowBits, valid = windowBits(v)
+		case paramClientMaxWindowBits:
+			if len(v) > 0 {
+				_, valid = windowBits(v)
+			}
+		default:
+			valid = false
+		}
+		return valid
+	})
+	if !valid {
+		return nil
+	}
+	if serverMaxWindowBits > 0 && serverMaxWindowBits < maxWindowBits {
+		// client can't handle our window size
+		return nil
+	}
+	rsp := httphead.Option{Name: []byte(extPermessageDeflate)}
+	rsp.Parameters.Set([]byte(paramServerNoContextTakeover), nil)
+	rsp.Parameters.Set([]byte(paramClientNoContextTakeover), nil)
+	if serverMaxWindowBits > 0 {
+		rsp.Parameters.Set([]byte(paramServerMaxWindowBits), []byte(strconv.Itoa(serverMaxWindowBits)))
+	}
+	return &rsp
+}
+
+// acceptWebkitDeflate validates x-webkit-deflate-frame offer and returns response for it.
+func acceptWebkitDeflate(opt httphead.Option) *httphead.Option {
+	valid := true
+	opt.Parameters.ForEach(func(k, v []byte) bool {
+		switch string(k) {
+		case paramNoContextTakeover:
+			valid = len(v) == 0
+		case paramMaxWindowBits:
+			_, valid = windowBits(v)
+		default:
+			valid = false
+		}
+		return valid
+	})
+	if !valid {
+		return nil
+	}
+	rsp := httphead.Option{Name: []byte(extWebkitDeflate)}
+	rsp.Parameters.Set([]byte(paramNoContextTakeover), nil)
+	return &rsp
+}
+
+// windowBits parses window bits parameter value, valid range is 8-15.
+func windowBits(v []byte) (int, bool) {
+	b, err := strconv.Atoi(strings.Trim(string(v), `"`))
+	if err != nil || b < 8 || b > 15 {
+		return 0, false
+	}
+	return b, true
+}
+
+// undeflate uncomresses websocket payload,
+// uncompressed payload is limited to maxFrame (deflate bomb).
+func undeflate(data []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(data)
+	buf.Write([]byte{0x00, 0x00, 0xff, 0xff})
+	r := flate.NewReader(buf)
+	defer r.Close()
+	out := bytes.NewBuffer(nil)
+	if _, err := io.Copy(out, io.LimitReader(r, maxFrame+1)); err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if out.Len() > maxFrame {
+		return nil, errors.Errorf("malformed: uncompressed frame larger than %d", maxFrame)
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,142 @@
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// handshake sends upgrade request with headers to the listener
+// and returns negotiated Sec-WebSocket-Extensions response header and connection capabilities.
+func handshake(t *testing.T, l *listener, uri string, headers map[string]string) (string, connCap) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	done := make(chan connCap)
+	go func() {
+		cc, err := l.upgrade(server)
+		require.NoError(t, err)
+		done <- cc
+	}()
+
+	req, err := http.NewRequest("GET", "http://localhost"+uri, nil)
+	require.NoError(t, err)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	go func() {
+		_ = req.Write(client)
+	}()
+	rsp, err := http.ReadResponse(bufio.NewReader(client), req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, rsp.StatusCode)
+	return rsp.Header.Get("Sec-WebSocket-Extensions"), <-done
+}
+
+func TestNegotiateDeflate(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   CompressionPolicy
+		uri      string
+		headers  map[string]string
+		expected string
+	}{
+		{
+			name:     "no extension offered",
+			policy:   DefaultCompressionPolicy,
+			expected: "",
+		},
+		{
+			name:     "permessage-deflate",
+			policy:   DefaultCompressionPolicy,
+			headers:  map[string]string{"Sec-WebSocket-Extensions": "permessage-deflate; client_max_window_bits"},
+			expected: "permessage-deflate;server_no_context_takeover;client_no_context_takeover",
+		},
+		{
+			name:     "server window bits smaller than ours declines offer, next one is accepted",
+			policy:   DefaultCompressionPolicy,
+			headers:  map[string]string{"Sec-WebSocket-Extensions": "permessage-deflate; server_max_window_bits=10, permessage-deflate; server_max_window_bits=15"},
+			expected: "permessage-deflate;server_no_context_takeover;client_no_context_takeover;server_max_window_bits=15",
+		},
+		{
+			name:     "unknown parameter declines offer",
+			policy:   DefaultCompressionPolicy,
+			headers:  map[string]string{"Sec-WebSocket-Extensions": "permessage-deflate; foo=bar"},
+			expected: "",
+		},
+		{
+			name:     "webkit fallback",
+			policy:   DefaultCompressionPolicy,
+			headers:  map[string]string{"Sec-WebSocket-Extensions": "x-webkit-deflate-frame"},
+			expected: "x-webkit-deflate-frame;no_context_takeover",
+		},
+		{
+			name:     "permessage-deflate preferred over webkit",
+			policy:   DefaultCompressionPolicy,
+			headers:  map[string]string{"Sec-WebSocket-Extensions": "x-webkit-deflate-frame, permessage-deflate"},
+			expected: "permessage-deflate;server_no_context_takeover;client_no_context_takeover",
+		},
+		{
+			name:     "webkit fallback disabled",
+			policy:   CompressionPolicy{},
+			headers:  map[string]string{"Sec-WebSocket-Extensions": "x-webkit-deflate-frame"},
+			expected: "",
+		},
+		{
+			name:   "denied user agent",
+			policy: DefaultCompressionPolicy,
+			headers: map[string]string{
+				"Sec-WebSocket-Extensions": "permessage-deflate",
+				"User-Agent":               "Mozilla/5.0 (iPhone; CPU iPhone OS 16_1 like Mac OS X)",
+			},
+			expected: "",
+		},
+		{
+			name:     "denied meta",
+			policy:   DefaultCompressionPolicy,
+			uri:      "/?klad=1",
+			headers:  map[string]string{"Sec-WebSocket-Extensions": "permessage-deflate"},
+			expected: "",
+		},
+		{
+			name:     "disabled",
+			policy:   CompressionPolicy{Disabled: true},
+			headers:  map[string]string{"Sec-WebSocket-Extensions": "permessage-deflate"},
+			expected: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uri := tt.uri
+			if uri == "" {
+				uri = "/"
+			}
+			ext, cc := handshake(t, &listener{compression: tt.policy}, uri, tt.headers)
+			assert.Equal(t, tt.expected, ext)
+			assert.Equal(t, tt.expected != "", cc.deflateSupported)
+		})
+	}
+}
+
+func TestUndeflate(t *testing.T) {
+	payload := bytes.Repeat([]byte("a"), maxFrame)
+	data, err := undeflate(deflate(t, payload))
+	require.NoError(t, err)
+	assert.Equal(t, payload, data)
+
+	// deflate bomb
+	bomb := deflate(t, bytes.Repeat([]byte("a"), 10*maxFrame))
+	assert.Less(t, len(bomb), maxFrame)
+	_, err = undeflate(bomb)
+	assert.Error(t, err)
+}
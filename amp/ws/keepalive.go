@@ -0,0 +1,65 @@
+package ws
+
+import (
+	"time"
+
+	"github.com/gobwas/ws"
+)
+
+// KeepalivePolicy sets websocket level keepalive and connection deadlines.
+type KeepalivePolicy struct {
+	// PingInterval between ping control frames sent to the client.
+	// Zero disables pings.
+	PingInterval time.Duration
+	// ReadTimeout is max period without any frame from the client (message, ping or pong).
+	// After that connection is considered half-open and it is closed.
+	// Should be longer than PingInterval so client pongs can keep connection open.
+	ReadTimeout time.Duration
+	// WriteTimeout is max duration of the single frame write.
+	WriteTimeout time.Duration
+}
+
+// DefaultKeepalivePolicy is used by listener when no other is set.
+var DefaultKeepalivePolicy = KeepalivePolicy{
+	PingInterval: 16 * time.Second,
+	ReadTimeout:  tcpDeadline,
+	WriteTimeout: 10 * time.Second,
+}
+
+// Connection close reasons, reported in ws.close.<reason> metric.
+const (
+	CloseClient   = "client"   // client closed connection
+	CloseIdle     = "idle"     // nothing received within ReadTimeout, half-open connection
+	CloseWrite    = "write"    // write error or timeout
+	CloseOverflow = "overflow" // session out queue is full, slow client
+	CloseShutdown = "shutdown" // application is closing
+	CloseError    = "error"    // malformed frame or other read error
+)
+
+// keepalive sends ping frames until done is closed
+func (c *Conn) keepalive(interval time.Duration, done <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.writeControl(ws.OpPing); err != nil {
+				_ = c.CloseWithReason(CloseWrite)
+				return
+			}
+		}
+	}
+}
+
+// writeControl writes control frame without payload
+func (c *Conn) writeControl(op ws.OpCode) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	c.setWriteDeadline()
+	return ws.WriteHeader(c.tcpConn, ws.Header{Fin: true, OpCode: op})
+}
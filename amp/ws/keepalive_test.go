@@ -0,0 +1,81 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepalivePing(t *testing.T) {
+	server, client := testConns(false)
+	defer client.Close()
+
+	done := make(chan struct{})
+	go server.keepalive(time.Millisecond, done)
+	f, err := ws.ReadFrame(client.tcpConn)
+	require.NoError(t, err)
+	assert.Equal(t, ws.OpPing, f.Header.OpCode)
+	close(done)
+
+	// client not reading pings, write timeout closes connection
+	server.SetTimeouts(0, 10*time.Millisecond)
+	go server.keepalive(time.Millisecond, make(chan struct{}))
+	_, err = server.Read()
+	assert.Error(t, err)
+	assert.Equal(t, CloseWrite, server.CloseReason())
+}
+
+func TestPingPong(t *testing.T) {
+	server, client := testConns(false)
+	defer server.Close()
+	defer client.Close()
+
+	go func() {
+		f := ws.MaskFrameInPlace(ws.NewPingFrame([]byte("x")))
+		assert.NoError(t, ws.WriteFrame(client.tcpConn, f))
+	}()
+	go func() {
+		payload, err := server.Read()
+		assert.NoError(t, err)
+		assert.Nil(t, payload)
+	}()
+	f, err := ws.ReadFrame(client.tcpConn)
+	require.NoError(t, err)
+	assert.Equal(t, ws.OpPong, f.Header.OpCode)
+	assert.Equal(t, []byte("x"), f.Payload)
+}
+
+func TestReadIdle(t *testing.T) {
+	server, client := testConns(false)
+	defer client.Close()
+
+	server.SetTimeouts(10*time.Millisecond, 0)
+	_, err := server.Read()
+	assert.Error(t, err)
+	assert.Equal(t, CloseIdle, server.CloseReason())
+}
+
+func TestCloseReason(t *testing.T) {
+	server, client := testConns(false)
+	defer client.Close()
+
+	assert.Equal(t, "", server.CloseReason())
+	_ = server.CloseWithReason(CloseOverflow)
+	_, err := server.Read()
+	assert.Error(t, err)
+	_ = server.CloseWithReason(CloseClient)
+	assert.Equal(t, CloseOverflow, server.CloseReason())
+
+	// client close frame
+	server, client = testConns(false)
+	go func() {
+		f := ws.MaskFrameInPlace(ws.NewCloseFrame(nil))
+		_ = ws.WriteFrame(client.tcpConn, f) // server closes connection after the header
+	}()
+	_, err = server.Read()
+	assert.Error(t, err)
+	assert.Equal(t, CloseClient, server.CloseReason())
+}
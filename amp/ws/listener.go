@@ -8,15 +8,40 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gobwas/ws"
 	"github.com/minus5/svckit/log"
+	"github.com/minus5/svckit/metric"
 	"github.com/pkg/errors"
 )
 
 type listener struct {
-	ln        net.Listener
-	onNewConn func(*Conn)
+	ln          net.Listener
+	onNewConn   func(*Conn)
+	compression CompressionPolicy
+	keepalive   KeepalivePolicy
+}
+
+// Compression sets websocket compression policy for the listener.
+// DefaultCompressionPolicy is used if not set.
+func Compression(p CompressionPolicy) func(*listener) {
+	return func(l *listener) {
+		l.compression = p
+	}
+}
+
+// NoCompression disables websocket compression for the listener.
+func NoCompression() func(*listener) {
+	return Compression(CompressionPolicy{Disabled: true})
+}
+
+// Keepalive sets websocket ping interval and read/write timeouts for the listener.
+// DefaultKeepalivePolicy is used if not set.
+func Keepalive(p KeepalivePolicy) func(*listener) {
+	return func(l *listener) {
+		l.keepalive = p
+	}
 }
 
 // Open opens new tcp port.
@@ -42,10 +67,15 @@ func MustOpen(port int) net.Listener {
 
 // Listen starts listening for new connections, blocks until ctx closed.
 // Then stops listening for new connections, and waits for current to finish.
-func Listen(ctx context.Context, ln net.Listener, h func(*Conn)) {
+func Listen(ctx context.Context, ln net.Listener, h func(*Conn), opts ...func(*listener)) {
 	l := &listener{
-		ln:        ln,
-		onNewConn: h,
+		ln:          ln,
+		onNewConn:   h,
+		compression: DefaultCompressionPolicy,
+		keepalive:   DefaultKeepalivePolicy,
+	}
+	for _, fn := range opts {
+		fn(l)
 	}
 	go func() {
 		<-ctx.Done()
@@ -78,8 +108,19 @@ func (l *listener) onConn(tc net.Conn) {
 		_ = tc.Close()
 		return
 	}
+	_ = tc.SetDeadline(time.Time{}) // handshake deadline, Conn sets its own
 	c := newConn(tc, cc)
+	c.SetTimeouts(l.keepalive.ReadTimeout, l.keepalive.WriteTimeout)
+
+	done := make(chan struct{})
+	go c.keepalive(l.keepalive.PingInterval, done)
 	l.onNewConn(c) // ovdje blocka do prekida komunikacije
+	close(done)
+
+	_ = c.CloseWithReason(CloseClient)
+	reason := c.CloseReason()
+	metric.Counter("ws.close." + reason)
+	log.I("no", int(c.No())).S("reason", reason).Debug("connection closed")
 }
 
 func (l *listener) upgrade(tc net.Conn) (connCap, error) {
@@ -87,33 +128,19 @@ func (l *listener) upgrade(tc net.Conn) (connCap, error) {
 		headers: map[string]string{},
 	}
 
+	deflate := &deflateNegotiation{policy: l.compression}
 	ug := ws.Upgrader{
 		// podrzava li klijent websocket permessage-deflate
-		//ExtensionCustom: func(f []byte, os []httphead.Option) ([]httphead.Option, bool) {
-		//	os = make([]httphead.Option, 0)
-		//	field := string(f)
-		//	// skip deflating for kladomat, implementation in Chromium is buggy, constantly reconnects
-		//	if cc.meta["klad"] != "" {
-		//		return os, true
-		//	}
-		//	if strings.Contains(field, "permessage-deflate") && !cc.deflateSupported {
-		//		params := map[string]string{
-		//			"client_no_context_takeover": "",
-		//			"server_no_context_takeover": "",
-		//		}
-		//		os = append(os, httphead.NewOption("permessage-deflate", params))
-		//		cc.deflateSupported = true
-		//	}
-		//	// iPhone (WebKit) salje po starom standardu
-		//	if strings.Contains(field, "x-webkit-deflate-frame") && !cc.deflateSupported {
-		//		params := map[string]string{
-		//			"no_context_takeover": "",
-		//		}
-		//		os = append(os, httphead.NewOption("x-webkit-deflate-frame", params))
-		//		cc.deflateSupported = true
-		//	}
-		//	return os, true
-		//},
+		Negotiate: deflate.offer,
+		// odluka o kompresiji tek kad imamo sve headere (user-agent, query string)
+		OnBeforeUpgrade: func() (ws.HandshakeHeader, error) {
+			h, ok := deflate.header(cc)
+			cc.deflateSupported = ok
+			if !ok {
+				return ws.HandshakeHeaderString(""), nil
+			}
+			return h, nil
+		},
 		OnRequest: func(uri []byte) error {
 			cc.meta = parseQueryString(uri)
 			for k, v := range cc.meta {
@@ -129,10 +156,6 @@ func (l *listener) upgrade(tc net.Conn) (connCap, error) {
 			switch key {
 			case "user-agent":
 				cc.userAgent = value
-				// na iOS 15, 16, ... ne radi vise web kompresija
-				if strings.Contains(value, "OS 1") || strings.Contains(value, "Mac OS X 10_1") {
-					cc.deflateSupported = false
-				}
 			case "x-forwarded-for":
 				if cc.forwardedFor != "" {
 					cc.forwardedFor += " "
@@ -40,7 +40,7 @@ func main() {
 	requester := nsq.MustRequester(interupt)
 	broker := broker.New(requester.Current, nil)
 	broker.Consume(nsq.Subscribe(interupt, inputTopics))
-	sessions := session.Factory(interupt, broker, requester, inputTopics)
+	sessions := session.Factory(interupt, broker, requester, session.TopicWhitelist(inputTopics))
 	defer sessions.Wait()
 
 	go debugHTTP()
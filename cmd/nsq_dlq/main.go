@@ -0,0 +1,100 @@
+// nsq_dlq inspects dead letter topic messages and re-drives them to the source topic.
+//
+// Usage:
+//
+//	nsq_dlq -topic listici.novi           # print dead letters, leave them in the topic
+//	nsq_dlq -topic listici.novi -redrive  # publish them back to listici.novi
+//
+// Dead letters are consumed from the topic.dlq on the dlq channel.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/minus5/svckit/log"
+	"github.com/minus5/svckit/nsq"
+)
+
+type deadLetter struct {
+	*nsq.DeadLetter
+	Body string `json:"body"` // printable body
+}
+
+func main() {
+	topic := flag.String("topic", "", "source topic name (without .dlq suffix)")
+	channel := flag.String("channel", "dlq", "dead letter topic channel")
+	redrive := flag.Bool("redrive", false, "publish messages back to the source topic")
+	max := flag.Int("n", 0, "max number of messages, 0 for all")
+	idle := flag.Duration("idle", 5*time.Second, "exit after no messages for idle duration")
+	flag.Parse()
+	if *topic == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var (
+		pub  *nsq.Producer
+		seen = make(map[string]struct{})
+		cnt  int
+		mu   sync.Mutex
+		msgs = make(chan struct{}, 1)
+		done = make(chan struct{})
+		once sync.Once
+	)
+	if *redrive {
+		pub = nsq.Pub(*topic)
+		defer pub.Close()
+	}
+	handler := func(m *nsq.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		select {
+		case msgs <- struct{}{}:
+		default:
+		}
+		_, repeated := seen[string(m.ID[:])]
+		if repeated || (*max > 0 && cnt >= *max) {
+			// all messages are inspected
+			m.RequeueWithoutBackoff(0)
+			once.Do(func() { close(done) })
+			return nil
+		}
+		d, err := nsq.NewDeadLetter(m.Body)
+		if err != nil {
+			log.Error(err)
+			m.RequeueWithoutBackoff(0)
+			seen[string(m.ID[:])] = struct{}{}
+			return nil
+		}
+		buf, _ := json.Marshal(deadLetter{DeadLetter: d, Body: string(d.Body)})
+		fmt.Println(string(buf))
+		cnt++
+		if !*redrive {
+			seen[string(m.ID[:])] = struct{}{}
+			m.RequeueWithoutBackoff(0)
+			return nil
+		}
+		return d.Redrive(pub)
+	}
+	c := nsq.Sub(nsq.DeadLetterTopic(*topic), handler, nsq.Channel(*channel), nsq.MaxInFlight(1))
+
+	timer := time.NewTimer(*idle)
+loop:
+	for {
+		select {
+		case <-msgs:
+			timer.Reset(*idle)
+		case <-timer.C:
+			break loop
+		case <-done:
+			break loop
+		}
+	}
+	c.Close()
+	log.I("messages", cnt).Info("done")
+}
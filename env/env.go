@@ -82,8 +82,18 @@ func SetNodeName(name string) {
 }
 
 // Hack to know that I'm in running in tests http://stackoverflow.com/a/36666114
+// Testing flags are registered after package init (go 1.13), so in init
+// test binary is recognized by its -test.* arguments (go test always sets them).
 func InTest() bool {
-	return flag.Lookup("test.v") != nil
+	if flag.Lookup("test.v") != nil {
+		return true
+	}
+	for _, a := range os.Args[1:] {
+		if strings.HasPrefix(a, "-test.") {
+			return true
+		}
+	}
+	return false
 }
 
 func InDev() bool {
@@ -12,6 +12,7 @@ import (
 	"github.com/minus5/svckit/env"
 
 	"github.com/minus5/svckit/amp/session"
+	"github.com/minus5/svckit/amp/sse"
 	"github.com/minus5/svckit/health"
 	"github.com/minus5/svckit/httpi"
 	"github.com/minus5/svckit/log"
@@ -37,7 +38,12 @@ func main() {
 	requester := nsq.MustRequester(interupt)
 	broker := broker.New(requester.Current, nil)
 	broker.Consume(nsq.Subscribe(interupt, inputTopics))
-	sessions := session.Factory(interupt, broker, requester, inputTopics)
+	sessions := session.Factory(interupt, broker, requester, session.TopicWhitelist(inputTopics))
+	// direct messages are delivered by the "user" backend header,
+	// set it on the connection after authentication
+	registry := session.NewRegistry("user")
+	registry.Consume(nsq.SubscribeDirect(interupt))
+	sessions.Registry(registry)
 	defer sessions.Wait()
 
 	go debugHTTP()
@@ -47,7 +53,11 @@ func main() {
 }
 
 func poolingHTTP(interupt context.Context, sessions *session.Sessions) {
-	srv := &http.Server{Addr: env.Address(poolingPortLabel), Handler: &restServer{sessions: sessions}}
+	rs := &restServer{
+		sessions: sessions,
+		sse:      sse.NewHandler(func(c *sse.Conn) { sessions.Serve(c) }),
+	}
+	srv := &http.Server{Addr: env.Address(poolingPortLabel), Handler: rs}
 	go func() {
 		<-interupt.Done()
 		srv.Shutdown(context.Background())
@@ -60,6 +70,7 @@ func poolingHTTP(interupt context.Context, sessions *session.Sessions) {
 
 type restServer struct {
 	sessions *session.Sessions
+	sse      *sse.Handler
 }
 
 func (s *restServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -69,6 +80,8 @@ func (s *restServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	case "ping":
 		w.WriteHeader(http.StatusOK)
+	case "sse":
+		s.sse.ServeHTTP(w, r)
 	default:
 		s.pool(w, r)
 	}
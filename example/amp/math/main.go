@@ -8,6 +8,8 @@ import (
 	"github.com/minus5/svckit/amp"
 	"github.com/minus5/svckit/amp/broker"
 	"github.com/minus5/svckit/amp/nsq"
+	"github.com/minus5/svckit/amp/router"
+	"github.com/minus5/svckit/amp/rpc"
 	"github.com/minus5/svckit/env"
 	"github.com/minus5/svckit/health"
 	"github.com/minus5/svckit/httpi"
@@ -107,43 +109,25 @@ func debugHTTP() {
 	httpi.Start(env.Address("debug"))
 }
 
-type requests struct {
-	broker *broker.ReplayBroker
-}
-
-func (r *requests) handler(m *amp.Msg) (*amp.Msg, error) {
-	if m.IsCurrent() {
-		r.broker.Replay(m.URI)
-		return nil, nil
-	}
-
-	if !m.IsRequest() {
-		return nil, nil
-	}
-
-	switch m.Path() {
-	case methodAdd:
-		p := &params{}
-		if err := m.Unmarshal(p); err != nil {
-			return nil, err
-		}
-		z := p.X + p.Y
-		if z == 42 {
-			// example of the error returned
-			return nil, fmt.Errorf("42 is not the number it is THE ANSWER")
-		}
-		return m.Response(amp.JSONMarshaler(&rsp{Z: z})), nil
-	default:
-		return nil, fmt.Errorf("unknown method %s", m.Path())
+func add(ctx context.Context, p params) (rsp, error) {
+	z := p.X + p.Y
+	if z == 42 {
+		// example of the error returned
+		return rsp{}, fmt.Errorf("42 is not the number it is THE ANSWER")
 	}
-	return nil, nil
+	return rsp{Z: z}, nil
 }
 
 func main() {
 	interupt := signal.InteruptContext()
 
 	broker := broker.NewWithReplay()
-	responder := nsq.NewResponder(interupt, (&requests{broker: broker}).handler, reqTopics)
+	rtr := router.New()
+	rtr.Use(rpc.Errors(), router.Recover(), router.Logger())
+	rtr.UseRoute(router.Metrics())
+	rtr.Current(broker.Replay)
+	rtr.Handle("math.req/"+methodAdd, rpc.Handler(add))
+	responder := nsq.NewResponder(interupt, rtr.Serve, reqTopics, nsq.Workers(4), nsq.OrderBy(router.ByMeta("user")))
 	defer responder.Wait()
 
 	pub := nsq.NewPublisher(broker.Pipe(msg2ampMsg(producer(interupt))))
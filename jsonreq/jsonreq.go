@@ -3,6 +3,7 @@ package jsonreq
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"math"
@@ -27,6 +28,7 @@ type request struct {
 	method     string
 	timeout    time.Duration
 	rsp        *http.Response
+	ctx        context.Context
 }
 
 func Timeout(t time.Duration) func(*request) {
@@ -35,6 +37,14 @@ func Timeout(t time.Duration) func(*request) {
 	}
 }
 
+//Context sets request context, retries stop when it is done.
+//Pass nsq handler context to propagate request deadline.
+func Context(ctx context.Context) func(*request) {
+	return func(r *request) {
+		r.ctx = ctx
+	}
+}
+
 //Retries set number of retries and max delay between them
 //delay will be exponentialy incresed from 1 to max
 func Retries(retries, maxRetrySleepSec int) func(*request) {
@@ -107,6 +117,7 @@ func New(url string, options ...func(*request)) *request {
 		timeout:    15 * time.Minute,
 		method:     "POST",
 		headers:    make(map[string]string),
+		ctx:        context.Background(),
 	}
 	//apply options
 	for _, o := range options {
@@ -143,7 +154,11 @@ func (r *request) do() ([]byte, error) {
 			} else {
 				retryAfter := r.calcRetryInterval(retry)
 				log.S("retry", no).I("retryAfter", retryAfter).Error(err)
-				time.Sleep(time.Duration(1e9 * retryAfter))
+				select {
+				case <-time.After(time.Duration(1e9 * retryAfter)):
+				case <-r.ctx.Done():
+					return rsp, r.ctx.Err()
+				}
 			}
 		}
 	}
@@ -151,7 +166,7 @@ func (r *request) do() ([]byte, error) {
 }
 
 func (r *request) one() ([]byte, error, bool) {
-	req, err := http.NewRequest(r.method, dcy.URL(r.url), bytes.NewReader(r.body))
+	req, err := http.NewRequestWithContext(r.ctx, r.method, dcy.URL(r.url), bytes.NewReader(r.body))
 	if err != nil {
 		return nil, err, true
 	}
@@ -166,7 +181,7 @@ func (r *request) one() ([]byte, error, bool) {
 	client := &http.Client{Timeout: r.timeout}
 	rsp, err := client.Do(req)
 	if err != nil {
-		return nil, err, true
+		return nil, err, r.ctx.Err() == nil
 	}
 	defer rsp.Body.Close()
 	r.rsp = rsp
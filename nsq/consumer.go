@@ -14,6 +14,9 @@ type Consumer struct {
 	nsqConsumer *gonsq.Consumer
 	logger      func() *log.Agregator
 	lookups     dcy.Addresses
+	stop        func() // stops Transport subscription, nil for nsqd
+	deadLetters *deadLetters
+	partitioner *partitioner
 }
 
 type nsqHandler struct {
@@ -41,11 +44,19 @@ func MustNewConsumer(topic string, handler func(*Message) error,
 func NewConsumer(topic string, handler func(*Message) error,
 	opts ...func(*options)) (*Consumer, error) {
 
+	if t := currentTransport(); t != nil {
+		return newTransportConsumer(t, topic, handler, opts...)
+	}
 	o := getDefaults().clone()
 	o.apply(opts...)
+	dl, err := newDeadLetters(topic, o)
+	if err != nil {
+		return nil, err
+	}
 
 	cfg := gonsq.NewConfig()
 	cfg.MaxInFlight = o.maxInFlight
+	cfg.MaxAttempts = dl.nsqMaxAttempts(cfg.MaxAttempts)
 	cfg.LookupdPollInterval = 10 * time.Second
 	cfg.OutputBufferSize = -1
 	cfg.OutputBufferTimeout = -1
@@ -55,8 +66,9 @@ func NewConsumer(topic string, handler func(*Message) error,
 		return nil, err
 	}
 
+	h, pt := o.handler(dl.wrap(handler))
 	c.SetLogger(o.logger, o.logLevel)
-	c.AddConcurrentHandlers(&nsqHandler{fn: handler}, o.concurrency)
+	c.AddConcurrentHandlers(&nsqHandler{fn: h}, o.handlerConcurrency())
 
 	err = c.ConnectToNSQLookupds(o.lookupds.String())
 	if err != nil {
@@ -66,6 +78,8 @@ func NewConsumer(topic string, handler func(*Message) error,
 	co := &Consumer{
 		lookups:     o.lookupds,
 		nsqConsumer: c,
+		deadLetters: dl,
+		partitioner: pt,
 		logger: func() *log.Agregator {
 			return logger().S("topic", topic).S("channel", o.channel)
 		},
@@ -77,6 +91,30 @@ func NewConsumer(topic string, handler func(*Message) error,
 	return co, nil
 }
 
+func newTransportConsumer(t Transport, topic string, handler func(*Message) error,
+	opts ...func(*options)) (*Consumer, error) {
+	o := transportDefaults().apply(opts...)
+	dl, err := newDeadLetters(topic, o)
+	if err != nil {
+		return nil, err
+	}
+	h, pt := o.handler(dl.wrap(handler))
+	maxAttempts := dl.nsqMaxAttempts(gonsq.NewConfig().MaxAttempts)
+	stop, err := t.Subscribe(topic, o.channel, o.maxInFlight, o.handlerConcurrency(), maxAttempts, h)
+	if err != nil {
+		pt.close()
+		return nil, err
+	}
+	return &Consumer{
+		stop:        stop,
+		deadLetters: dl,
+		partitioner: pt,
+		logger: func() *log.Agregator {
+			return logger().S("topic", topic).S("channel", o.channel)
+		},
+	}, nil
+}
+
 func (c *Consumer) onLookupChanges(as dcy.Addresses) {
 	for _, a := range as {
 		if err := c.nsqConsumer.ConnectToNSQLookupd(a.String()); err != nil {
@@ -95,6 +133,12 @@ func (c *Consumer) onLookupChanges(as dcy.Addresses) {
 }
 
 func (c *Consumer) Close() {
+	defer c.deadLetters.close()
+	defer c.partitioner.close()
+	if c.stop != nil {
+		c.stop()
+		return
+	}
 	dcy.Unsubscribe(LookupdHTTPServiceName, c.onLookupChanges)
 	dcy.UnsubscribeByTag(LookupdHTTPServiceNameByTag, LookupdHTTPServiceTag, c.onLookupChanges)
 	c.nsqConsumer.Stop()
@@ -104,8 +148,25 @@ func (c *Consumer) Close() {
 // StartClosing will initiate a graceful stop of the Consumer (permanent)
 // Receive on returned chan to block until this process completes
 func (c *Consumer) StartClosing() chan int {
+	if c.stop != nil {
+		ch := make(chan int)
+		go func() {
+			c.stop()
+			c.partitioner.close()
+			c.deadLetters.close()
+			close(ch)
+		}()
+		return ch
+	}
 	dcy.Unsubscribe(LookupdHTTPServiceName, c.onLookupChanges)
 	dcy.UnsubscribeByTag(LookupdHTTPServiceNameByTag, LookupdHTTPServiceTag, c.onLookupChanges)
 	c.nsqConsumer.Stop()
+	if c.deadLetters != nil || c.partitioner != nil {
+		go func() {
+			<-c.nsqConsumer.StopChan
+			c.partitioner.close()
+			c.deadLetters.close()
+		}()
+	}
 	return c.nsqConsumer.StopChan
 }
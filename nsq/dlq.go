@@ -0,0 +1,126 @@
+package nsq
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DeadLetterSuffix is appended to the topic name to get its dead letter topic.
+const DeadLetterSuffix = ".dlq"
+
+// DeadLetterTopic returns name of the dead letter topic for the topic.
+func DeadLetterTopic(topic string) string {
+	return topic + DeadLetterSuffix
+}
+
+// DeadLetter wraps message which handler failed in all attempts.
+// It is published to the dead letter topic of the consumer topic.
+type DeadLetter struct {
+	Topic     string    `json:"topic"`
+	Channel   string    `json:"channel"`
+	ID        string    `json:"id"`
+	Attempts  uint16    `json:"attempts"`
+	Error     string    `json:"error,omitempty"` // last handler error
+	Published time.Time `json:"published"`       // when original message was published
+	Failed    time.Time `json:"failed"`          // when the last attempt failed
+	Body      []byte    `json:"body"`            // original message body
+}
+
+// NewDeadLetter decodes dead letter topic message.
+func NewDeadLetter(buf []byte) (*DeadLetter, error) {
+	d := &DeadLetter{}
+	if err := json.Unmarshal(buf, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Bytes encodes dead letter for putting on wire.
+func (d *DeadLetter) Bytes() []byte {
+	buf, _ := json.Marshal(d)
+	return buf
+}
+
+// Redrive publishes original message back to the source topic.
+func (d *DeadLetter) Redrive(p *Producer) error {
+	return p.PublishTo(d.Topic, d.Body)
+}
+
+// deadLetters publishes messages which failed max attempts
+type deadLetters struct {
+	topic       string
+	channel     string
+	maxAttempts uint16
+	producer    *Producer
+}
+
+func newDeadLetters(topic string, o *options) (*deadLetters, error) {
+	if o.maxAttempts == 0 {
+		return nil, nil
+	}
+	p, err := NewProducer(DeadLetterTopic(topic))
+	if err != nil {
+		return nil, err
+	}
+	return &deadLetters{
+		topic:       topic,
+		channel:     o.channel,
+		maxAttempts: o.maxAttempts,
+		producer:    p,
+	}, nil
+}
+
+// nsqMaxAttempts returns consumer max attempts for nsqd (or Transport),
+// with dead letters messages are never dropped
+func (d *deadLetters) nsqMaxAttempts(def uint16) uint16 {
+	if d == nil {
+		return def
+	}
+	return 0
+}
+
+// wrap publishes message to the dead letter topic when handler fails
+// (returns error or requeues message) in the last attempt
+func (d *deadLetters) wrap(handler func(*Message) error) func(*Message) error {
+	if d == nil {
+		return handler
+	}
+	return func(m *Message) error {
+		m.lastAttempt = m.Attempts >= d.maxAttempts
+		err := handler(m)
+		if !m.lastAttempt || (err == nil && !m.requeued) {
+			return err
+		}
+		if err == nil {
+			err = m.err
+		}
+		dl := &DeadLetter{
+			Topic:     d.topic,
+			Channel:   d.channel,
+			ID:        string(m.ID[:]),
+			Attempts:  m.Attempts,
+			Published: time.Unix(0, m.Timestamp),
+			Failed:    time.Now(),
+			Body:      m.Body,
+		}
+		if err != nil {
+			dl.Error = err.Error()
+		}
+		if perr := d.producer.Publish(dl.Bytes()); perr != nil {
+			// message stays in the source topic
+			logger().S("topic", d.topic).S("channel", d.channel).Error(perr)
+			return perr
+		}
+		logger().S("topic", d.topic).S("channel", d.channel).S("id", dl.ID).
+			I("attempts", int(m.Attempts)).S("error", dl.Error).Info("dead letter")
+		m.requeued, m.err = false, nil
+		return nil
+	}
+}
+
+func (d *deadLetters) close() {
+	if d == nil {
+		return
+	}
+	d.producer.Close()
+}
@@ -0,0 +1,98 @@
+package nsq_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/minus5/svckit/nsq"
+	"github.com/minus5/svckit/nsq/nsqtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetter(t *testing.T) {
+	n := nsqtest.Start(t, nsqtest.RequeueDelay(0))
+	var attempts int
+	c := nsq.Sub("dlq.test", func(m *nsq.Message) error {
+		attempts++
+		if string(m.Body) == "requeue" {
+			m.RequeueWithoutBackoff(0)
+			return nil
+		}
+		return errors.New("handler error")
+	}, nsq.Channel("test"), nsq.MaxAttempts(3), nsq.Concurrency(1))
+	defer c.Close()
+
+	nsq.Pub("dlq.test").MustPublish([]byte("error"))
+	n.Wait()
+	assert.Equal(t, 3, attempts)
+	dls := n.Published(nsq.DeadLetterTopic("dlq.test"))
+	require.Len(t, dls, 1)
+	d, err := nsq.NewDeadLetter(dls[0])
+	require.NoError(t, err)
+	assert.Equal(t, "dlq.test", d.Topic)
+	assert.Equal(t, "test", d.Channel)
+	assert.Equal(t, uint16(3), d.Attempts)
+	assert.Equal(t, "handler error", d.Error)
+	assert.Equal(t, "error", string(d.Body))
+	assert.False(t, d.Published.IsZero())
+	assert.False(t, d.Failed.Before(d.Published))
+
+	attempts = 0
+	nsq.Pub("dlq.test").MustPublish([]byte("requeue"))
+	n.Wait()
+	assert.Equal(t, 3, attempts)
+	dls = n.Published(nsq.DeadLetterTopic("dlq.test"))
+	require.Len(t, dls, 2)
+	d, err = nsq.NewDeadLetter(dls[1])
+	require.NoError(t, err)
+	assert.Equal(t, "", d.Error)
+
+	// redrive to the source topic
+	attempts = 0
+	require.NoError(t, d.Redrive(nsq.Pub("dlq.test")))
+	n.Wait()
+	assert.Equal(t, 3, attempts)
+	assert.Len(t, n.Published("dlq.test"), 3)
+}
+
+// nsqtest default max attempts (same as go-nsq) doesn't drop dead letter consumer messages
+func TestDeadLetterMaxAttempts(t *testing.T) {
+	n := nsqtest.Start(t, nsqtest.RequeueDelay(0))
+	var attempts int
+	c := nsq.Sub("dlq.attempts", func(m *nsq.Message) error {
+		attempts++
+		return errors.New("handler error")
+	}, nsq.Channel("test"), nsq.MaxAttempts(nsqtest.DefaultMaxAttempts+2), nsq.Concurrency(1))
+	defer c.Close()
+
+	nsq.Pub("dlq.attempts").MustPublish([]byte("error"))
+	n.Wait()
+	assert.Equal(t, nsqtest.DefaultMaxAttempts+2, attempts)
+	dls := n.Published(nsq.DeadLetterTopic("dlq.attempts"))
+	require.Len(t, dls, 1)
+	d, err := nsq.NewDeadLetter(dls[0])
+	require.NoError(t, err)
+	assert.Equal(t, uint16(nsqtest.DefaultMaxAttempts+2), d.Attempts)
+}
+
+func TestRrSubDeadLetter(t *testing.T) {
+	n := nsqtest.Start(t)
+	defer func(d time.Duration) { nsq.RequeueDelay = d }(nsq.RequeueDelay)
+	nsq.RequeueDelay = 0
+	s := nsq.RrSub("dlq.rr", func(typ string, body []byte) (interface{}, error) {
+		return nil, errors.New("rr error")
+	}, nsq.ConsumerOptions(nsq.MaxAttempts(2)))
+	defer s.Close()
+
+	e := &nsq.Envelope{Type: "t", Body: []byte("{}")}
+	nsq.Pub("dlq.rr").MustPublish(e.Bytes())
+	n.Wait()
+	dls := n.Published(nsq.DeadLetterTopic("dlq.rr"))
+	require.Len(t, dls, 1)
+	d, err := nsq.NewDeadLetter(dls[0])
+	require.NoError(t, err)
+	assert.Equal(t, "rr error", d.Error)
+	assert.Equal(t, uint16(2), d.Attempts)
+}
@@ -2,6 +2,7 @@ package nsq
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"strings"
 	"time"
@@ -20,8 +21,10 @@ type Envelope struct {
 	// connection between request and response
 	CorrelationId string `json:"c,omitempty"`
 	// unix timestamp when message expires, after that should be dropped
-	ExpiresAt int64  `json:"e,omitempty"`
-	Error     string `json:"error,omitempty"`
+	ExpiresAt int64 `json:"e,omitempty"`
+	// unix timestamp in milliseconds when message expires, more precise ExpiresAt
+	Deadline int64  `json:"d,omitempty"`
+	Error    string `json:"error,omitempty"`
 	// message body
 	Body []byte `json:"-"`
 }
@@ -78,8 +81,36 @@ func (m *Envelope) Reply(o interface{}, err error) (*Envelope, error) {
 	return e, nil
 }
 
+// SetDeadline sets envelope expiration.
+func (m *Envelope) SetDeadline(t time.Time) {
+	m.ExpiresAt = t.Unix()
+	m.Deadline = t.UnixNano() / int64(time.Millisecond)
+}
+
+// ExpiresIn returns envelope expiration time, false if it doesn't expire.
+func (m *Envelope) ExpiresIn() (time.Time, bool) {
+	if m.Deadline > 0 {
+		return time.Unix(0, m.Deadline*int64(time.Millisecond)), true
+	}
+	if m.ExpiresAt > 0 {
+		return time.Unix(m.ExpiresAt, 0), true
+	}
+	return time.Time{}, false
+}
+
+// Context returns context which expires with the envelope.
+func (m *Envelope) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	if d, ok := m.ExpiresIn(); ok {
+		return context.WithDeadline(parent, d)
+	}
+	return context.WithCancel(parent)
+}
+
 // Expired returns true if message expired.
 func (m *Envelope) Expired() bool {
+	if m.Deadline > 0 {
+		return time.Now().UnixNano()/int64(time.Millisecond) > m.Deadline
+	}
 	if m.ExpiresAt <= 0 {
 		return false
 	}
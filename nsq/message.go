@@ -8,12 +8,19 @@ import (
 
 // Presipavam da klijent ne bi morao referencirati go-nsq package.
 type Message struct {
-	nsqm        *gonsq.Message
+	nsqm        MessageDelegate
 	ID          gonsq.MessageID
 	Body        []byte
 	Timestamp   int64
 	Attempts    uint16
 	NSQDAddress string
+
+	lastAttempt  bool          // requeue is replaced by publishing to the dead letter topic
+	requeued     bool          // handler requeued message
+	requeueDelay time.Duration // requested requeue delay
+	err          error         // requeue reason
+	hold         bool          // requeue is handled by the partition worker
+	async        asyncDelegate // finished by the partition worker
 }
 
 func newMessage(m *gonsq.Message) *Message {
@@ -28,9 +35,27 @@ func newMessage(m *gonsq.Message) *Message {
 }
 
 func (m *Message) RequeueWithoutBackoff(delay time.Duration) {
+	m.requeued = true
+	m.requeueDelay = delay
+	if m.lastAttempt || m.hold {
+		return
+	}
 	m.nsqm.RequeueWithoutBackoff(delay)
 }
 
+// finish responds to message handled by the partition worker
+func (m *Message) finish() {
+	if m.async != nil {
+		m.async.Finish()
+	}
+}
+
+// requeue requeues message and remembers error for the dead letter
+func (m *Message) requeue(delay time.Duration, err error) {
+	m.err = err
+	m.RequeueWithoutBackoff(delay)
+}
+
 func (m *Message) Touch() {
 	m.nsqm.Touch()
 }
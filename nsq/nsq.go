@@ -49,14 +49,17 @@ func getDefaults() *options {
 func Set(opts ...func(*options)) {
 	initMu.Lock()
 	defer initMu.Unlock()
+	if defaults == nil && transport != nil {
+		defaults = baseDefaults()
+	}
 	if defaults == nil {
 		initDefaults()
 	}
 	defaults.apply(opts...)
 }
 
-func initDefaults() {
-	defaults = &options{
+func baseDefaults() *options {
+	return &options{
 		maxInFlight: DefaultMaxInFlight,
 		concurrency: DefaultConcurrency,
 		channel:     fmt.Sprintf("%s-%s", env.AppName(), env.InstanceId()),
@@ -65,6 +68,21 @@ func initDefaults() {
 		logLevel:    gonsq.LogLevelWarning,
 		logger:      &nsqLogger{},
 	}
+}
+
+// transportDefaults returns defaults without nsqd and lookupd discovery,
+// options set with Set are used if they are already initialized.
+func transportDefaults() *options {
+	initMu.Lock()
+	defer initMu.Unlock()
+	if defaults != nil {
+		return defaults.clone()
+	}
+	return baseDefaults()
+}
+
+func initDefaults() {
+	defaults = baseDefaults()
 	if e, ok := os.LookupEnv(EnvNsqd); ok && e != "" {
 		defaults.nsqdTCPAddr = e
 		logger().S("nsqd", defaults.nsqdTCPAddr).Debug("init nsqd")
@@ -0,0 +1,386 @@
+// Package nsqtest is in memory nsqd for tests.
+//
+// Start switches nsq package Producers and Consumers (and everything built
+// on them: RrPub, RrSub, amp/nsq Requester, Responder...) to the in memory
+// transport, so they work without nsqd and lookupd:
+//
+//	n := nsqtest.Start(t)
+//	c := nsq.Sub("topic", handler, nsq.Channel("test"))
+//	defer c.Close()
+//	nsq.Pub("topic").Publish(buf)
+//	n.Wait() // until handler is done
+//
+// Semantics follow nsqd: each channel gets copy of every topic message,
+// consumers of the same channel share its messages, messages published
+// before the first channel is created are delivered to it, channels with
+// #ephemeral suffix are removed with the last consumer. Message is requeued
+// when handler returns error or calls RequeueWithoutBackoff. Message with
+// disabled auto response stays in flight until Finish or requeue.
+// Messages never time out, Touch is only counted.
+//
+// nsq package imports dcy which looks for consul on init. Test binaries
+// (go test) use dcy test mode without consul, unless SVCKIT_DCY_CONSUL is set.
+// Run test binary started otherwise with SVCKIT_DCY_CONSUL=- .
+package nsqtest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minus5/svckit/nsq"
+	gonsq "github.com/nsqio/go-nsq"
+)
+
+const (
+	// DefaultRequeueDelay is delay for messages which handler returned error.
+	DefaultRequeueDelay = 10 * time.Millisecond
+	// DefaultMaxAttempts after which message is dropped, same as go-nsq default.
+	// Consumers without limit (nsq consumer with dead letter topic) never drop messages.
+	DefaultMaxAttempts = 5
+)
+
+// Nsqd is in memory nsqd, implements nsq.Transport.
+type Nsqd struct {
+	topics       map[string]*topic
+	published    map[string][][]byte
+	requeueDelay time.Duration
+	maxAttempts  uint16
+	msgID        uint64
+	touches      int
+	cond         *sync.Cond // signals any change of the queues
+	sync.Mutex
+}
+
+type topic struct {
+	channels map[string]*channel
+	pending  []*message // published before the first channel
+}
+
+type channel struct {
+	name      string
+	queue     []*message
+	inFlight  int
+	deferred  int // requeued with delay
+	consumers int
+}
+
+type consumer struct {
+	ch          *channel
+	maxInFlight int
+	maxAttempts uint16 // 0 for no limit
+	inFlight    int
+	stopped     bool
+	handler     func(*nsq.Message) error
+	wg          sync.WaitGroup
+}
+
+type message struct {
+	id       gonsq.MessageID
+	body     []byte
+	attempts uint16
+	ch       *channel
+}
+
+// delivery of the message to the consumer, implements nsq.MessageDelegate
+type delivery struct {
+	*message
+	n         *Nsqd
+	c         *consumer
+	responded bool // finished or requeued
+	async     bool // auto response disabled
+}
+
+// RequeueDelay sets delay for messages which handler returned error.
+func RequeueDelay(d time.Duration) func(*Nsqd) {
+	return func(n *Nsqd) {
+		n.requeueDelay = d
+	}
+}
+
+// MaxAttempts sets number of attempts after which message is dropped,
+// for consumers with the attempts limit.
+func MaxAttempts(a uint16) func(*Nsqd) {
+	return func(n *Nsqd) {
+		n.maxAttempts = a
+	}
+}
+
+// New creates in memory nsqd, use nsq.SetTransport to switch to it.
+func New(opts ...func(*Nsqd)) *Nsqd {
+	n := &Nsqd{
+		topics:       make(map[string]*topic),
+		published:    make(map[string][][]byte),
+		requeueDelay: DefaultRequeueDelay,
+		maxAttempts:  DefaultMaxAttempts,
+	}
+	n.cond = sync.NewCond(&n.Mutex)
+	for _, opt := range opts {
+		opt(n)
+	}
+	return n
+}
+
+// Start creates in memory nsqd and switches nsq package to it until the end of the test.
+func Start(t testing.TB, opts ...func(*Nsqd)) *Nsqd {
+	n := New(opts...)
+	nsq.SetTransport(n)
+	t.Cleanup(func() { nsq.SetTransport(nil) })
+	return n
+}
+
+// Publish implements nsq.Transport.
+func (n *Nsqd) Publish(name string, body []byte) error {
+	if name == "" {
+		return fmt.Errorf("nsqtest: empty topic name")
+	}
+	n.Lock()
+	defer n.Unlock()
+	n.published[name] = append(n.published[name], body)
+	t := n.topic(name)
+	if len(t.channels) == 0 {
+		t.pending = append(t.pending, n.newMessage(body))
+		return nil
+	}
+	for _, ch := range t.channels {
+		m := n.newMessage(body)
+		m.ch = ch
+		ch.queue = append(ch.queue, m)
+	}
+	n.cond.Broadcast()
+	return nil
+}
+
+// Subscribe implements nsq.Transport.
+// Consumer with maxAttempts 0 never drops messages, others are limited by
+// the MaxAttempts option.
+func (n *Nsqd) Subscribe(name, channelName string, maxInFlight, concurrency int, maxAttempts uint16,
+	handler func(*nsq.Message) error) (func(), error) {
+	if maxInFlight < 1 {
+		maxInFlight = 1
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	n.Lock()
+	ch := n.channel(name, channelName)
+	ch.consumers++
+	c := &consumer{ch: ch, maxInFlight: maxInFlight, maxAttempts: maxAttempts, handler: handler}
+	n.Unlock()
+
+	for i := 0; i < concurrency; i++ {
+		c.wg.Add(1)
+		go n.work(c)
+	}
+	stop := func() {
+		n.Lock()
+		if c.stopped {
+			n.Unlock()
+			return
+		}
+		c.stopped = true
+		n.cond.Broadcast()
+		n.Unlock()
+		c.wg.Wait()
+
+		n.Lock()
+		defer n.Unlock()
+		ch.consumers--
+		if ch.consumers == 0 && strings.HasSuffix(ch.name, "#ephemeral") {
+			delete(n.topic(name).channels, ch.name)
+		}
+		n.cond.Broadcast()
+	}
+	return stop, nil
+}
+
+// work delivers channel messages to the consumer handler
+func (n *Nsqd) work(c *consumer) {
+	defer c.wg.Done()
+	for {
+		n.Lock()
+		for !c.stopped && (len(c.ch.queue) == 0 || c.inFlight >= c.maxInFlight) {
+			n.cond.Wait()
+		}
+		if c.stopped {
+			n.Unlock()
+			return
+		}
+		m := c.ch.queue[0]
+		c.ch.queue = c.ch.queue[1:]
+		m.attempts++
+		if c.maxAttempts > 0 && m.attempts > n.maxAttempts {
+			// giving up, same as go-nsq
+			n.cond.Broadcast()
+			n.Unlock()
+			continue
+		}
+		d := &delivery{message: m, n: n, c: c}
+		c.inFlight++
+		c.ch.inFlight++
+		n.Unlock()
+
+		err := c.handler(nsq.NewMessage(m.id, m.body, m.attempts, d))
+
+		n.Lock()
+		if !d.async {
+			n.respond(d, err != nil, n.requeueDelay)
+		}
+		n.Unlock()
+	}
+}
+
+// RequeueWithoutBackoff implements nsq.MessageDelegate.
+func (d *delivery) RequeueWithoutBackoff(delay time.Duration) {
+	d.n.Lock()
+	defer d.n.Unlock()
+	d.n.respond(d, true, delay)
+}
+
+// Touch implements nsq.MessageDelegate.
+func (d *delivery) Touch() {
+	d.n.Lock()
+	defer d.n.Unlock()
+	d.n.touches++
+}
+
+// DisableAutoResponse leaves message in flight after handler returns,
+// until Finish or RequeueWithoutBackoff is called.
+func (d *delivery) DisableAutoResponse() {
+	d.n.Lock()
+	defer d.n.Unlock()
+	d.async = true
+}
+
+// Finish finishes message with disabled auto response.
+func (d *delivery) Finish() {
+	d.n.Lock()
+	defer d.n.Unlock()
+	d.n.respond(d, false, 0)
+}
+
+// respond finishes or requeues in flight message, must be called under lock
+func (n *Nsqd) respond(d *delivery, requeue bool, delay time.Duration) {
+	if d.responded {
+		return
+	}
+	d.responded = true
+	d.c.inFlight--
+	d.ch.inFlight--
+	if requeue {
+		n.requeue(d.message, delay)
+	}
+	n.cond.Broadcast()
+}
+
+// requeue must be called under lock
+func (n *Nsqd) requeue(m *message, delay time.Duration) {
+	if delay <= 0 {
+		m.ch.queue = append(m.ch.queue, m)
+		n.cond.Broadcast()
+		return
+	}
+	m.ch.deferred++
+	time.AfterFunc(delay, func() {
+		n.Lock()
+		defer n.Unlock()
+		m.ch.deferred--
+		m.ch.queue = append(m.ch.queue, m)
+		n.cond.Broadcast()
+	})
+}
+
+// topic must be called under lock
+func (n *Nsqd) topic(name string) *topic {
+	t, ok := n.topics[name]
+	if !ok {
+		t = &topic{channels: make(map[string]*channel)}
+		n.topics[name] = t
+	}
+	return t
+}
+
+// channel must be called under lock
+func (n *Nsqd) channel(topicName, name string) *channel {
+	t := n.topic(topicName)
+	ch, ok := t.channels[name]
+	if ok {
+		return ch
+	}
+	ch = &channel{name: name}
+	t.channels[name] = ch
+	if len(t.channels) == 1 {
+		for _, m := range t.pending {
+			m.ch = ch
+		}
+		ch.queue = t.pending
+		t.pending = nil
+	}
+	return ch
+}
+
+func (n *Nsqd) newMessage(body []byte) *message {
+	n.msgID++
+	var id gonsq.MessageID
+	copy(id[:], fmt.Sprintf("%016x", n.msgID))
+	b := make([]byte, len(body))
+	copy(b, body)
+	return &message{id: id, body: b}
+}
+
+// Wait blocks until all messages in the channels with consumers are handled,
+// including the requeued ones.
+func (n *Nsqd) Wait() {
+	n.Lock()
+	defer n.Unlock()
+	for !n.idle() {
+		n.cond.Wait()
+	}
+}
+
+// idle must be called under lock
+func (n *Nsqd) idle() bool {
+	for _, t := range n.topics {
+		for _, ch := range t.channels {
+			if ch.consumers > 0 && (len(ch.queue) > 0 || ch.inFlight > 0 || ch.deferred > 0) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Published returns all messages published to the topic.
+func (n *Nsqd) Published(topic string) [][]byte {
+	n.Lock()
+	defer n.Unlock()
+	return append([][]byte(nil), n.published[topic]...)
+}
+
+// Depth returns number of messages waiting in the channel,
+// or in the topic if channel is empty string.
+func (n *Nsqd) Depth(topic, channel string) int {
+	n.Lock()
+	defer n.Unlock()
+	t, ok := n.topics[topic]
+	if !ok {
+		return 0
+	}
+	if channel == "" {
+		return len(t.pending)
+	}
+	ch, ok := t.channels[channel]
+	if !ok {
+		return 0
+	}
+	return len(ch.queue) + ch.deferred
+}
+
+// Touches returns number of Touch calls on all messages.
+func (n *Nsqd) Touches() int {
+	n.Lock()
+	defer n.Unlock()
+	return n.touches
+}
@@ -0,0 +1,148 @@
+package nsqtest
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/minus5/svckit/nsq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func subscribe(t *testing.T, n *Nsqd, topic, channel string, handler func(*nsq.Message) error) func() {
+	stop, err := n.Subscribe(topic, channel, 1, 1, DefaultMaxAttempts, handler)
+	require.NoError(t, err)
+	return stop
+}
+
+func TestChannels(t *testing.T) {
+	n := New()
+	var a, b int32
+	defer subscribe(t, n, "t", "a", func(*nsq.Message) error { atomic.AddInt32(&a, 1); return nil })()
+	defer subscribe(t, n, "t", "a", func(*nsq.Message) error { atomic.AddInt32(&a, 1); return nil })()
+	defer subscribe(t, n, "t", "b", func(*nsq.Message) error { atomic.AddInt32(&b, 1); return nil })()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, n.Publish("t", []byte("x")))
+	}
+	n.Wait()
+	assert.Equal(t, int32(10), atomic.LoadInt32(&a))
+	assert.Equal(t, int32(10), atomic.LoadInt32(&b))
+	assert.Len(t, n.Published("t"), 10)
+}
+
+func TestPending(t *testing.T) {
+	n := New()
+	require.NoError(t, n.Publish("t", []byte("1")))
+	require.NoError(t, n.Publish("t", []byte("2")))
+	assert.Equal(t, 2, n.Depth("t", ""))
+
+	var bodies []string
+	defer subscribe(t, n, "t", "a", func(m *nsq.Message) error {
+		bodies = append(bodies, string(m.Body))
+		return nil
+	})()
+	n.Wait()
+	assert.Equal(t, []string{"1", "2"}, bodies)
+	assert.Equal(t, 0, n.Depth("t", ""))
+}
+
+func TestRequeue(t *testing.T) {
+	n := New(RequeueDelay(time.Millisecond), MaxAttempts(3))
+	var attempts []uint16
+	defer subscribe(t, n, "t", "a", func(m *nsq.Message) error {
+		attempts = append(attempts, m.Attempts)
+		if string(m.Body) == "delay" && m.Attempts == 1 {
+			m.RequeueWithoutBackoff(time.Millisecond)
+			return nil
+		}
+		if string(m.Body) == "error" {
+			return errors.New("handler error")
+		}
+		return nil
+	})()
+	require.NoError(t, n.Publish("t", []byte("delay")))
+	n.Wait()
+	assert.Equal(t, []uint16{1, 2}, attempts)
+
+	attempts = nil
+	require.NoError(t, n.Publish("t", []byte("error")))
+	n.Wait()
+	assert.Equal(t, []uint16{1, 2, 3}, attempts)
+}
+
+func TestMaxInFlight(t *testing.T) {
+	n := New()
+	var inFlight, max int32
+	var mu sync.Mutex
+	stop, err := n.Subscribe("t", "a", 2, 4, DefaultMaxAttempts, func(m *nsq.Message) error {
+		i := atomic.AddInt32(&inFlight, 1)
+		mu.Lock()
+		if i > max {
+			max = i
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		return nil
+	})
+	require.NoError(t, err)
+	defer stop()
+	for i := 0; i < 20; i++ {
+		require.NoError(t, n.Publish("t", []byte("x")))
+	}
+	n.Wait()
+	assert.Equal(t, int32(2), max)
+}
+
+func TestConsumerConcurrency(t *testing.T) {
+	n := Start(t)
+	var inFlight, max int32
+	var mu sync.Mutex
+	c := nsq.Sub("concurrency", func(m *nsq.Message) error {
+		i := atomic.AddInt32(&inFlight, 1)
+		mu.Lock()
+		if i > max {
+			max = i
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		return nil
+	}, nsq.Channel("test"), nsq.MaxInFlight(8), nsq.Concurrency(2))
+	defer c.Close()
+	for i := 0; i < 20; i++ {
+		require.NoError(t, n.Publish("concurrency", []byte("x")))
+	}
+	n.Wait()
+	assert.Equal(t, int32(2), max)
+}
+
+func TestEphemeral(t *testing.T) {
+	n := New()
+	stop := subscribe(t, n, "t", "a#ephemeral", func(*nsq.Message) error { return nil })
+	stop()
+	require.NoError(t, n.Publish("t", []byte("x")))
+	assert.Equal(t, 1, n.Depth("t", ""))
+	assert.Equal(t, 0, n.Depth("t", "a#ephemeral"))
+}
+
+func TestRequestResponse(t *testing.T) {
+	Start(t)
+	// RrPub caches producers by topic, unique topics for each run
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	s := nsq.RrSub("nsqtest.req."+suffix, func(typ string, body []byte) (interface{}, error) {
+		return map[string]string{"typ": typ, "req": string(body)}, nil
+	})
+	defer s.Close()
+	p := nsq.RrPub("nsqtest.rsp." + suffix)
+	defer p.Close()
+
+	var rsp map[string]string
+	err := p.ReqRsp("nsqtest.req."+suffix, "echo", "ping", &rsp, nil, time.Second, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"typ": "echo", "req": `"ping"`}, rsp)
+}
@@ -57,6 +57,10 @@ type options struct {
 	logger      *nsqLogger
 	logLevel    gonsq.LogLevel
 	lookupds    dcy.Addresses
+	maxAttempts uint16
+
+	partitions   int
+	partitionKey func(*Message) string
 }
 
 func (o *options) clone() *options {
@@ -83,6 +87,24 @@ func (c *options) Concurrency() int {
 	return c.maxInFlight
 }
 
+// handler wraps handler with partitioner when Partitioned is set
+func (c *options) handler(h func(*Message) error) (func(*Message) error, *partitioner) {
+	if c.partitions <= 0 {
+		return h, nil
+	}
+	p := newPartitioner(c, h)
+	return p.dispatch, p
+}
+
+// handlerConcurrency is number of go-nsq (or Transport) handlers,
+// partitioned consumer dispatches from the single one
+func (c *options) handlerConcurrency() int {
+	if c.partitions > 0 {
+		return 1
+	}
+	return c.Concurrency()
+}
+
 func MaxInFlight(m int) func(*options) {
 	return func(o *options) {
 		o.maxInFlight = m
@@ -110,6 +132,15 @@ func Ordered() func(*options) {
 	}
 }
 
+// MaxAttempts sets number of handler attempts after which message is
+// published to the dead letter topic (topic name with .dlq suffix).
+// Handler attempt fails when it returns error or requeues message.
+func MaxAttempts(a uint16) func(*options) {
+	return func(o *options) {
+		o.maxAttempts = a
+	}
+}
+
 // LogLevelDebug sets log level to Debug for underlying go-nsq package.
 func LogLevelDebug() func(*options) {
 	return func(o *options) {
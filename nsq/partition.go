@@ -0,0 +1,134 @@
+package nsq
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// PartitionAttempts is number of handler attempts in the partition worker
+// when MaxAttempts is not set. After that message is requeued to nsqd and
+// its order is not preserved any more.
+const PartitionAttempts = 5
+
+// Partitioned routes messages by key to the workers, each key is always
+// handled by the same worker. Messages with the same key are handled in
+// order of arrival, different keys in parallel.
+// Failed message (handler returns error or requeues) is retried by the worker
+// so later messages with the same key wait for it. Retries are limited by
+// MaxAttempts (message goes to dead letter topic) or PartitionAttempts.
+// Set MaxInFlight larger than workers to keep them busy.
+func Partitioned(workers int, key func(*Message) string) func(*options) {
+	return func(o *options) {
+		o.partitions = workers
+		o.partitionKey = key
+	}
+}
+
+// EnvelopeKey creates Partitioned key function from the message Envelope.
+func EnvelopeKey(key func(*Envelope) string) func(*Message) string {
+	return func(m *Message) string {
+		e, err := NewEnvelope(m.Body)
+		if err != nil {
+			return ""
+		}
+		return key(e)
+	}
+}
+
+// asyncDelegate is message which can be finished after handler returns
+type asyncDelegate interface {
+	DisableAutoResponse()
+	Finish()
+}
+
+type partitioner struct {
+	workers     []chan partitioned
+	key         func(*Message) string
+	handler     func(*Message) error
+	maxAttempts uint16
+	wg          sync.WaitGroup
+}
+
+// partitioned message waiting for the worker
+type partitioned struct {
+	m         *Message
+	stopTouch chan struct{}
+}
+
+func newPartitioner(o *options, handler func(*Message) error) *partitioner {
+	p := &partitioner{
+		key:         o.partitionKey,
+		handler:     handler,
+		maxAttempts: o.maxAttempts,
+	}
+	if p.maxAttempts == 0 {
+		p.maxAttempts = PartitionAttempts
+	}
+	for i := 0; i < o.partitions; i++ {
+		w := make(chan partitioned, o.maxInFlight)
+		p.workers = append(p.workers, w)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for pm := range w {
+				p.handle(pm.m)
+				close(pm.stopTouch)
+			}
+		}()
+	}
+	return p
+}
+
+// dispatch sends message to the key worker,
+// must be called from the single goroutine to preserve order
+func (p *partitioner) dispatch(m *Message) error {
+	ad, ok := m.nsqm.(asyncDelegate)
+	if !ok {
+		// can't finish message later, handle in order
+		p.handle(m)
+		return nil
+	}
+	ad.DisableAutoResponse()
+	m.async = ad
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(p.key(m)))
+	// touch while waiting in the worker queue and during retries
+	p.workers[h.Sum32()%uint32(len(p.workers))] <- partitioned{
+		m:         m,
+		stopTouch: every(DefaultMsgTouchInterval, m.Touch),
+	}
+	return nil
+}
+
+// handle calls handler until it succeeds or attempts are exhausted
+func (p *partitioner) handle(m *Message) {
+	m.hold = true
+	for {
+		m.requeued, m.requeueDelay, m.err = false, RequeueDelay, nil
+		err := p.handler(m)
+		if err == nil && !m.requeued {
+			m.finish()
+			return
+		}
+		if m.Attempts >= p.maxAttempts {
+			logger().S("id", string(m.ID[:])).I("attempts", int(m.Attempts)).Info("partition requeue")
+			m.hold = false
+			m.nsqm.RequeueWithoutBackoff(m.requeueDelay)
+			return
+		}
+		time.Sleep(m.requeueDelay)
+		m.Attempts++
+	}
+}
+
+// close waits for workers to handle queued messages
+func (p *partitioner) close() {
+	if p == nil {
+		return
+	}
+	for _, w := range p.workers {
+		close(w)
+	}
+	p.wg.Wait()
+}
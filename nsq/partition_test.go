@@ -0,0 +1,104 @@
+package nsq_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/minus5/svckit/nsq"
+	"github.com/minus5/svckit/nsq/nsqtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func bodyKey(m *nsq.Message) string {
+	return strings.Split(string(m.Body), ":")[0]
+}
+
+func TestPartitioned(t *testing.T) {
+	n := nsqtest.Start(t)
+	defer func(d time.Duration) { nsq.RequeueDelay = d }(nsq.RequeueDelay)
+	nsq.RequeueDelay = 0
+
+	var (
+		mu       sync.Mutex
+		got      = make(map[string][]string)
+		inFlight int32
+		parallel int32
+		failed   = make(map[string]bool)
+	)
+	c := nsq.Sub("partition.test", func(m *nsq.Message) error {
+		if i := atomic.AddInt32(&inFlight, 1); i > 1 {
+			atomic.StoreInt32(&parallel, 1)
+		}
+		defer atomic.AddInt32(&inFlight, -1)
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		body := string(m.Body)
+		// every third message fails once, first with error then with requeue
+		if strings.HasSuffix(body, "0") && !failed[body] {
+			failed[body] = true
+			if strings.HasPrefix(body, "a") {
+				return errors.New("fail")
+			}
+			m.RequeueWithoutBackoff(0)
+			return nil
+		}
+		k := bodyKey(m)
+		got[k] = append(got[k], body)
+		return nil
+	}, nsq.Channel("test"), nsq.MaxInFlight(64), nsq.Partitioned(4, bodyKey))
+
+	var expected = make(map[string][]string)
+	for i := 0; i < 20; i++ {
+		for _, k := range []string{"a", "b", "c", "d"} {
+			body := fmt.Sprintf("%s:%d", k, i)
+			expected[k] = append(expected[k], body)
+			nsq.Pub("partition.test").MustPublish([]byte(body))
+		}
+	}
+	n.Wait()
+	c.Close()
+	assert.Equal(t, expected, got)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&parallel))
+}
+
+func TestPartitionedDeadLetter(t *testing.T) {
+	n := nsqtest.Start(t)
+	defer func(d time.Duration) { nsq.RequeueDelay = d }(nsq.RequeueDelay)
+	nsq.RequeueDelay = 0
+
+	var got []string
+	c := nsq.Sub("partition.dlq", func(m *nsq.Message) error {
+		if string(m.Body) == "a:poison" {
+			return errors.New("poison")
+		}
+		got = append(got, string(m.Body))
+		return nil
+	}, nsq.Channel("test"), nsq.MaxAttempts(3), nsq.Partitioned(1, bodyKey))
+	defer c.Close()
+
+	for _, b := range []string{"a:1", "a:poison", "a:2"} {
+		nsq.Pub("partition.dlq").MustPublish([]byte(b))
+	}
+	n.Wait()
+	assert.Equal(t, []string{"a:1", "a:2"}, got)
+	dls := n.Published(nsq.DeadLetterTopic("partition.dlq"))
+	if assert.Len(t, dls, 1) {
+		d, err := nsq.NewDeadLetter(dls[0])
+		assert.NoError(t, err)
+		assert.Equal(t, uint16(3), d.Attempts)
+		assert.Equal(t, "poison", d.Error)
+	}
+}
+
+func TestEnvelopeKey(t *testing.T) {
+	key := nsq.EnvelopeKey(func(e *nsq.Envelope) string { return e.CorrelationId })
+	e := &nsq.Envelope{CorrelationId: "42"}
+	assert.Equal(t, "42", key(&nsq.Message{Body: e.Bytes()}))
+	assert.Equal(t, "", key(&nsq.Message{Body: []byte("not json")}))
+}
@@ -9,6 +9,7 @@ import (
 type Producer struct {
 	topic       string
 	nsqProducer *gonsq.Producer
+	transport   Transport // used instead of nsqProducer when set
 }
 
 func MustNewProducer(topic string, opts ...func(*options)) *Producer {
@@ -20,6 +21,9 @@ func MustNewProducer(topic string, opts ...func(*options)) *Producer {
 }
 
 func NewProducer(topic string, opts ...func(*options)) (*Producer, error) {
+	if t := currentTransport(); t != nil {
+		return &Producer{transport: t, topic: topic}, nil
+	}
 	o := getDefaults().clone()
 	o.apply(opts...)
 
@@ -33,14 +37,20 @@ func NewProducer(topic string, opts ...func(*options)) (*Producer, error) {
 }
 
 func (p *Producer) Close() {
+	if p.transport != nil {
+		return
+	}
 	p.nsqProducer.Stop()
 }
 
 func (p *Producer) Publish(msg []byte) error {
-	return p.nsqProducer.Publish(p.topic, msg)
+	return p.PublishTo(p.topic, msg)
 }
 
 func (p *Producer) PublishTo(topic string, msg []byte) error {
+	if p.transport != nil {
+		return p.transport.Publish(topic, msg)
+	}
 	return p.nsqProducer.Publish(topic, msg)
 }
 
@@ -1,6 +1,7 @@
 package nsq
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/minus5/svckit/env"
 	"github.com/minus5/svckit/log"
+	"github.com/minus5/svckit/metric"
 )
 
 var (
@@ -26,18 +28,46 @@ type RrConsumer struct {
 	consumerOptions []func(*options)
 	requeueError    error // set this error to requeue only on this
 	// if nil requeues on all errors
+	handlers map[string]rrHandler // typed handlers registered with Handle
 	sync.Mutex
 }
 
+type rrHandler func(ctx context.Context, e *Envelope) (interface{}, error)
+
 // RrSub creates RrConsumer
 // topic   - nsq topic where reuqest arrive
 // handler - gets message type and body and creates response (or error)
 func RrSub(topic string, handler func(string, []byte) (interface{}, error), opts ...func(*RrConsumer)) *RrConsumer {
+	s := newRrConsumer(topic, opts...)
+	s.subscribe(func(_ context.Context, e *Envelope) (interface{}, error) {
+		return handler(e.Type, e.Body)
+	})
+	return s
+}
+
+// RrSubContext creates RrConsumer with handler which gets request envelope
+// and context which expires with the request. Pass context to the nested
+// calls (ReqRspContext, Call, jsonreq.Context) to propagate the deadline.
+// Response of the handler which exceeded request deadline is not sent.
+func RrSubContext(topic string, handler func(context.Context, *Envelope) (interface{}, error), opts ...func(*RrConsumer)) *RrConsumer {
+	s := newRrConsumer(topic, opts...)
+	s.subscribe(handler)
+	return s
+}
+
+func newRrConsumer(topic string, opts ...func(*RrConsumer)) *RrConsumer {
 	s := &RrConsumer{
 		topic:     topic,
 		producers: make(map[string]*Producer),
+		handlers:  make(map[string]rrHandler),
 	}
 	s.apply(opts...)
+	return s
+}
+
+// subscribe starts consuming requests,
+// handler context expires with the request envelope
+func (s *RrConsumer) subscribe(handler rrHandler) {
 	h := func(m *Message) error {
 		// zapakiraj poruku u envelope
 		eReq, err := NewEnvelope(m.Body)
@@ -47,13 +77,23 @@ func RrSub(topic string, handler func(string, []byte) (interface{}, error), opts
 		// provjeri da li je expired
 		if eReq.Expired() {
 			log.S("type", eReq.Type).S("correlationId", eReq.CorrelationId).I("now", int(time.Now().Unix())).I("expires_at", int(eReq.ExpiresAt)).Info("expired")
+			metric.Counter("rr.expired")
 			return nil
 		}
 		// radi request
-		rsp, handlerErr := handler(eReq.Type, eReq.Body)
+		ctx, cancel := eReq.Context(context.Background())
+		rsp, handlerErr := handler(ctx, eReq)
+		expired := ctx.Err() == context.DeadlineExceeded
+		cancel()
+		// requester ne ceka vise odgovor
+		if expired {
+			log.S("type", eReq.Type).S("correlationId", eReq.CorrelationId).Info("cancelled")
+			metric.Counter("rr.cancelled")
+			return nil
+		}
 		// ako je puklo vrati poruku u nsq
-		if handlerErr != nil && (s.requeueError == nil || handlerErr == s.requeueError) {
-			m.RequeueWithoutBackoff(RequeueDelay)
+		if handlerErr != nil && (s.requeueError == nil || errors.Is(handlerErr, s.requeueError)) {
+			m.requeue(RequeueDelay, handlerErr)
 			log.S("type", eReq.Type).S("correlationId", eReq.CorrelationId).Error(handlerErr)
 			return nil
 		}
@@ -75,8 +115,7 @@ func RrSub(topic string, handler func(string, []byte) (interface{}, error), opts
 		return nil
 	}
 	s.consumerOptions = append(s.consumerOptions, Channel(env.AppName()))
-	s.sub = Sub(topic, h, s.consumerOptions...)
-	return s
+	s.sub = Sub(s.topic, h, s.consumerOptions...)
 }
 
 // apply calls all functions to setup options
@@ -110,6 +149,7 @@ func RrAsyncSub(topic string, handler func(string, string, []byte) error) *RrCon
 		}
 		if eReq.Expired() {
 			log.S("type", eReq.Type).S("correlationId", eReq.CorrelationId).Info("expired")
+			metric.Counter("rr.expired")
 			return nil
 		}
 		correlationId := fmt.Sprintf("%s|%s|%s", eReq.Type, eReq.CorrelationId, eReq.ReplyTo)
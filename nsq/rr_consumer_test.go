@@ -0,0 +1,86 @@
+package nsq_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/minus5/svckit/nsq"
+	"github.com/minus5/svckit/nsq/nsqtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadlinePropagation(t *testing.T) {
+	nsqtest.Start(t)
+	var inner time.Time
+	s1 := nsq.RrSubContext("ctx.inner", func(ctx context.Context, e *nsq.Envelope) (interface{}, error) {
+		inner, _ = ctx.Deadline()
+		return "inner", nil
+	})
+	defer s1.Close()
+	// RrPub caches producers by topic, unique topic for each run
+	p := nsq.RrPub("ctx.rsp." + strconv.FormatInt(time.Now().UnixNano(), 10))
+	defer p.Close()
+	s2 := nsq.RrSubContext("ctx.outer", func(ctx context.Context, e *nsq.Envelope) (interface{}, error) {
+		var rsp string
+		err := p.ReqRspContext(ctx, "ctx.inner", "t", nil, &rsp, nil)
+		return rsp, err
+	})
+	defer s2.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	var rsp string
+	require.NoError(t, p.ReqRspContext(ctx, "ctx.outer", "t", nil, &rsp, nil))
+	assert.Equal(t, "inner", rsp)
+	assert.WithinDuration(t, deadline, inner, time.Millisecond)
+}
+
+func TestExpired(t *testing.T) {
+	n := nsqtest.Start(t)
+	var calls int
+	s := nsq.RrSubContext("ctx.expired", func(ctx context.Context, e *nsq.Envelope) (interface{}, error) {
+		calls++
+		<-ctx.Done()
+		return "late", nil
+	})
+	defer s.Close()
+
+	e := &nsq.Envelope{Type: "t", ReplyTo: "ctx.expired.rsp"}
+	e.SetDeadline(time.Now().Add(-time.Millisecond))
+	nsq.Pub("ctx.expired").MustPublish(e.Bytes())
+	n.Wait()
+	assert.Equal(t, 0, calls)
+
+	// handler exceeds deadline, response is not sent
+	e.SetDeadline(time.Now().Add(10 * time.Millisecond))
+	nsq.Pub("ctx.expired").MustPublish(e.Bytes())
+	n.Wait()
+	assert.Equal(t, 1, calls)
+	assert.Len(t, n.Published("ctx.expired.rsp"), 0)
+}
+
+func TestEnvelopeDeadline(t *testing.T) {
+	e := &nsq.Envelope{}
+	_, ok := e.ExpiresIn()
+	assert.False(t, ok)
+	assert.False(t, e.Expired())
+
+	d := time.Now().Add(1500 * time.Millisecond)
+	e.SetDeadline(d)
+	e2, err := nsq.NewEnvelope(e.Bytes())
+	require.NoError(t, err)
+	in, ok := e2.ExpiresIn()
+	assert.True(t, ok)
+	assert.WithinDuration(t, d, in, time.Millisecond)
+	assert.False(t, e2.Expired())
+
+	// old envelopes with second precision only
+	e3 := &nsq.Envelope{ExpiresAt: d.Unix()}
+	in, ok = e3.ExpiresIn()
+	assert.True(t, ok)
+	assert.Equal(t, d.Unix(), in.Unix())
+}
@@ -1,6 +1,7 @@
 package nsq
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -52,13 +53,18 @@ func RrPub(topic string, opts ...func(*RrProducer)) *RrProducer {
 	if s, ok := rrProducers[topic]; ok {
 		return s
 	}
+	s := newRrProducer(topic, opts...)
+	rrProducers[topic] = s
+	return s
+}
+
+func newRrProducer(topic string, opts ...func(*RrProducer)) *RrProducer {
 	s := &RrProducer{
 		msgNo:     rand.Intn(math.MaxInt32),
 		s:         make(map[string]chan *Envelope),
 		producers: make(map[string]*Producer),
 		topic:     topic,
 	}
-	rrProducers[topic] = s
 	// Set default calc of correlation id-a
 	s.apply(SetRrProducerCorrelation(s))
 	// Set all options
@@ -156,15 +162,30 @@ type ErrorsMapping struct {
 // ttl   - time to live of message for envelope
 // em    - error mapping, mapping to application specific messages
 func (s *RrProducer) ReqRsp(topic, typ string, req interface{}, rsp interface{}, sig chan struct{}, ttl time.Duration, em *ErrorsMapping) error {
-	if typ == "" {
-		typ = typeToString(req)
-	}
-	p := ReqRspBaseParams{
+	return s.reqRspJSON(context.Background(), ReqRspBaseParams{
 		Topic: topic,
 		Typ:   typ,
 		Ttl:   ttl,
 		Sig:   sig,
 		Em:    em,
+	}, req, rsp)
+}
+
+// ReqRspContext is ReqRsp which waits until ctx is done.
+// Request expires with ctx deadline, so the deadline of the request
+// being handled is propagated when called with the handler context.
+func (s *RrProducer) ReqRspContext(ctx context.Context, topic, typ string, req interface{}, rsp interface{}, em *ErrorsMapping) error {
+	return s.reqRspJSON(ctx, ReqRspBaseParams{
+		Topic: topic,
+		Typ:   typ,
+		Em:    em,
+	}, req, rsp)
+}
+
+// reqRspJSON marshals req and unmarshals response into rsp
+func (s *RrProducer) reqRspJSON(ctx context.Context, p ReqRspBaseParams, req interface{}, rsp interface{}) error {
+	if p.Typ == "" {
+		p.Typ = typeToString(req)
 	}
 	p.defaults()
 	reqBuf, err := json.Marshal(req)
@@ -172,8 +193,8 @@ func (s *RrProducer) ReqRsp(topic, typ string, req interface{}, rsp interface{},
 		return p.Fatal(err)
 	}
 	p.Req = reqBuf
-	p.correlationId = s.corr.NewCorrelationID(topic, typ, req)
-	rspBuf, err := s.ReqRspBase(p)
+	p.correlationId = s.corr.NewCorrelationID(p.Topic, p.Typ, req)
+	rspBuf, err := s.reqRsp(ctx, p)
 	if err != nil {
 		return err
 	}
@@ -259,7 +280,21 @@ func (p *ReqRspBaseParams) Error(text string) error {
 }
 
 func (s *RrProducer) ReqRspBase(p ReqRspBaseParams) ([]byte, error) {
+	return s.reqRsp(context.Background(), p)
+}
+
+// reqRsp sends request and waits for response until ttl, Sig or ctx is done.
+// Request expires with ttl or ctx deadline, whichever comes first.
+func (s *RrProducer) reqRsp(ctx context.Context, p ReqRspBaseParams) ([]byte, error) {
 	p.defaults()
+	deadline := time.Now().Add(p.Ttl)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+		p.Ttl = time.Until(d)
+		if p.Ttl <= 0 {
+			return nil, p.Timeout()
+		}
+	}
 	if p.correlationId == "" {
 		p.correlationId = s.NewCorrelationID("", "", nil)
 	}
@@ -269,8 +304,8 @@ func (s *RrProducer) ReqRspBase(p ReqRspBaseParams) ([]byte, error) {
 		ReplyTo:       s.topic,
 		CorrelationId: p.correlationId,
 		Body:          p.Req,
-		ExpiresAt:     time.Now().Add(p.Ttl).Unix(),
 	}
+	eReq.SetDeadline(deadline)
 	c := make(chan *Envelope)
 	s.add(p.correlationId, c)
 
@@ -289,6 +324,12 @@ func (s *RrProducer) ReqRspBase(p ReqRspBaseParams) ([]byte, error) {
 	case <-p.Sig:
 		s.timeout(p.correlationId)
 		return nil, p.Stopped()
+	case <-ctx.Done():
+		s.timeout(p.correlationId)
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, p.Timeout()
+		}
+		return nil, p.Stopped()
 	}
 	return nil, nil
 }
@@ -339,13 +380,19 @@ func (s *RrProducer) listen() {
 		log.S("id", e.CorrelationId).Info("subscriber not found")
 		return nil
 	}
-	s.sub = Sub(s.topic, handler, s.consumerOptions...)
+	sub := Sub(s.topic, handler, s.consumerOptions...)
+	s.Lock()
+	s.sub = sub
+	s.Unlock()
 }
 
 // Close implements gracefully stop.
 func (s *RrProducer) Close() {
-	if s.sub != nil {
-		s.sub.Close()
+	s.Lock()
+	sub := s.sub
+	s.Unlock()
+	if sub != nil {
+		sub.Close()
 	}
 	for _, p := range s.producers {
 		p.Close()
@@ -0,0 +1,52 @@
+package nsq
+
+import (
+	"time"
+
+	gonsq "github.com/nsqio/go-nsq"
+)
+
+// Transport is alternative to nsqd used by Producer and Consumer,
+// nsqtest implements it in memory for tests.
+type Transport interface {
+	// Publish publishes message to the topic.
+	Publish(topic string, body []byte) error
+	// Subscribe starts delivering messages from the topic channel to the handler.
+	// maxAttempts is go-nsq Config.MaxAttempts of the consumer,
+	// 0 when failed messages are not dropped (dead letter topic).
+	// Returned function stops delivering and waits for handlers in progress.
+	Subscribe(topic, channel string, maxInFlight, concurrency int, maxAttempts uint16, handler func(*Message) error) (func(), error)
+}
+
+// MessageDelegate handles message requeue and touch, implemented by Transport messages.
+type MessageDelegate interface {
+	RequeueWithoutBackoff(delay time.Duration)
+	Touch()
+}
+
+var transport Transport
+
+// SetTransport switches all Producers and Consumers created after the call to
+// transport t. Nil switches back to nsqd.
+func SetTransport(t Transport) {
+	initMu.Lock()
+	defer initMu.Unlock()
+	transport = t
+}
+
+func currentTransport() Transport {
+	initMu.Lock()
+	defer initMu.Unlock()
+	return transport
+}
+
+// NewMessage creates message delivered by Transport.
+func NewMessage(id gonsq.MessageID, body []byte, attempts uint16, d MessageDelegate) *Message {
+	return &Message{
+		nsqm:      d,
+		ID:        id,
+		Body:      body,
+		Timestamp: time.Now().UnixNano(),
+		Attempts:  attempts,
+	}
+}
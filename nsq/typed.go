@@ -0,0 +1,174 @@
+package nsq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/minus5/svckit/env"
+	"github.com/minus5/svckit/log"
+)
+
+var (
+	// ErrRequeue returned from the Handle handler requeues request.
+	ErrRequeue = errors.New("requeue")
+	// ErrUnknownType is returned to the caller when there is no handler for the request type.
+	ErrUnknownType = errors.New("unknown type")
+
+	typedErrors = map[string]error{ErrUnknownType.Error(): ErrUnknownType}
+	typedMu     sync.Mutex
+	caller      *RrProducer
+	callerOf    Transport // transport of the caller, new one is created when it changes
+)
+
+// RemoteError is error returned by the remote handler which is not registered with RegisterErrors.
+type RemoteError string
+
+func (e RemoteError) Error() string {
+	return string(e)
+}
+
+// RegisterErrors registers errors which are passed from Handle handlers to
+// the Call callers. Caller gets the same error value so it can be
+// checked with errors.Is. Errors are matched by text, register them on both sides.
+func RegisterErrors(errs ...error) {
+	typedMu.Lock()
+	defer typedMu.Unlock()
+	for _, err := range errs {
+		typedErrors[err.Error()] = err
+	}
+}
+
+// parseTypedError maps Envelope.Error text to the registered error
+func parseTypedError(s string) error {
+	if s == "" {
+		return nil
+	}
+	typedMu.Lock()
+	defer typedMu.Unlock()
+	if err, ok := typedErrors[s]; ok {
+		return err
+	}
+	return RemoteError(s)
+}
+
+// typedError unwraps handler error to the registered one
+func typedError(err error) error {
+	typedMu.Lock()
+	defer typedMu.Unlock()
+	for _, te := range typedErrors {
+		if errors.Is(err, te) {
+			return te
+		}
+	}
+	return err
+}
+
+// TypeName returns request type name used by Call and Handle.
+func TypeName[T any]() string {
+	return strings.TrimPrefix(reflect.TypeOf((*T)(nil)).Elem().String(), "*")
+}
+
+// Handle registers typed handler on RrConsumer created with RrServe.
+// Empty name registers handler for TypeName[Req].
+// Handler context expires with the request.
+// Handler errors are returned to the caller, except ErrRequeue which requeues request.
+func Handle[Req, Rsp any](name string, fn func(context.Context, Req) (Rsp, error)) func(*RrConsumer) {
+	if name == "" {
+		name = TypeName[Req]()
+	}
+	return func(s *RrConsumer) {
+		s.handlers[name] = func(ctx context.Context, e *Envelope) (interface{}, error) {
+			var req Req
+			if err := json.Unmarshal(e.Body, &req); err != nil {
+				return nil, err
+			}
+			rsp, err := fn(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			return rsp, nil
+		}
+	}
+}
+
+// RrServe creates RrConsumer which dispatches requests to the handlers registered with Handle:
+//
+//	s := nsq.RrServe("math.req", nsq.Handle("add", add), nsq.Handle("", mul))
+func RrServe(topic string, opts ...func(*RrConsumer)) *RrConsumer {
+	s := newRrConsumer(topic, append([]func(*RrConsumer){RequeueError(ErrRequeue)}, opts...)...)
+	s.subscribe(s.dispatch)
+	return s
+}
+
+func (s *RrConsumer) dispatch(ctx context.Context, e *Envelope) (interface{}, error) {
+	h, ok := s.handlers[e.Type]
+	if !ok {
+		log.S("topic", s.topic).S("type", e.Type).Info("unknown type")
+		return nil, ErrUnknownType
+	}
+	rsp, err := h(ctx, e)
+	if err != nil && !errors.Is(err, ErrRequeue) {
+		err = typedError(err)
+	}
+	return rsp, err
+}
+
+// Call sends typed request to the topic and waits for response.
+// Request type is TypeName[Req], use CallType for the other names.
+// Waits until ctx is done, ctx deadline is request expiration.
+// Without ctx deadline request expires after DefaultTimeout.
+// Returns ErrTimeout when deadline is exceeded and context.Canceled when ctx is canceled.
+// Remote errors registered with RegisterErrors are returned as registered values,
+// others as RemoteError.
+func Call[Req, Rsp any](ctx context.Context, topic string, req Req) (Rsp, error) {
+	return CallType[Req, Rsp](ctx, topic, TypeName[Req](), req)
+}
+
+// CallType is Call with explicit request type name.
+func CallType[Req, Rsp any](ctx context.Context, topic, typ string, req Req) (Rsp, error) {
+	var rsp Rsp
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return rsp, err
+	}
+	rspBuf, err := defaultCaller().reqRsp(ctx, ReqRspBaseParams{
+		Topic: topic,
+		Typ:   typ,
+		Req:   buf,
+		Em: &ErrorsMapping{
+			Parser:     parseTypedError,
+			ErrStopped: context.Canceled,
+			ErrTimeout: ErrTimeout,
+		},
+	})
+	if err != nil {
+		return rsp, err
+	}
+	if len(rspBuf) > 0 {
+		if err := json.Unmarshal(rspBuf, &rsp); err != nil {
+			return rsp, err
+		}
+	}
+	return rsp, nil
+}
+
+// defaultCaller returns RrProducer used by Call
+func defaultCaller() *RrProducer {
+	typedMu.Lock()
+	defer typedMu.Unlock()
+	t := currentTransport()
+	if caller != nil && callerOf == t {
+		return caller
+	}
+	if caller != nil {
+		caller.Close()
+	}
+	caller = newRrProducer(fmt.Sprintf("z...rsp.%s.%s.call", env.AppName(), env.InstanceId()))
+	callerOf = t
+	return caller
+}
@@ -0,0 +1,87 @@
+package nsq_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/minus5/svckit/nsq"
+	"github.com/minus5/svckit/nsq/nsqtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type addReq struct {
+	X, Y int
+}
+
+type addRsp struct {
+	Z int
+}
+
+var errOverflow = errors.New("overflow")
+
+func init() {
+	nsq.RegisterErrors(errOverflow)
+}
+
+func TestTypedCall(t *testing.T) {
+	nsqtest.Start(t)
+	s := nsq.RrServe("typed.req",
+		nsq.Handle("", func(ctx context.Context, req addReq) (addRsp, error) {
+			if _, ok := ctx.Deadline(); !ok {
+				return addRsp{}, errors.New("no deadline")
+			}
+			if req.X > 100 {
+				return addRsp{}, fmt.Errorf("x: %w", errOverflow)
+			}
+			if req.X < 0 {
+				return addRsp{}, errors.New("negative")
+			}
+			return addRsp{Z: req.X + req.Y}, nil
+		}),
+		nsq.Handle("echo", func(ctx context.Context, req string) (string, error) {
+			return req, nil
+		}),
+	)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Equal(t, "nsq_test.addReq", nsq.TypeName[*addReq]())
+
+	rsp, err := nsq.Call[addReq, addRsp](ctx, "typed.req", addReq{X: 1, Y: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 3, rsp.Z)
+
+	_, err = nsq.Call[addReq, addRsp](ctx, "typed.req", addReq{X: 101})
+	assert.True(t, errors.Is(err, errOverflow))
+
+	_, err = nsq.Call[addReq, addRsp](ctx, "typed.req", addReq{X: -1})
+	var re nsq.RemoteError
+	require.True(t, errors.As(err, &re))
+	assert.Equal(t, "negative", re.Error())
+
+	echo, err := nsq.CallType[string, string](ctx, "typed.req", "echo", "ping")
+	require.NoError(t, err)
+	assert.Equal(t, "ping", echo)
+
+	_, err = nsq.CallType[string, string](ctx, "typed.req", "unknown", "ping")
+	assert.Equal(t, nsq.ErrUnknownType, err)
+}
+
+func TestTypedCallContext(t *testing.T) {
+	nsqtest.Start(t)
+	// no consumer for the topic
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := nsq.Call[addReq, addRsp](ctx, "typed.none", addReq{})
+	assert.Equal(t, nsq.ErrTimeout, err)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = nsq.Call[addReq, addRsp](ctx, "typed.none", addReq{})
+	assert.Equal(t, context.Canceled, err)
+}
@@ -173,12 +173,10 @@ func JsonMerge(m map[string]interface{}, d map[string]interface{}) {
 	for k, v := range d {
 		switch v.(type) {
 		case map[string]interface{}:
-			if m[k] == nil {
-				m[k] = d[k]
+			if im, ok := m[k].(map[string]interface{}); ok {
+				JsonMerge(im, d[k].(map[string]interface{}))
 			} else {
-				if im, ok := m[k].(map[string]interface{}); ok {
-					JsonMerge(im, d[k].(map[string]interface{}))
-				}
+				m[k] = d[k]
 			}
 		case nil:
 			delete(m, k)
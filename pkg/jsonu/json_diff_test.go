@@ -197,6 +197,12 @@ func TestJsonMerge(t *testing.T) {
 	}
 }
 
+func TestJsonMergeReplaceValue(t *testing.T) {
+	m := map[string]interface{}{"k": "v", "o": map[string]interface{}{"k": 1}}
+	JsonMerge(m, map[string]interface{}{"k": map[string]interface{}{"k": 2}, "o": "v"})
+	assert.Equal(t, map[string]interface{}{"k": map[string]interface{}{"k": 2}, "o": "v"}, m)
+}
+
 func TestDeepCopyMap(t *testing.T) {
 	s := `{"o1":{"k1":"v1","k2":"v2"},"o2":{"k3":"v3"}}`
 	var m map[string]interface{}